@@ -17,6 +17,7 @@ type AggregateResult struct {
 	reduction []reflect.Value // always pointers
 	group     []reflect.Value
 	sortby    string
+	store     *Store
 }
 
 // Group returns the field grouped by in the query
@@ -76,7 +77,13 @@ func (a *aggregateResultSort) Less(i, j int) bool {
 		panic(err)
 	}
 
-	c, err := compare(iVal, jVal)
+	iVal, jVal = derefValue(iVal), derefValue(jVal)
+	if iVal == nil || jVal == nil {
+		// nil values sort after every other value
+		return iVal != nil
+	}
+
+	c, err := a.store.compare(iVal, jVal)
 	if err != nil {
 		panic(err)
 	}
@@ -84,8 +91,8 @@ func (a *aggregateResultSort) Less(i, j int) bool {
 	return c == -1
 }
 
-// Sort sorts the aggregate reduction by the passed in field in ascending order
-// Sort is called automatically by calls to Min / Max to get the min and max values
+// Sort sorts the aggregate reduction by the passed in field in ascending order, records where the field is nil are
+// sorted last.  Sort is called automatically by calls to Min / Max to get the min and max values
 func (a *AggregateResult) Sort(field string) {
 	if !startsUpper(field) {
 		panic("The first letter of a field must be upper-case")
@@ -99,7 +106,8 @@ func (a *AggregateResult) Sort(field string) {
 	sort.Sort((*aggregateResultSort)(a))
 }
 
-// Max Returns the maxiumum value of the Aggregate Grouping, uses the Comparer interface
+// Max Returns the maxiumum value of the Aggregate Grouping, uses the Comparer interface.  Records where the field
+// is nil are skipped, and if the field is nil in every record, result is left unchanged
 func (a *AggregateResult) Max(field string, result interface{}) {
 	a.Sort(field)
 
@@ -112,10 +120,16 @@ func (a *AggregateResult) Max(field string, result interface{}) {
 		panic("result argument must not be nil")
 	}
 
-	resultVal.Elem().Set(a.reduction[len(a.reduction)-1].Elem())
+	for i := len(a.reduction) - 1; i >= 0; i-- {
+		if !a.fieldIsNil(i, field) {
+			resultVal.Elem().Set(a.reduction[i].Elem())
+			return
+		}
+	}
 }
 
-// Min returns the minimum value of the Aggregate Grouping, uses the Comparer interface
+// Min returns the minimum value of the Aggregate Grouping, uses the Comparer interface.  Records where the field
+// is nil are skipped, and if the field is nil in every record, result is left unchanged
 func (a *AggregateResult) Min(field string, result interface{}) {
 	a.Sort(field)
 
@@ -128,7 +142,19 @@ func (a *AggregateResult) Min(field string, result interface{}) {
 		panic("result argument must not be nil")
 	}
 
-	resultVal.Elem().Set(a.reduction[0].Elem())
+	// nil values are sorted last, so if the first value is nil, they all are
+	if len(a.reduction) > 0 && !a.fieldIsNil(0, field) {
+		resultVal.Elem().Set(a.reduction[0].Elem())
+	}
+}
+
+// fieldIsNil returns whether the field of the reduction record at i is nil
+func (a *AggregateResult) fieldIsNil(i int, field string) bool {
+	value, err := fieldValue(a.reduction[i].Elem(), field)
+	if err != nil {
+		panic(err)
+	}
+	return isNilValue(value)
 }
 
 // Avg returns the average float value of the aggregate grouping
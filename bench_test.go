@@ -224,3 +224,20 @@ func BenchmarkFindIndexed(b *testing.B) {
 		}
 	})
 }
+
+// every record has the same indexed value
+func BenchmarkIndexedInsertLowCardinality(b *testing.B) {
+	benchWrap(b, nil, func(store *bolthold.Store, b *testing.B) {
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			err := store.Insert(id(), BenchDataIndexed{
+				ID:       i,
+				Category: "test category",
+			})
+			if err != nil {
+				b.Fatalf("Error inserting into store: %s", err)
+			}
+		}
+	})
+}
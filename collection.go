@@ -0,0 +1,298 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold
+
+import (
+	"fmt"
+	"reflect"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Collection is a type safe view of the records of type T in a Store.  T must be a struct type, and the records are
+// stored exactly the same as they are with the Store's methods, so the two can be used side by side.
+/*
+Collection Example
+
+	items := bolthold.NewCollection[Item](store)
+
+	err := items.Insert(1234, &Item{Name: "Test Name"})
+
+	result, err := items.Find(bolthold.Where("Name").Eq("Test Name"))
+*/
+type Collection[T interface{}] struct {
+	store    *Store
+	keyField string
+}
+
+// NewCollection returns a Collection of the records of type T in the store.
+// NewCollection will panic if T isn't a struct type
+func NewCollection[T interface{}](store *Store) *Collection[T] {
+	tp := reflect.TypeOf(new(T)).Elem()
+	if tp.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("Collection type must be a struct, not %s", tp))
+	}
+
+	c := &Collection[T]{
+		store: store,
+	}
+
+	if field, ok := keyField(tp); ok {
+		c.keyField = field.Name
+	}
+
+	return c
+}
+
+// Store returns the Store the collection's records are stored in
+func (c *Collection[T]) Store() *Store {
+	return c.store
+}
+
+// Get returns the record with the passed in key, or ErrNotFound
+func (c *Collection[T]) Get(key interface{}) (T, error) {
+	var result T
+	err := c.store.Bolt().View(func(tx *bolt.Tx) error {
+		var txErr error
+		result, txErr = c.TxGet(tx, key)
+		return txErr
+	})
+	return result, err
+}
+
+// TxGet is the same as Get but you get to specify your transaction
+func (c *Collection[T]) TxGet(tx *bolt.Tx, key interface{}) (T, error) {
+	var result T
+	err := c.store.get(tx, key, &result)
+	return result, err
+}
+
+// Find returns the records that match the query
+func (c *Collection[T]) Find(query *Query) ([]T, error) {
+	var result []T
+	err := c.store.Bolt().View(func(tx *bolt.Tx) error {
+		var txErr error
+		result, txErr = c.TxFind(tx, query)
+		return txErr
+	})
+	return result, err
+}
+
+// TxFind is the same as Find but you get to specify your transaction
+func (c *Collection[T]) TxFind(tx *bolt.Tx, query *Query) ([]T, error) {
+	var result []T
+	err := c.store.findQuery(tx, &result, query)
+	return result, err
+}
+
+// FindOne returns the first record that matches the query, or ErrNotFound
+func (c *Collection[T]) FindOne(query *Query) (T, error) {
+	var result T
+	err := c.store.Bolt().View(func(tx *bolt.Tx) error {
+		var txErr error
+		result, txErr = c.TxFindOne(tx, query)
+		return txErr
+	})
+	return result, err
+}
+
+// TxFindOne is the same as FindOne but you get to specify your transaction
+func (c *Collection[T]) TxFindOne(tx *bolt.Tx, query *Query) (T, error) {
+	var result T
+	err := c.store.findOneQuery(tx, &result, query)
+	return result, err
+}
+
+// Count returns the number of records that match the query
+func (c *Collection[T]) Count(query *Query) (int, error) {
+	return c.store.Count(new(T), query)
+}
+
+// TxCount is the same as Count but you get to specify your transaction
+func (c *Collection[T]) TxCount(tx *bolt.Tx, query *Query) (int, error) {
+	return c.store.countQuery(tx, new(T), query)
+}
+
+// ForEach runs fn against every record that matches the query.  Returning an error from fn stops the iteration
+func (c *Collection[T]) ForEach(query *Query, fn func(record *T) error) error {
+	return c.store.Bolt().View(func(tx *bolt.Tx) error {
+		return c.TxForEach(tx, query, fn)
+	})
+}
+
+// TxForEach is the same as ForEach but you get to specify your transaction
+func (c *Collection[T]) TxForEach(tx *bolt.Tx, query *Query, fn func(record *T) error) error {
+	if query == nil {
+		query = &Query{}
+	}
+
+	return c.store.runQuery(tx, new(T), query, nil, query.skip, func(r *record) error {
+		projected, err := query.project(r.value)
+		if err != nil {
+			return err
+		}
+
+		value := projected.Interface().(*T)
+		err = c.setKey(r.key, value)
+		if err != nil {
+			return err
+		}
+		return fn(value)
+	})
+}
+
+// Insert inserts the record into the store, and returns ErrKeyExists if a record with the key already exists
+func (c *Collection[T]) Insert(key interface{}, data *T) error {
+	return c.store.Insert(key, data)
+}
+
+// TxInsert is the same as Insert but you get to specify your transaction
+func (c *Collection[T]) TxInsert(tx *bolt.Tx, key interface{}, data *T) error {
+	return c.store.TxInsert(tx, key, data)
+}
+
+// Update updates the existing record with the key, and returns ErrNotFound if it doesn't exist
+func (c *Collection[T]) Update(key interface{}, data *T) error {
+	return c.store.Update(key, data)
+}
+
+// TxUpdate is the same as Update but you get to specify your transaction
+func (c *Collection[T]) TxUpdate(tx *bolt.Tx, key interface{}, data *T) error {
+	return c.store.TxUpdate(tx, key, data)
+}
+
+// Upsert inserts the record if it doesn't exist, or updates it if it does
+func (c *Collection[T]) Upsert(key interface{}, data *T) error {
+	return c.store.Upsert(key, data)
+}
+
+// TxUpsert is the same as Upsert but you get to specify your transaction
+func (c *Collection[T]) TxUpsert(tx *bolt.Tx, key interface{}, data *T) error {
+	return c.store.TxUpsert(tx, key, data)
+}
+
+// Delete deletes the record with the key, and returns ErrNotFound if it doesn't exist
+func (c *Collection[T]) Delete(key interface{}) error {
+	return c.store.Delete(key, new(T))
+}
+
+// TxDelete is the same as Delete but you get to specify your transaction
+func (c *Collection[T]) TxDelete(tx *bolt.Tx, key interface{}) error {
+	return c.store.TxDelete(tx, key, new(T))
+}
+
+// DeleteMatching deletes all of the records that match the query
+func (c *Collection[T]) DeleteMatching(query *Query) error {
+	return c.store.DeleteMatching(new(T), query)
+}
+
+// TxDeleteMatching is the same as DeleteMatching but you get to specify your transaction
+func (c *Collection[T]) TxDeleteMatching(tx *bolt.Tx, query *Query) error {
+	return c.store.TxDeleteMatching(tx, new(T), query)
+}
+
+// UpdateMatching runs the update function for every record that matches the query, and stores the updated records
+func (c *Collection[T]) UpdateMatching(query *Query, update func(record *T) error) error {
+	return c.store.Bolt().Update(func(tx *bolt.Tx) error {
+		return c.TxUpdateMatching(tx, query, update)
+	})
+}
+
+// TxUpdateMatching is the same as UpdateMatching but you get to specify your transaction
+func (c *Collection[T]) TxUpdateMatching(tx *bolt.Tx, query *Query, update func(record *T) error) error {
+	return c.store.updateQuery(tx, new(T), query, func(record interface{}) error {
+		return update(record.(*T))
+	})
+}
+
+// FindAggregate returns an aggregate grouping of the records that match the query
+// groupBy is optional
+func (c *Collection[T]) FindAggregate(query *Query, groupBy ...string) ([]*Aggregate[T], error) {
+	var result []*Aggregate[T]
+	err := c.store.Bolt().View(func(tx *bolt.Tx) error {
+		var txErr error
+		result, txErr = c.TxFindAggregate(tx, query, groupBy...)
+		return txErr
+	})
+	return result, err
+}
+
+// TxFindAggregate is the same as FindAggregate but you get to specify your transaction
+func (c *Collection[T]) TxFindAggregate(tx *bolt.Tx, query *Query, groupBy ...string) ([]*Aggregate[T], error) {
+	results, err := c.store.aggregateQuery(tx, new(T), query, groupBy...)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregates := make([]*Aggregate[T], len(results))
+	for i := range results {
+		aggregates[i] = &Aggregate[T]{result: results[i]}
+	}
+
+	return aggregates, nil
+}
+
+// setKey sets the key field of the record, if T has one
+func (c *Collection[T]) setKey(key []byte, value *T) error {
+	if c.keyField == "" {
+		return nil
+	}
+	return c.store.decode(key, reflect.ValueOf(value).Elem().FieldByName(c.keyField).Addr().Interface())
+}
+
+// Aggregate is a type safe AggregateResult of the records of type T
+type Aggregate[T interface{}] struct {
+	result *AggregateResult
+}
+
+// Group sets the values the records were grouped by into result, in the order they were passed to FindAggregate
+func (a *Aggregate[T]) Group(result ...interface{}) {
+	a.result.Group(result...)
+}
+
+// Reduction returns the records in the group
+func (a *Aggregate[T]) Reduction() []T {
+	records := make([]T, len(a.result.reduction))
+	for i := range a.result.reduction {
+		records[i] = *a.result.reduction[i].Interface().(*T)
+	}
+	return records
+}
+
+// Sort sorts the records in the group by the passed in field in ascending order
+func (a *Aggregate[T]) Sort(field string) {
+	a.result.Sort(field)
+}
+
+// Min returns the record in the group with the smallest value in the field
+func (a *Aggregate[T]) Min(field string) T {
+	var result T
+	a.result.Min(field, &result)
+	return result
+}
+
+// Max returns the record in the group with the largest value in the field
+func (a *Aggregate[T]) Max(field string) T {
+	var result T
+	a.result.Max(field, &result)
+	return result
+}
+
+// Avg returns the average value of the field in the group
+// panics if the field cannot be converted to an float64
+func (a *Aggregate[T]) Avg(field string) float64 {
+	return a.result.Avg(field)
+}
+
+// Sum returns the sum of the field in the group
+// panics if the field cannot be converted to an float64
+func (a *Aggregate[T]) Sum(field string) float64 {
+	return a.result.Sum(field)
+}
+
+// Count returns the number of records in the group
+func (a *Aggregate[T]) Count() int {
+	return a.result.Count()
+}
@@ -0,0 +1,143 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"testing"
+
+	"github.com/timshannon/bolthold"
+)
+
+func TestCollection(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPageData(t, store)
+		items := bolthold.NewCollection[PageItem](store)
+
+		t.Run("Get", func(t *testing.T) {
+			item, err := items.Get(12)
+			ok(t, err)
+			equals(t, PageItem{ID: 12, Group: "group5", Score: 2}, item)
+
+			_, err = items.Get(1000)
+			equals(t, bolthold.ErrNotFound, err)
+		})
+
+		t.Run("Find", func(t *testing.T) {
+			query := func() *bolthold.Query { return bolthold.Where("Group").Eq("group3").SortBy("Score") }
+
+			var expected []PageItem
+			ok(t, store.Find(&expected, query()))
+
+			result, err := items.Find(query())
+			ok(t, err)
+			equals(t, expected, result)
+		})
+
+		t.Run("FindOne", func(t *testing.T) {
+			item, err := items.FindOne(bolthold.Where("Score").Eq(4).And("Group").Eq("group4"))
+			ok(t, err)
+			equals(t, 4, item.ID)
+
+			_, err = items.FindOne(bolthold.Where("Score").Eq(100))
+			equals(t, bolthold.ErrNotFound, err)
+		})
+
+		t.Run("ForEach", func(t *testing.T) {
+			var ids []int
+			ok(t, items.ForEach(bolthold.Where("Group").Eq("group6"), func(record *PageItem) error {
+				ids = append(ids, record.ID)
+				return nil
+			}))
+
+			count, err := items.Count(bolthold.Where("Group").Eq("group6"))
+			ok(t, err)
+			equals(t, count, len(ids))
+
+			for i := range ids {
+				equals(t, 6, ids[i]%7)
+			}
+		})
+
+		t.Run("Aggregate", func(t *testing.T) {
+			groups, err := items.FindAggregate(bolthold.Where("Score").Lt(5), "Group")
+			ok(t, err)
+			equals(t, 7, len(groups))
+
+			var group string
+			groups[0].Group(&group)
+			equals(t, "group0", group)
+
+			reduction := groups[0].Reduction()
+			equals(t, groups[0].Count(), len(reduction))
+
+			equals(t, 0, groups[0].Min("Score").Score)
+			equals(t, 4, groups[0].Max("Score").Score)
+
+			sum := 0
+			for i := range reduction {
+				sum += reduction[i].Score
+			}
+			equals(t, float64(sum), groups[0].Sum("Score"))
+			equals(t, float64(sum)/float64(len(reduction)), groups[0].Avg("Score"))
+		})
+	})
+}
+
+func TestCollectionWrites(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPageData(t, store)
+		items := bolthold.NewCollection[PageItem](store)
+
+		ok(t, items.Insert(100, &PageItem{Group: "new", Score: 1}))
+		equals(t, bolthold.ErrKeyExists, items.Insert(100, &PageItem{}))
+
+		ok(t, items.Update(100, &PageItem{Group: "new", Score: 2}))
+		ok(t, items.Upsert(101, &PageItem{Group: "new", Score: 3}))
+
+		ok(t, items.UpdateMatching(bolthold.Where("Group").Eq("new"), func(record *PageItem) error {
+			record.Score *= 10
+			return nil
+		}))
+
+		result, err := items.Find(bolthold.Where("Group").Eq("new"))
+		ok(t, err)
+		equals(t, []PageItem{{ID: 100, Group: "new", Score: 20}, {ID: 101, Group: "new", Score: 30}}, result)
+
+		ok(t, items.Delete(100))
+		ok(t, items.DeleteMatching(bolthold.Where("Group").Eq("group0")))
+
+		count, err := items.Count(nil)
+		ok(t, err)
+		equals(t, 95+1-14, count)
+	})
+}
+
+func TestCollectionNotStruct(t *testing.T) {
+	defer func() {
+		assert(t, recover() != nil, "Collection of a pointer type didn't panic")
+	}()
+
+	bolthold.NewCollection[*ItemTest](nil)
+}
+
+type TaggedKeyItem struct {
+	Name string `json:"boltholdKey"`
+	ID   int    `boltholdKey:"ID"`
+}
+
+func TestCollectionKeyTag(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		items := bolthold.NewCollection[TaggedKeyItem](store)
+		ok(t, items.Insert(7, &TaggedKeyItem{Name: "seven"}))
+
+		item, err := items.Get(7)
+		ok(t, err)
+		equals(t, TaggedKeyItem{Name: "seven", ID: 7}, item)
+
+		result, err := items.Find(nil)
+		ok(t, err)
+		equals(t, []TaggedKeyItem{{Name: "seven", ID: 7}}, result)
+	})
+}
@@ -5,7 +5,9 @@
 package bolthold
 
 import (
+	"bytes"
 	"fmt"
+	"math"
 	"math/big"
 	"reflect"
 	"time"
@@ -31,18 +33,7 @@ type Comparer interface {
 	Compare(other interface{}) (int, error)
 }
 
-func (c *Criterion) compare(rowValue, criterionValue interface{}, currentRow interface{}) (int, error) {
-	if reflect.TypeOf(rowValue) == reflect.TypeOf(reflect.Value{}) {
-		rowValue = rowValue.(reflect.Value).Interface()
-	}
-
-	if rowValue == nil || criterionValue == nil {
-		if rowValue == criterionValue {
-			return 0, nil
-		}
-		return 0, &ErrTypeMismatch{rowValue, criterionValue}
-	}
-
+func (c *Criterion) compare(s *Store, rowValue, criterionValue interface{}, currentRow interface{}) (int, error) {
 	if _, ok := criterionValue.(Field); ok {
 		fVal := reflect.ValueOf(currentRow).Elem().FieldByName(string(criterionValue.(Field)))
 		if !fVal.IsValid() {
@@ -53,20 +44,223 @@ func (c *Criterion) compare(rowValue, criterionValue interface{}, currentRow int
 		criterionValue = fVal.Interface()
 	}
 
-	value := rowValue
+	value := derefValue(rowValue)
+	other := derefValue(criterionValue)
+
+	if value == nil || other == nil {
+		if value == other {
+			return 0, nil
+		}
+		return 0, &ErrTypeMismatch{value, other}
+	}
+
+	return s.compareValues(value, other, c.promotesNumbers(s))
+}
+
+// promotesNumbers returns whether numbers of different types are compared by their value, either because the store
+// has NumericPromotion set, or because the criterion's values were parsed from query text
+func (c *Criterion) promotesNumbers(s *Store) bool {
+	return s.numericPromotion || c.literal
+}
+
+// isNumber returns whether the type is an int, uint or float type
+func isNumber(tp reflect.Type) bool {
+	switch tp.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// derefValue returns the value the passed in value points to, or nil if the value, or any pointer to it, is nil.
+// reflect.Values are replaced by the value they hold
+func derefValue(value interface{}) interface{} {
+	v, ok := value.(reflect.Value)
+	if !ok {
+		v = reflect.ValueOf(value)
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// isNilValue returns whether the value is nil, a nil pointer or interface, or an invalid reflect.Value
+func isNilValue(value interface{}) bool {
+	return derefValue(value) == nil
+}
+
+// nilMismatch returns whether the error is from comparing a nil record value with a criterion value that isn't nil.
+// Comparing with a nil criterion value is still an error
+func nilMismatch(err error) bool {
+	mismatch, ok := err.(*ErrTypeMismatch)
+	return ok && mismatch.Value == nil && mismatch.Other != nil
+}
 
-	for reflect.TypeOf(value).Kind() == reflect.Ptr {
-		value = reflect.ValueOf(value).Elem().Interface()
+// CompareFunc compares a with b.  The result should be 0 if a == b, -1 if a < b, and +1 if a > b
+type CompareFunc func(a, b interface{}) (int, error)
+
+// RegisterComparer sets the function that compares values of the passed in type, for types that can't implement the
+// Comparer interface, such as types from other packages.  Registered comparers are used by criteria, sorting,
+// aggregates and distinct values, and take precedence over the Comparer interface and the default comparisons.
+// Pointers are dereferenced before they're compared, so the comparer is registered for, and passed values of, the
+// type the pointer points to.  A nil compare func removes the type's comparer
+/*
+RegisterComparer Example
+
+	store.RegisterComparer(reflect.TypeOf(decimal.Decimal{}), func(a, b interface{}) (int, error) {
+		other, ok := b.(decimal.Decimal)
+		if !ok {
+			return 0, &bolthold.ErrTypeMismatch{Value: a, Other: b}
+		}
+		return a.(decimal.Decimal).Cmp(other), nil
+	})
+*/
+func (s *Store) RegisterComparer(tp reflect.Type, compare CompareFunc) {
+	if tp == nil {
+		panic("RegisterComparer requires a type, use reflect.TypeOf on a value of the type to compare")
+	}
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	s.comparersLock.Lock()
+	defer s.comparersLock.Unlock()
+
+	current, _ := s.comparers.Load().(map[reflect.Type]CompareFunc)
+	comparers := make(map[reflect.Type]CompareFunc, len(current)+1)
+	for k, v := range current {
+		comparers[k] = v
 	}
 
-	other := criterionValue
-	for reflect.TypeOf(other).Kind() == reflect.Ptr {
-		other = reflect.ValueOf(other).Elem().Interface()
+	if compare == nil {
+		delete(comparers, tp)
+	} else {
+		comparers[tp] = compare
+	}
+	s.comparers.Store(comparers)
+}
+
+// comparer returns the comparer registered for the type, if any
+func (s *Store) comparer(tp reflect.Type) CompareFunc {
+	comparers, _ := s.comparers.Load().(map[reflect.Type]CompareFunc)
+	return comparers[tp]
+}
+
+// hasComparer returns whether a comparer is registered for the type, or the type it points to
+func (s *Store) hasComparer(tp reflect.Type) bool {
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	return s.comparer(tp) != nil
+}
+
+// compare compares the values the same as the package level compare, except that comparers registered with the store
+// are used first, and when NumericPromotion is set, numbers of different types are compared by their numeric value.
+// Elements of slices, arrays and structs are compared the same way
+func (s *Store) compare(value, other interface{}) (int, error) {
+	return s.compareValues(value, other, s.numericPromotion)
+}
+
+// compareValues compares the values the same as compare, with numbers of different types compared by their numeric
+// value when promote is true
+func (s *Store) compareValues(value, other interface{}, promote bool) (int, error) {
+	if compare := s.comparer(reflect.TypeOf(value)); compare != nil {
+		return compare(value, other)
+	}
+
+	if _, ok := value.(Comparer); !ok {
+		if promote && reflect.TypeOf(value) != reflect.TypeOf(other) {
+			if result, ok := compareNumbers(value, other); ok {
+				return result, nil
+			}
+		}
+
+		result, ok, err := compareStructure(value, other, func(value, other interface{}) (int, error) {
+			return s.compareValues(value, other, promote)
+		})
+		if ok {
+			return result, err
+		}
 	}
 
 	return compare(value, other)
 }
 
+// compareNumbers compares any combination of integers, unsigned integers, floats, big.Ints, big.Floats and big.Rats
+// exactly, without converting either one to the other's type.  ok is false if either value isn't a number, or is NaN
+func compareNumbers(value, other interface{}) (result int, ok bool) {
+	v, vInf, ok := numberRat(value)
+	if !ok {
+		return 0, false
+	}
+	o, oInf, ok := numberRat(other)
+	if !ok {
+		return 0, false
+	}
+
+	if vInf != 0 || oInf != 0 {
+		switch {
+		case vInf == oInf:
+			return 0, true
+		case vInf < oInf:
+			return -1, true
+		default:
+			return 1, true
+		}
+	}
+
+	return v.Cmp(o), true
+}
+
+// numberRat returns the exact value of the number as a big.Rat.  Infinite values have no big.Rat, and inf is instead
+// set to their sign
+func numberRat(value interface{}) (rat *big.Rat, inf int, ok bool) {
+	switch v := value.(type) {
+	case big.Int:
+		return new(big.Rat).SetInt(&v), 0, true
+	case big.Float:
+		if v.IsInf() {
+			return nil, v.Sign(), true
+		}
+		rat, _ = v.Rat(nil)
+		return rat, 0, true
+	case big.Rat:
+		return &v, 0, true
+	}
+
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(val.Int()), 0, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Rat).SetUint64(val.Uint()), 0, true
+	case reflect.Float32, reflect.Float64:
+		f := val.Float()
+		switch {
+		case math.IsNaN(f):
+			return nil, 0, false
+		case math.IsInf(f, 1):
+			return nil, 1, true
+		case math.IsInf(f, -1):
+			return nil, -1, true
+		}
+		return new(big.Rat).SetFloat64(f), 0, true
+	}
+
+	return nil, 0, false
+}
+
 func compare(value, other interface{}) (int, error) {
 	switch t := value.(type) {
 	case time.Time:
@@ -299,6 +493,11 @@ func compare(value, other interface{}) (int, error) {
 	case Comparer:
 		return value.(Comparer).Compare(other)
 	default:
+		result, ok, err := compareStructure(value, other, compare)
+		if ok {
+			return result, err
+		}
+
 		valS := fmt.Sprintf("%s", value)
 		otherS := fmt.Sprintf("%s", other)
 		if valS == otherS {
@@ -313,3 +512,96 @@ func compare(value, other interface{}) (int, error) {
 	}
 
 }
+
+// compareStructure compares slices, arrays, structs and pointers of the same type by their contents.  Byte slices and
+// arrays are compared with bytes.Compare, other slices and arrays element by element, with a shorter slice first when
+// it's the start of the longer one, structs field by field in the order they're declared, and pointers by the values
+// they point to.  ok is false for any other value, and for structs with unexported fields, or that implement
+// fmt.Stringer, which are compared as strings.  Elements, fields and the values pointed to are compared with the
+// passed in compare func
+func compareStructure(value, other interface{}, compare CompareFunc) (result int, ok bool, err error) {
+	v := reflect.ValueOf(value)
+	o := reflect.ValueOf(other)
+	if !v.IsValid() || !o.IsValid() || v.Type() != o.Type() {
+		return 0, false, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		result, err = compareElements(v, o, compare)
+		return result, true, err
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return bytes.Compare(byteSlice(v), byteSlice(o)), true, nil
+		}
+
+		for i := 0; i < v.Len() && i < o.Len(); i++ {
+			result, err = compareElements(v.Index(i), o.Index(i), compare)
+			if err != nil || result != 0 {
+				return result, true, err
+			}
+		}
+
+		switch {
+		case v.Len() < o.Len():
+			return -1, true, nil
+		case v.Len() > o.Len():
+			return 1, true, nil
+		}
+		return 0, true, nil
+	case reflect.Struct:
+		tp := v.Type()
+		if tp.Implements(stringerType) || reflect.PtrTo(tp).Implements(stringerType) {
+			return 0, false, nil
+		}
+		for i := 0; i < tp.NumField(); i++ {
+			if tp.Field(i).PkgPath != "" {
+				return 0, false, nil
+			}
+		}
+
+		for i := 0; i < tp.NumField(); i++ {
+			result, err = compareElements(v.Field(i), o.Field(i), compare)
+			if err != nil || result != 0 {
+				return result, true, err
+			}
+		}
+		return 0, true, nil
+	}
+
+	return 0, false, nil
+}
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// compareElements compares two elements of a slice, array or struct, where nil pointers and interfaces come before
+// any other value
+func compareElements(value, other reflect.Value, compare CompareFunc) (int, error) {
+	for (value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface) && value.Kind() == other.Kind() {
+		switch {
+		case value.IsNil() && other.IsNil():
+			return 0, nil
+		case value.IsNil():
+			return -1, nil
+		case other.IsNil():
+			return 1, nil
+		}
+		value = value.Elem()
+		other = other.Elem()
+	}
+
+	return compare(value.Interface(), other.Interface())
+}
+
+// byteSlice returns the bytes of a byte slice or array
+func byteSlice(value reflect.Value) []byte {
+	if value.Kind() == reflect.Slice {
+		return value.Bytes()
+	}
+
+	b := make([]byte, value.Len())
+	for i := range b {
+		b[i] = byte(value.Index(i).Uint())
+	}
+	return b
+}
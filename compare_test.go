@@ -7,6 +7,7 @@ package bolthold_test
 import (
 	"fmt"
 	"math/big"
+	"net"
 	"reflect"
 	"testing"
 	"time"
@@ -302,3 +303,165 @@ func TestFindWithBuiltinTypes(t *testing.T) {
 
 	})
 }
+
+type Point struct {
+	X, Y int
+}
+
+type Structured struct {
+	ID     int `boltholdKey:"ID"`
+	Raw    []byte
+	UUID   [4]byte
+	IP     net.IP
+	Path   []int
+	Point  Point
+	Corner *Point
+}
+
+func TestStructuralCompare(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		data := []Structured{
+			{ID: 1, Raw: []byte{10, 2}, UUID: [4]byte{0, 0, 1, 0}, IP: net.ParseIP("10.0.0.2"), Path: []int{10, 2},
+				Point: Point{X: 1, Y: 5}, Corner: &Point{X: 10}},
+			{ID: 2, Raw: []byte{9, 3}, UUID: [4]byte{0, 0, 0, 255}, IP: net.ParseIP("9.0.0.1"), Path: []int{9, 3},
+				Point: Point{X: 1, Y: 2}},
+			{ID: 3, Raw: []byte{9}, UUID: [4]byte{1, 0, 0, 0}, IP: net.ParseIP("192.168.0.1"), Path: []int{9},
+				Point: Point{X: 0, Y: 9}, Corner: &Point{X: 9}},
+		}
+		for i := range data {
+			ok(t, store.Insert(data[i].ID, &data[i]))
+		}
+
+		tests := []struct {
+			name  string
+			query *bolthold.Query
+			want  []int
+		}{
+			{"Byte Slice", bolthold.Where("Raw").Gt([]byte{9, 4}), []int{1}},
+			{"Byte Slice Prefix", bolthold.Where("Raw").Lt([]byte{9, 3}), []int{3}},
+			{"Byte Array", bolthold.Where("UUID").Lt([4]byte{0, 0, 1, 0}), []int{2}},
+			{"IP", bolthold.Where("IP").Ge(net.ParseIP("10.0.0.0")), []int{1, 3}},
+			{"Int Slice", bolthold.Where("Path").Gt([]int{9, 3}), []int{1}},
+			{"Int Slice Equal", bolthold.Where("Path").Eq([]int{9}), []int{3}},
+			{"Struct", bolthold.Where("Point").Gt(Point{X: 1, Y: 2}), []int{1}},
+			{"Struct Sort", (&bolthold.Query{}).SortBy("Point"), []int{3, 2, 1}},
+			{"Pointer Field Sort", (&bolthold.Query{}).SortBy("Corner"), []int{3, 1, 2}},
+			{"IP Sort", (&bolthold.Query{}).SortBy("IP"), []int{2, 1, 3}},
+			{"Int Slice Sort", (&bolthold.Query{}).SortBy("Path"), []int{3, 2, 1}},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				var result []Structured
+				ok(t, store.Find(&result, tst.query))
+
+				ids := []int{}
+				for i := range result {
+					ids = append(ids, result[i].ID)
+				}
+				equals(t, tst.want, ids)
+			})
+		}
+
+		aggs, err := store.FindAggregate(&Structured{}, nil)
+		ok(t, err)
+
+		var highest Structured
+		aggs[0].Max("UUID", &highest)
+		equals(t, 3, highest.ID)
+	})
+}
+
+// SemVer stands in for a type from another package, that can't implement Comparer
+type SemVer string
+
+func compareSemVer(a, b interface{}) (int, error) {
+	other, ok := b.(SemVer)
+	if !ok {
+		return 0, &bolthold.ErrTypeMismatch{Value: a, Other: b}
+	}
+
+	var aParts, bParts [3]int
+	_, err := fmt.Sscanf(string(a.(SemVer)), "%d.%d.%d", &aParts[0], &aParts[1], &aParts[2])
+	if err != nil {
+		return 0, err
+	}
+	_, err = fmt.Sscanf(string(other), "%d.%d.%d", &bParts[0], &bParts[1], &bParts[2])
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range aParts {
+		if aParts[i] != bParts[i] {
+			if aParts[i] < bParts[i] {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+type Release struct {
+	ID       int `boltholdKey:"ID"`
+	Version  SemVer
+	Requires []SemVer
+	Previous *SemVer
+}
+
+func TestRegisterComparer(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		previous := []SemVer{"1.9.3", "1.2.0", "1.1.0"}
+		releases := []Release{
+			{ID: 1, Version: "1.10.0", Requires: []SemVer{"2.0.0"}, Previous: &previous[0]},
+			{ID: 2, Version: "1.9.3", Requires: []SemVer{"1.10.0", "0.9.0"}, Previous: &previous[1]},
+			{ID: 3, Version: "1.2.0", Requires: []SemVer{"1.10.0", "0.10.0"}, Previous: &previous[2]},
+		}
+		for i := range releases {
+			ok(t, store.Insert(releases[i].ID, &releases[i]))
+		}
+
+		ids := func(query *bolthold.Query) []int {
+			var result []Release
+			ok(t, store.Find(&result, query))
+			ids := []int{}
+			for i := range result {
+				ids = append(ids, result[i].ID)
+			}
+			return ids
+		}
+
+		// lexicographically, 1.10.0 comes before 1.9.3 and 1.2.0
+		equals(t, []int{2, 3}, ids(bolthold.Where("Version").Gt(SemVer("1.10.0"))))
+
+		store.RegisterComparer(reflect.TypeOf((*SemVer)(nil)), compareSemVer)
+
+		equals(t, []int{1}, ids(bolthold.Where("Version").Gt(SemVer("1.9.3"))))
+		equals(t, []int{2, 3}, ids(bolthold.Where("Previous").Lt(SemVer("1.9.0"))))
+		equals(t, []int{3, 2, 1}, ids((&bolthold.Query{}).SortBy("Version")))
+		equals(t, []int{2, 3, 1}, ids((&bolthold.Query{}).SortBy("Requires").SortBy("ID")))
+
+		aggs, err := store.FindAggregate(&Release{}, nil)
+		ok(t, err)
+		var latest Release
+		aggs[0].Max("Version", &latest)
+		equals(t, 1, latest.ID)
+
+		var count int
+		store.RegisterComparer(reflect.TypeOf(SemVer("")), func(a, b interface{}) (int, error) {
+			count++
+			return compareSemVer(a, b)
+		})
+		equals(t, []int{1, 2}, ids(bolthold.Where("Version").Ge(SemVer("1.9.0"))))
+		assert(t, count > 0, "The replaced comparer wasn't called")
+
+		store.RegisterComparer(reflect.TypeOf(SemVer("")), nil)
+		equals(t, []int{2, 3}, ids(bolthold.Where("Version").Gt(SemVer("1.10.0"))))
+
+		assert(t, func() (panicked bool) {
+			defer func() { panicked = recover() != nil }()
+			store.RegisterComparer(nil, compareSemVer)
+			return false
+		}(), "No panic registering a comparer for a nil type")
+	})
+}
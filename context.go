@@ -0,0 +1,147 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold
+
+import (
+	"context"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// FindContext is the same as Find, but stops with the context's error if the context is cancelled.  The context is
+// checked between each record the query reads, and while the results are sorted.
+func (s *Store) FindContext(ctx context.Context, result interface{}, query *Query) error {
+	return s.Bolt().View(func(tx *bolt.Tx) error {
+		return s.TxFindContext(ctx, tx, result, query)
+	})
+}
+
+// TxFindContext is the same as FindContext but you get to specify your transaction
+func (s *Store) TxFindContext(ctx context.Context, tx *bolt.Tx, result interface{}, query *Query) error {
+	return withContext(ctx, query, func(query *Query) error {
+		return s.findQuery(tx, result, query)
+	})
+}
+
+// FindOneContext is the same as FindOne, but stops with the context's error if the context is cancelled
+func (s *Store) FindOneContext(ctx context.Context, result interface{}, query *Query) error {
+	return s.Bolt().View(func(tx *bolt.Tx) error {
+		return s.TxFindOneContext(ctx, tx, result, query)
+	})
+}
+
+// TxFindOneContext is the same as FindOneContext but you get to specify your transaction
+func (s *Store) TxFindOneContext(ctx context.Context, tx *bolt.Tx, result interface{}, query *Query) error {
+	return withContext(ctx, query, func(query *Query) error {
+		return s.findOneQuery(tx, result, query)
+	})
+}
+
+// CountContext is the same as Count, but stops with the context's error if the context is cancelled
+func (s *Store) CountContext(ctx context.Context, dataType interface{}, query *Query) (int, error) {
+	count := 0
+	err := s.Bolt().View(func(tx *bolt.Tx) error {
+		var txErr error
+		count, txErr = s.TxCountContext(ctx, tx, dataType, query)
+		return txErr
+	})
+	return count, err
+}
+
+// TxCountContext is the same as CountContext but you get to specify your transaction
+func (s *Store) TxCountContext(ctx context.Context, tx *bolt.Tx, dataType interface{}, query *Query) (int, error) {
+	count := 0
+	err := withContext(ctx, query, func(query *Query) error {
+		var err error
+		count, err = s.countQuery(tx, dataType, query)
+		return err
+	})
+	return count, err
+}
+
+// ForEachContext is the same as ForEach, but stops with the context's error if the context is cancelled
+func (s *Store) ForEachContext(ctx context.Context, query *Query, fn interface{}) error {
+	return s.Bolt().View(func(tx *bolt.Tx) error {
+		return s.TxForEachContext(ctx, tx, query, fn)
+	})
+}
+
+// TxForEachContext is the same as ForEachContext but you get to specify your transaction
+func (s *Store) TxForEachContext(ctx context.Context, tx *bolt.Tx, query *Query, fn interface{}) error {
+	return withContext(ctx, query, func(query *Query) error {
+		return s.forEach(tx, query, fn)
+	})
+}
+
+// FindAggregateContext is the same as FindAggregate, but stops with the context's error if the context is cancelled
+func (s *Store) FindAggregateContext(ctx context.Context, dataType interface{}, query *Query,
+	groupBy ...string) ([]*AggregateResult, error) {
+	var result []*AggregateResult
+	err := s.Bolt().View(func(tx *bolt.Tx) error {
+		var txErr error
+		result, txErr = s.TxFindAggregateContext(ctx, tx, dataType, query, groupBy...)
+		return txErr
+	})
+	return result, err
+}
+
+// TxFindAggregateContext is the same as FindAggregateContext but you get to specify your transaction
+func (s *Store) TxFindAggregateContext(ctx context.Context, tx *bolt.Tx, dataType interface{}, query *Query,
+	groupBy ...string) ([]*AggregateResult, error) {
+	var result []*AggregateResult
+	err := withContext(ctx, query, func(query *Query) error {
+		var err error
+		result, err = s.aggregateQuery(tx, dataType, query, groupBy...)
+		return err
+	})
+	return result, err
+}
+
+// DeleteMatchingContext is the same as DeleteMatching, but if the context is cancelled it stops, and none of the
+// matching records are deleted
+func (s *Store) DeleteMatchingContext(ctx context.Context, dataType interface{}, query *Query) error {
+	return s.Bolt().Update(func(tx *bolt.Tx) error {
+		return s.TxDeleteMatchingContext(ctx, tx, dataType, query)
+	})
+}
+
+// TxDeleteMatchingContext is the same as DeleteMatchingContext but you get to specify your transaction.  If the
+// context is cancelled, the error is returned and it's up to you to roll back the transaction
+func (s *Store) TxDeleteMatchingContext(ctx context.Context, tx *bolt.Tx, dataType interface{}, query *Query) error {
+	return withContext(ctx, query, func(query *Query) error {
+		return s.deleteQuery(tx, dataType, query)
+	})
+}
+
+// UpdateMatchingContext is the same as UpdateMatching, but if the context is cancelled it stops, and none of the
+// matching records are updated
+func (s *Store) UpdateMatchingContext(ctx context.Context, dataType interface{}, query *Query,
+	update func(record interface{}) error) error {
+	return s.Bolt().Update(func(tx *bolt.Tx) error {
+		return s.TxUpdateMatchingContext(ctx, tx, dataType, query, update)
+	})
+}
+
+// TxUpdateMatchingContext is the same as UpdateMatchingContext but you get to specify your transaction.  If the
+// context is cancelled, the error is returned and it's up to you to roll back the transaction
+func (s *Store) TxUpdateMatchingContext(ctx context.Context, tx *bolt.Tx, dataType interface{}, query *Query,
+	update func(record interface{}) error) error {
+	return withContext(ctx, query, func(query *Query) error {
+		return s.updateQuery(tx, dataType, query, update)
+	})
+}
+
+// withContext runs fn with a copy of the query that checks the context as it runs
+func withContext(ctx context.Context, query *Query, fn func(query *Query) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if query == nil {
+		query = &Query{}
+	}
+
+	return fn(query.contextCopy(ctx))
+}
@@ -0,0 +1,218 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/timshannon/bolthold"
+	bolt "go.etcd.io/bbolt"
+)
+
+// cancelSort is called by cancelOnCompare, to cancel a query while it's being sorted
+var cancelSort context.CancelFunc
+
+type cancelOnCompare int
+
+func (c cancelOnCompare) Compare(other interface{}) (int, error) {
+	cancelSort()
+
+	o := other.(cancelOnCompare)
+	if c < o {
+		return -1, nil
+	}
+	if c > o {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+type SortCancelItem struct {
+	Value cancelOnCompare
+}
+
+func TestContextCancelled(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPageData(t, store)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		var result []PageItem
+		equals(t, context.Canceled, store.FindContext(ctx, &result, nil))
+		equals(t, 0, len(result))
+
+		_, err := store.CountContext(ctx, &PageItem{}, bolthold.Where("Group").Eq("group1"))
+		equals(t, context.Canceled, err)
+
+		equals(t, context.Canceled, store.ReIndexContext(ctx, &PageItem{}, nil))
+
+		// the query can still be run without the context
+		query := bolthold.Where("Group").Eq("group1").Or(bolthold.Where("Score").Eq(2))
+		_, err = store.CountContext(ctx, &PageItem{}, query)
+		equals(t, context.Canceled, err)
+
+		count, err := store.Count(&PageItem{}, query)
+		ok(t, err)
+		assert(t, count > 0, "Query found no records after its context was cancelled")
+	})
+}
+
+func TestContextForEach(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPageData(t, store)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		count := 0
+		err := store.ForEachContext(ctx, bolthold.Where("Score").Ge(5), func(record *PageItem) error {
+			count++
+			if count == 3 {
+				cancel()
+			}
+			return nil
+		})
+		equals(t, context.Canceled, err)
+		equals(t, 3, count)
+	})
+}
+
+func TestContextWriteRollback(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPageData(t, store)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		updated := 0
+		err := store.UpdateMatchingContext(ctx, &PageItem{}, bolthold.Where("Group").Eq("group2"),
+			func(record interface{}) error {
+				record.(*PageItem).Score = 100
+				updated++
+				if updated == 2 {
+					cancel()
+				}
+				return nil
+			})
+		equals(t, context.Canceled, err)
+		equals(t, 2, updated)
+
+		count, err := store.Count(&PageItem{}, bolthold.Where("Score").Eq(100))
+		ok(t, err)
+		equals(t, 0, count)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+
+		matched := 0
+		err = store.DeleteMatchingContext(ctx, &PageItem{}, bolthold.Where("Group").Eq("group2").And("Score").
+			MatchFunc(func(ra *bolthold.RecordAccess) (bool, error) {
+				matched++
+				if matched == 5 {
+					cancel()
+				}
+				return true, nil
+			}))
+		equals(t, context.Canceled, err)
+
+		count, err = store.Count(&PageItem{}, bolthold.Where("Group").Eq("group2"))
+		ok(t, err)
+		equals(t, 14, count)
+	})
+}
+
+func TestContextSort(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		ok(t, store.Bolt().Update(func(tx *bolt.Tx) error {
+			for i := 0; i < 200; i++ {
+				ok(t, store.TxInsert(tx, i, &SortCancelItem{Value: cancelOnCompare(200 - i)}))
+			}
+			return nil
+		}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		cancelSort = cancel
+
+		var result []SortCancelItem
+		err := store.FindContext(ctx, &result, (&bolthold.Query{}).SortBy("Value"))
+		equals(t, context.Canceled, err)
+		equals(t, 0, len(result))
+	})
+}
+
+func TestContextSharedQuery(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPageData(t, store)
+
+		query := bolthold.Where("Score").Ge(2).
+			AndGroup(bolthold.Not(bolthold.Where("Group").Eq("group1"))).
+			Or(bolthold.Where("Group").Eq("group3").AndGroup(bolthold.Where("Score").Lt(5)))
+
+		expected, err := store.Count(&PageItem{}, query)
+		ok(t, err)
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		errs := make(chan error, 20)
+		for i := 0; i < 10; i++ {
+			go func() {
+				_, err := store.CountContext(cancelled, &PageItem{}, query)
+				if err != context.Canceled {
+					errs <- fmt.Errorf("Expected %v running under the cancelled context, got %v", context.Canceled, err)
+					return
+				}
+				errs <- nil
+			}()
+			go func() {
+				count, err := store.CountContext(context.Background(), &PageItem{}, query)
+				if err == nil && count != expected {
+					err = fmt.Errorf("Expected a count of %d, got %d", expected, count)
+				}
+				errs <- err
+			}()
+		}
+
+		for i := 0; i < 20; i++ {
+			ok(t, <-errs)
+		}
+	})
+}
+
+type ContextPart struct {
+	Qty int
+}
+
+type ContextElemItem struct {
+	ID    int
+	Parts []ContextPart
+}
+
+func TestContextElemMatch(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		ok(t, store.Insert(1, &ContextElemItem{ID: 1, Parts: []ContextPart{{1}, {2}, {3}, {4}, {5}, {6}}}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		tested := 0
+		query := bolthold.Where("Parts").ElemMatch(bolthold.Where("Qty").
+			MatchFunc(func(ra *bolthold.RecordAccess) (bool, error) {
+				tested++
+				if tested == 2 {
+					cancel()
+				}
+				return false, nil
+			}))
+
+		var result []ContextElemItem
+		equals(t, context.Canceled, store.FindContext(ctx, &result, query))
+		equals(t, 2, tested)
+	})
+}
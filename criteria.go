@@ -5,9 +5,11 @@
 package bolthold
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 )
@@ -24,6 +26,12 @@ const (
 	fn           // func
 	isnil        // test's for nil
 	hk           // match map keys
+	hp           // string has prefix
+	hs           // string has suffix
+	cs           // string contains substring
+	ef           // string equal under case folding
+	em           // an element matches a query
+	hv           // match map values
 
 	contains // slice only
 	any      // slice only
@@ -41,18 +49,30 @@ const BoltholdKeyTag = "boltholdKey"
 // an empty query matches against all records
 type Query struct {
 	index         string
+	indexSet      bool
 	currentField  string
 	fieldCriteria map[string][]*Criterion
 	ors           []*Query
-
-	badIndex bool
-	dataType reflect.Type
-	source   BucketSource
-
-	limit   int
-	skip    int
-	sort    []string
-	reverse bool
+	groups        []queryGroup
+
+	badIndex    bool
+	indexFields []string
+	dataType    reflect.Type
+	source      BucketSource
+	profile     *Plan
+	ctx         context.Context
+
+	limit    int
+	skip     int
+	sort     []string
+	sortDesc []bool // whether each sort field is sorted in descending order
+	sortNull []int  // where each sort field puts nil values, nullsDefault, nullsFirst or nullsLast
+	reverse  bool
+	selected []string
+
+	page       interface{} // the key or PageToken passed to After or Before
+	pageBefore bool
+	paged      bool // run by FindPage
 }
 
 // IsEmpty returns true if the query is an empty query
@@ -69,9 +89,19 @@ func (q *Query) IsEmpty() bool {
 		return false
 	}
 
+	if len(q.groups) != 0 {
+		return false
+	}
+
 	return true
 }
 
+// queryGroup is a query that is And'd with another query as a whole, with its own Or'd queries
+type queryGroup struct {
+	query  *Query
+	negate bool
+}
+
 // Criterion is an operator and a value that a given field needs to match on
 type Criterion struct {
 	query    *Query
@@ -79,13 +109,15 @@ type Criterion struct {
 	value    interface{}
 	values   []interface{}
 	negate   bool
+	length   bool // the length of the field is tested rather than its value
+	literal  bool // the values were parsed from query text, so numbers are compared with any type of number
 }
 
 // some operators can't function against an indexed value, they need to look at
 // the entire record
 func canUseIndex(criteria []*Criterion) bool {
 	for _, c := range criteria {
-		if c.operator == fn || c.operator == all {
+		if c.operator == fn || c.operator == all || c.operator == em || c.operator == hv || c.length {
 			return false
 		}
 	}
@@ -178,13 +210,54 @@ func (q *Query) Limit(amount int) *Query {
 	return q
 }
 
+// After returns only the records that come after the record with the passed in key, in the order the query returns
+// them.  A PageToken from FindPage can be passed instead of a key.  Unlike Skip, the records before the key aren't
+// read when the query isn't sorted and has no Or'd queries.
+// Setting After or Before more than once will panic
+func (q *Query) After(key interface{}) *Query {
+	q.setPage(key, false)
+	return q
+}
+
+// Before returns only the records that come before the record with the passed in key, in the order the query returns
+// them.  A PageToken from FindPage can be passed instead of a key.  Limit returns the records closest to the key,
+// and Skip skips the records closest to the key.
+// Setting After or Before more than once will panic
+func (q *Query) Before(key interface{}) *Query {
+	q.setPage(key, true)
+	return q
+}
+
+func (q *Query) setPage(key interface{}, before bool) {
+	if key == nil {
+		panic("After and Before require a key")
+	}
+
+	if q.page != nil {
+		panic("After or Before has already been set")
+	}
+
+	q.page = key
+	q.pageBefore = before
+}
+
 // SortBy sorts the results by the given fields name
-// Multiple fields can be used
+// Multiple fields can be used, and bolthold.Key sorts by the record's key.  A query that is only sorted by Key, and
+// reads its records in key order, isn't sorted in memory.
 func (q *Query) SortBy(fields ...string) *Query {
+	q.addSort(fields, false)
+	return q
+}
+
+// SortByDesc sorts the results by the given fields in descending order.  It can be mixed with SortBy, such as
+// SortBy("Division").SortByDesc("Hired"), and Reverse reverses the direction of every field.
+func (q *Query) SortByDesc(fields ...string) *Query {
+	q.addSort(fields, true)
+	return q
+}
+
+func (q *Query) addSort(fields []string, desc bool) {
 	for i := range fields {
-		if fields[i] == Key {
-			panic("Cannot sort by Key.")
-		}
 		found := false
 		for k := range q.sort {
 			if q.sort[k] == fields[i] {
@@ -194,6 +267,77 @@ func (q *Query) SortBy(fields ...string) *Query {
 		}
 		if !found {
 			q.sort = append(q.sort, fields[i])
+			q.sortDesc = append(q.sortDesc, desc)
+			q.sortNull = append(q.sortNull, nullsDefault)
+		}
+	}
+}
+
+const (
+	nullsDefault = iota // nil values are greater than any other value, so they're last unless sorted descending
+	nullsFirst
+	nullsLast
+)
+
+// NullsFirst sorts the records with a nil value for the last field passed to SortBy or SortByDesc before every other
+// record, whichever direction the field is sorted in.  Nil values are pointers, interfaces and fields in embedded
+// structs that are nil.  By default, nil values sort after every other value, and so come first when the field is
+// sorted in descending order.  NullsFirst will panic if the query isn't sorted
+func (q *Query) NullsFirst() *Query {
+	q.setNulls(nullsFirst)
+	return q
+}
+
+// NullsLast sorts the records with a nil value for the last field passed to SortBy or SortByDesc after every other
+// record, whichever direction the field is sorted in.  NullsLast will panic if the query isn't sorted
+func (q *Query) NullsLast() *Query {
+	q.setNulls(nullsLast)
+	return q
+}
+
+func (q *Query) setNulls(nulls int) {
+	if len(q.sort) == 0 {
+		panic("NullsFirst and NullsLast must follow SortBy or SortByDesc")
+	}
+
+	for len(q.sortNull) < len(q.sort) {
+		q.sortNull = append(q.sortNull, nullsDefault)
+	}
+	q.sortNull[len(q.sort)-1] = nulls
+}
+
+// sortNulls returns where the sort field at i puts nil values
+func (q *Query) sortNulls(i int) int {
+	if i < len(q.sortNull) {
+		return q.sortNull[i]
+	}
+	return nullsDefault
+}
+
+// keyOrdered returns whether the query is only sorted by Key in ascending order, which is the order records are
+// read when the query runs against the Key
+func (q *Query) keyOrdered() bool {
+	return len(q.sort) == 1 && q.sort[0] == Key && q.sortDesc[0] == q.reverse && len(q.ors) == 0
+}
+
+// Select limits the fields that are set on the records returned by Find, FindOne, ForEach and Iter to the passed in
+// fields, the rest are left as their zero value.  The key field is always set.  If the index the query is read
+// through holds every selected field, and every field the query's criteria and sort order use, the records are read
+// from the index rather than decoded.
+func (q *Query) Select(fields ...string) *Query {
+	for i := range fields {
+		if !startsUpper(fields[i]) {
+			panic("The first letter of a selected field must be upper-case")
+		}
+		found := false
+		for k := range q.selected {
+			if q.selected[k] == fields[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			q.selected = append(q.selected, fields[i])
 		}
 	}
 	return q
@@ -206,9 +350,11 @@ func (q *Query) Reverse() *Query {
 	return q
 }
 
-// Index specifies the index to use when running this query
+// Index specifies the index to use when running this query.  If no index is specified, the index estimated to
+// return the fewest records for the query's criteria is used
 func (q *Query) Index(indexName string) *Query {
 	q.index = indexName
+	q.indexSet = true
 	return q
 }
 
@@ -223,19 +369,142 @@ func (q *Query) Or(query *Query) *Query {
 	return q
 }
 
+// AndGroup adds a query that records must also match, as a whole, to this query.  A record matches the group if it
+// matches the group's criteria, or any of the group's Or'd queries, so groups can express criteria such as
+// A AND (B OR C), which a flat list of Or'd queries can't.  Groups are tested against each record after it's read,
+// so only the criteria of the query itself are used to pick an index.  The index, sort order, skip and limit of
+// the group are ignored.
+/*
+Group Example
+
+	bolthold.Where("Owner").Eq(user).
+		AndGroup(bolthold.Where("Public").Eq(true).Or(bolthold.Where("Shared").Contains(user)))
+*/
+func (q *Query) AndGroup(query *Query) *Query {
+	if query == nil {
+		panic("AndGroup requires a query")
+	}
+
+	if len(query.fieldCriteria) == 0 && len(query.ors) == 0 && len(query.groups) > 0 {
+		// a query made up of only groups, such as one from Not, is the same as And'ing each of its groups
+		q.groups = append(q.groups, query.groups...)
+		return q
+	}
+
+	q.groups = append(q.groups, queryGroup{query: query})
+	return q
+}
+
+// Not returns a query that matches the records the passed in query, including its Or'd queries, doesn't match.
+// It can be run on its own, or And'd with another query with AndGroup, such as
+// bolthold.Where("Division").Eq("Sales").AndGroup(bolthold.Not(bolthold.Where("Active").Eq(false)))
+func Not(query *Query) *Query {
+	if query == nil {
+		panic("Not requires a query")
+	}
+	return &Query{
+		groups: []queryGroup{{query: query, negate: true}},
+	}
+}
+
+// setProfile sets the plan on the query and its Or'd queries, that counts the records they scan and match while
+// running
+func (q *Query) setProfile(plan *Plan) {
+	q.profile = plan
+	if plan != nil {
+		plan.Profiled = true
+	}
+
+	for i := range q.ors {
+		var orPlan *Plan
+		if plan != nil {
+			orPlan = plan.Ors[i]
+		}
+		q.ors[i].setProfile(orPlan)
+	}
+}
+
+// contextCopy returns a copy of the query, with copies of its criteria, Or'd queries, groups and ElemMatch queries,
+// that checks the context between each record it reads.  The query itself is left unchanged, so it can be run under
+// other contexts at the same time.
+func (q *Query) contextCopy(ctx context.Context) *Query {
+	c := *q
+	c.ctx = ctx
+
+	if q.fieldCriteria != nil {
+		c.fieldCriteria = make(map[string][]*Criterion, len(q.fieldCriteria))
+		for field, criteria := range q.fieldCriteria {
+			copied := make([]*Criterion, len(criteria))
+			for i := range criteria {
+				criterion := *criteria[i]
+				criterion.query = &c
+				if sub, ok := criterion.value.(*Query); ok {
+					criterion.value = sub.contextCopy(ctx)
+				}
+				copied[i] = &criterion
+			}
+			c.fieldCriteria[field] = copied
+		}
+	}
+
+	if q.ors != nil {
+		c.ors = make([]*Query, len(q.ors))
+		for i := range q.ors {
+			c.ors[i] = q.ors[i].contextCopy(ctx)
+		}
+	}
+
+	if q.groups != nil {
+		c.groups = make([]queryGroup, len(q.groups))
+		for i := range q.groups {
+			c.groups[i] = queryGroup{query: q.groups[i].query.contextCopy(ctx), negate: q.groups[i].negate}
+		}
+	}
+
+	return &c
+}
+
+// contextErr returns the error of the query's context, if it's been cancelled
+func (q *Query) contextErr() error {
+	if q.ctx == nil {
+		return nil
+	}
+	return q.ctx.Err()
+}
+
 func (q *Query) matchesAllFields(s *Store, key []byte, value reflect.Value, currentRow interface{}) (bool, error) {
+	return q.matchesFields(s, key, value, currentRow, !q.badIndex)
+}
+
+// matchesFields tests the record against the query's criteria and groups, but not its Or'd queries.  If indexed is
+// true, the criteria already tested by the index iterator are skipped.
+func (q *Query) matchesFields(s *Store, key []byte, value reflect.Value, currentRow interface{},
+	indexed bool) (bool, error) {
 	if q.IsEmpty() {
 		return true, nil
 	}
 
 	for field, criteria := range q.fieldCriteria {
-		if field == q.index && !q.badIndex {
+		if indexed && q.indexHandles(field) {
 			// already handled by index Iterator
 			continue
 		}
 
+		if field == Key && key == nil {
+			// elements tested by ElemMatch have no key, the Key is the element itself
+			ok, err := matchesAllCriteria(s, criteria, value.Elem().Interface(), nil, currentRow)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				return false, nil
+			}
+
+			continue
+		}
+
 		if field == Key {
-			ok, err := matchesAllCriteria(s, criteria, key, true, currentRow)
+			ok, err := matchesAllCriteria(s, criteria, key, s.decode, currentRow)
 			if err != nil {
 				return false, err
 			}
@@ -251,7 +520,12 @@ func (q *Query) matchesAllFields(s *Store, key []byte, value reflect.Value, curr
 			return false, err
 		}
 
-		ok, err := matchesAllCriteria(s, criteria, fVal, false, currentRow)
+		match := matchesAllCriteria
+		if isMapField(field) {
+			match = matchesMapCriteria
+		}
+
+		ok, err := match(s, criteria, fVal, nil, currentRow)
 		if err != nil {
 			return false, err
 		}
@@ -260,12 +534,60 @@ func (q *Query) matchesAllFields(s *Store, key []byte, value reflect.Value, curr
 		}
 	}
 
+	for _, group := range q.groups {
+		ok, err := group.query.matchesGroup(s, q.source, key, value, currentRow)
+		if err != nil {
+			return false, err
+		}
+		if ok == group.negate {
+			return false, nil
+		}
+	}
+
 	return true, nil
 }
 
+// matchesGroup returns whether the record matches the query as a group, either its criteria and groups, or any of
+// its Or'd queries
+func (q *Query) matchesGroup(s *Store, source BucketSource, key []byte, value reflect.Value,
+	currentRow interface{}) (bool, error) {
+	q.source = source
+
+	ok, err := q.matchesFields(s, key, value, currentRow, false)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	for i := range q.ors {
+		ok, err = q.ors[i].matchesGroup(s, source, key, value, currentRow)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
+
+// indexHandles returns true if the criteria on the field are tested by the index iterator
+func (q *Query) indexHandles(field string) bool {
+	for i := range q.indexFields {
+		if q.indexFields[i] == field {
+			return true
+		}
+	}
+	return false
+}
+
 func fieldValue(value reflect.Value, field string) (interface{}, error) {
 	current := value
 
+	if current.Kind() == reflect.Interface {
+		if current.IsNil() {
+			return nil, nil
+		}
+		current = current.Elem()
+	}
+
 	if current.Kind() == reflect.Ptr {
 		if current.IsNil() {
 			return reflect.Value{}, nil
@@ -281,15 +603,16 @@ func fieldValue(value reflect.Value, field string) (interface{}, error) {
 		return value.Interface(), nil
 	}
 
-	split := strings.SplitN(field, ".", 2)
-
-	currentField := split[0]
-	remainder := ""
-	if len(split) != 1 {
-		remainder = split[1]
+	currentField, keys, remainder, err := splitField(field)
+	if err != nil {
+		return reflect.Value{}, err
 	}
 
 	typ := current.Type()
+	if typ.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("The field %s does not exist in the type %s", field, typ)
+	}
+
 	f, ok := typ.FieldByNameFunc(func(name string) bool {
 		return name == currentField
 	})
@@ -312,8 +635,109 @@ func fieldValue(value reflect.Value, field string) (interface{}, error) {
 		v = vField
 	}
 
-	return fieldValue(current.FieldByIndex(f.Index), remainder)
+	next := current.FieldByIndex(f.Index)
+	for _, key := range keys {
+		next, err = mapEntry(next, key)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if !next.IsValid() {
+			return nil, nil
+		}
+	}
+
+	return fieldValue(next, remainder)
+
+}
+
+// splitField splits the first field name off of the field path, along with the map keys in brackets that follow it,
+// i.e. Attrs[color].Name is split into Attrs, [color] and Name.  Map keys can contain dots, but not brackets
+func splitField(field string) (name string, keys []string, remainder string, err error) {
+	end := strings.IndexAny(field, ".[")
+	if end < 0 {
+		return field, nil, "", nil
+	}
+
+	name = field[:end]
+	rest := field[end:]
+	for strings.HasPrefix(rest, "[") {
+		closing := strings.IndexByte(rest, ']')
+		if closing < 0 {
+			return "", nil, "", fmt.Errorf("The map key in the field %s is missing its closing ]", field)
+		}
+		keys = append(keys, rest[1:closing])
+		rest = rest[closing+1:]
+	}
+
+	if rest == "" {
+		return name, keys, "", nil
+	}
+	if rest[0] != '.' {
+		return "", nil, "", fmt.Errorf("The field %s is invalid, a map key must be followed by a . or another "+
+			"map key", field)
+	}
+
+	return name, keys, rest[1:], nil
+}
+
+// mapEntry returns the entry in the map with the key, or the zero value of the map's values if the key isn't in the
+// map.  An invalid value is returned if the map is inside of a nil interface{}
+func mapEntry(value reflect.Value, key string) (reflect.Value, error) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			if value.Kind() == reflect.Interface {
+				return reflect.Value{}, nil
+			}
+			value = reflect.Zero(value.Type().Elem())
+			continue
+		}
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Map {
+		return reflect.Value{}, fmt.Errorf("The map key [%s] can't be used on a %s, which is not a map", key,
+			value.Type())
+	}
+
+	k, err := mapKey(value.Type().Key(), key)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	entry := value.MapIndex(k)
+	if !entry.IsValid() {
+		return reflect.Zero(value.Type().Elem()), nil
+	}
+	return entry, nil
+}
+
+// mapKey converts the key from a field path to the key type of a map
+func mapKey(keyType reflect.Type, key string) (reflect.Value, error) {
+	switch keyType.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(key).Convert(keyType), nil
+	case reflect.Interface:
+		if reflect.TypeOf(key).Implements(keyType) {
+			return reflect.ValueOf(key).Convert(keyType), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(key, 10, keyType.Bits())
+		if err == nil {
+			return reflect.ValueOf(i).Convert(keyType), nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(key, 10, keyType.Bits())
+		if err == nil {
+			return reflect.ValueOf(u).Convert(keyType), nil
+		}
+	}
+
+	return reflect.Value{}, fmt.Errorf("The map key [%s] can't be converted to the map's key type %s", key, keyType)
+}
 
+// isMapField returns whether the field path reads an entry of a map
+func isMapField(field string) bool {
+	return strings.Contains(field, "[")
 }
 
 func (c *Criterion) op(op int, value interface{}) *Query {
@@ -372,6 +796,54 @@ func (c *Criterion) HasKey(value interface{}) *Query {
 	return c.op(hk, value)
 }
 
+// HasValue tests if the field is a map with a value matching the passed in value.  Values of a map of interface{}
+// that are a different type than the passed in value don't match
+func (c *Criterion) HasValue(value interface{}) *Query {
+	return c.op(hv, value)
+}
+
+// HasPrefix tests if the current field is a string that starts with the passed in prefix.  If the field has an
+// index, the index is only read from the prefix on
+func (c *Criterion) HasPrefix(prefix string) *Query {
+	return c.op(hp, prefix)
+}
+
+// HasSuffix tests if the current field is a string that ends with the passed in suffix
+func (c *Criterion) HasSuffix(suffix string) *Query {
+	return c.op(hs, suffix)
+}
+
+// ContainsString tests if the current field is a string that contains the passed in substring
+func (c *Criterion) ContainsString(substring string) *Query {
+	return c.op(cs, substring)
+}
+
+// EqFold tests if the current field is a string that is equal to the passed in value, ignoring case
+func (c *Criterion) EqFold(value string) *Query {
+	return c.op(ef, value)
+}
+
+// Len tests the length of the current field, which must be a slice, array, map or string, rather than its value.
+// The length is an int, so it's compared against int values
+//
+//	Where("Items").Len().Gt(3)
+func (c *Criterion) Len() *Criterion {
+	c.length = true
+	return c
+}
+
+// ElemMatch tests if any element of the current field, which must be a slice, array or map, matches the passed in
+// query.  The query's criteria are on the fields of the elements, and criteria on bolthold.Key test the element
+// itself, for slices of values that aren't structs.  Elements of maps are their values
+//
+//	Where("LineItems").ElemMatch(bolthold.Where("SKU").Eq("x").And("Qty").Gt(5))
+func (c *Criterion) ElemMatch(query *Query) *Query {
+	if query == nil {
+		panic("ElemMatch query cannot be nil")
+	}
+	return c.op(em, query)
+}
+
 // RegExp will test if a field matches against the regular expression
 // The Field Value will be converted to string (%s) before testing
 func (c *Criterion) RegExp(expression *regexp.Regexp) *Query {
@@ -462,10 +934,14 @@ func (c *Criterion) MatchFunc(match interface{}) *Query {
 }
 
 // test if the criterion passes with the passed in value
-func (c *Criterion) test(s *Store, testValue interface{}, encoded bool, currentRow interface{}) (bool, error) {
+// if decode is not nil, then testValue is encoded and will be decoded with it before testing
+func (c *Criterion) test(s *Store, testValue interface{}, decode DecodeFunc, currentRow interface{}) (bool, error) {
 	var recordValue interface{}
-	if encoded {
-		if len(testValue.([]byte)) != 0 {
+	if decode != nil {
+		if isNilIndexKey(testValue.([]byte)) {
+			// a nil field of a composite index key
+			recordValue = nil
+		} else if len(testValue.([]byte)) != 0 {
 			// used with keys
 			if c.operator == in || c.operator == any || c.operator == all {
 				// value is a slice of values, use c.values
@@ -473,7 +949,14 @@ func (c *Criterion) test(s *Store, testValue interface{}, encoded bool, currentR
 			} else {
 				recordValue = newElemType(c.value)
 			}
-			err := s.decode(testValue.([]byte), recordValue)
+			err := decode(testValue.([]byte), recordValue)
+			if _, mismatch := err.(*ErrTypeMismatch); mismatch && c.promotesNumbers(s) {
+				// the index holds numbers of a different type than the criterion's value, which are compared by
+				// their value instead
+				var natural interface{}
+				recordValue = &natural
+				err = decode(testValue.([]byte), recordValue)
+			}
 			if err != nil {
 				return false, err
 			}
@@ -483,10 +966,21 @@ func (c *Criterion) test(s *Store, testValue interface{}, encoded bool, currentR
 		recordValue = testValue
 	}
 
+	if c.length {
+		var err error
+		recordValue, err = valueLen(recordValue)
+		if err != nil {
+			return false, err
+		}
+	}
+
 	switch c.operator {
 	case in:
 		for i := range c.values {
-			result, err := c.compare(recordValue, c.values[i], currentRow)
+			result, err := c.compare(s, recordValue, c.values[i], currentRow)
+			if nilMismatch(err) {
+				continue
+			}
 			if err != nil {
 				return false, err
 			}
@@ -499,8 +993,14 @@ func (c *Criterion) test(s *Store, testValue interface{}, encoded bool, currentR
 	case hk:
 		v := reflect.ValueOf(recordValue).MapIndex(reflect.ValueOf(c.value))
 		return !reflect.ValueOf(v).IsZero(), nil
+	case hv:
+		return c.testMapValues(s, recordValue, currentRow)
 	case re:
 		return c.value.(*regexp.Regexp).Match([]byte(fmt.Sprintf("%s", recordValue))), nil
+	case hp, hs, cs, ef:
+		return c.testString(recordValue)
+	case em:
+		return c.testElements(s, recordValue)
 	case fn:
 		fnVal := reflect.ValueOf(c.value)
 		fnType := reflect.TypeOf(c.value)
@@ -533,7 +1033,19 @@ func (c *Criterion) test(s *Store, testValue interface{}, encoded bool, currentR
 		}
 		return false, out[1].Interface().(error)
 	case isnil:
-		return reflect.ValueOf(recordValue).IsNil(), nil
+		v, ok := recordValue.(reflect.Value)
+		if !ok {
+			v = reflect.ValueOf(recordValue)
+		}
+		switch v.Kind() {
+		case reflect.Invalid:
+			// a missing entry in a map of interface{} values is an untyped nil
+			return true, nil
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Chan, reflect.Func:
+			return v.IsNil(), nil
+		}
+		// entries of a map of interface{} values can hold values that are never nil
+		return false, nil
 	case contains, any, all:
 		slc := reflect.ValueOf(recordValue)
 		kind := slc.Kind()
@@ -547,7 +1059,10 @@ func (c *Criterion) test(s *Store, testValue interface{}, encoded bool, currentR
 
 		if c.operator == contains {
 			for i := 0; i < slc.Len(); i++ {
-				result, err := c.compare(slc.Index(i), c.value, currentRow)
+				result, err := c.compare(s, slc.Index(i), c.value, currentRow)
+				if nilMismatch(err) {
+					continue
+				}
 				if err != nil {
 					return false, err
 				}
@@ -561,7 +1076,10 @@ func (c *Criterion) test(s *Store, testValue interface{}, encoded bool, currentR
 		if c.operator == any {
 			for i := 0; i < slc.Len(); i++ {
 				for k := range c.values {
-					result, err := c.compare(slc.Index(i), c.values[k], currentRow)
+					result, err := c.compare(s, slc.Index(i), c.values[k], currentRow)
+					if nilMismatch(err) {
+						continue
+					}
 					if err != nil {
 						return false, err
 					}
@@ -578,7 +1096,10 @@ func (c *Criterion) test(s *Store, testValue interface{}, encoded bool, currentR
 		for k := range c.values {
 			found := false
 			for i := 0; i < slc.Len(); i++ {
-				result, err := c.compare(slc.Index(i), c.values[k], currentRow)
+				result, err := c.compare(s, slc.Index(i), c.values[k], currentRow)
+				if nilMismatch(err) {
+					continue
+				}
 				if err != nil {
 					return false, err
 				}
@@ -596,7 +1117,11 @@ func (c *Criterion) test(s *Store, testValue interface{}, encoded bool, currentR
 
 	default:
 		//comparison operators
-		result, err := c.compare(recordValue, c.value, currentRow)
+		result, err := c.compare(s, recordValue, c.value, currentRow)
+		if nilMismatch(err) {
+			// nil values are only equal to nil, and are neither greater nor less than any other value
+			return c.operator == ne, nil
+		}
 		if err != nil {
 			return false, err
 		}
@@ -620,10 +1145,32 @@ func (c *Criterion) test(s *Store, testValue interface{}, encoded bool, currentR
 	}
 }
 
-func matchesAllCriteria(s *Store, criteria []*Criterion, value interface{}, encoded bool,
+func matchesAllCriteria(s *Store, criteria []*Criterion, value interface{}, decode DecodeFunc,
 	currentRow interface{}) (bool, error) {
 	for i := range criteria {
-		ok, err := criteria[i].test(s, value, encoded, currentRow)
+		ok, err := criteria[i].test(s, value, decode, currentRow)
+		if err != nil {
+			return false, err
+		}
+
+		if criteria[i].negate == ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// matchesMapCriteria is matchesAllCriteria for the entry of a map.  Entries of the same map can be different types,
+// or missing, so an entry that can't be compared with a criterion's value doesn't match the criterion rather than
+// returning an error
+func matchesMapCriteria(s *Store, criteria []*Criterion, value interface{}, decode DecodeFunc,
+	currentRow interface{}) (bool, error) {
+	for i := range criteria {
+		ok, err := criteria[i].test(s, value, decode, currentRow)
+		if _, mismatch := err.(*ErrTypeMismatch); mismatch {
+			ok, err = false, nil
+		}
 		if err != nil {
 			return false, err
 		}
@@ -648,29 +1195,143 @@ func startsUpper(str string) bool {
 	return false
 }
 
-func (q *Query) String() string {
-	s := ""
+// valueLen returns the length of a slice, array, map or string value.  Nil values have a length of 0
+func valueLen(value interface{}) (int, error) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return 0, nil
+		}
+		v = v.Elem()
+	}
 
-	if q.index != "" {
-		s += "Using Index [" + q.index + "] "
+	switch v.Kind() {
+	case reflect.Invalid:
+		return 0, nil
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
+		return v.Len(), nil
 	}
 
-	s += "Where "
-	for field, criteria := range q.fieldCriteria {
-		for i := range criteria {
-			s += field + " " + criteria[i].String()
-			s += "\n\tAND "
+	return 0, fmt.Errorf("Len can't be used on a %s, only on slices, arrays, maps and strings", v.Type())
+}
+
+// testElements tests if any element of the slice, array or map value matches the criterion's query
+func (c *Criterion) testElements(s *Store, recordValue interface{}) (bool, error) {
+	v := reflect.ValueOf(recordValue)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return false, nil
 		}
+		v = v.Elem()
 	}
 
-	// remove last AND
-	s = s[:len(s)-6]
+	var elems []reflect.Value
+	switch v.Kind() {
+	case reflect.Invalid:
+		return false, nil
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			elems = append(elems, v.Index(i))
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			elems = append(elems, iter.Value())
+		}
+	default:
+		return false, fmt.Errorf("ElemMatch can't be used on a %s, only on slices, arrays and maps", v.Type())
+	}
 
-	for i := range q.ors {
-		s += "\nOr " + q.ors[i].String()
+	query := c.value.(*Query)
+	for _, elem := range elems {
+		err := query.contextErr()
+		if err != nil {
+			return false, err
+		}
+
+		for elem.Kind() == reflect.Interface && !elem.IsNil() {
+			elem = elem.Elem()
+		}
+
+		// elements are matched as records, so Field criteria can refer to the other fields of the element
+		row := elem
+		if elem.Kind() != reflect.Ptr {
+			row = reflect.New(elem.Type())
+			row.Elem().Set(elem)
+		} else if elem.IsNil() {
+			continue
+		}
+
+		ok, err := query.matchesGroup(s, c.query.source, nil, row, row.Interface())
+		if err != nil || ok {
+			return ok, err
+		}
 	}
 
-	return s
+	return false, nil
+}
+
+// testMapValues tests whether any of the values of the map match the criterion's value
+func (c *Criterion) testMapValues(s *Store, recordValue interface{}, currentRow interface{}) (bool, error) {
+	v := reflect.ValueOf(recordValue)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return false, nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Map {
+		return false, fmt.Errorf("HasValue can't be used on a %s, only on maps", reflect.TypeOf(recordValue))
+	}
+
+	dynamic := v.Type().Elem().Kind() == reflect.Interface
+	iter := v.MapRange()
+	for iter.Next() {
+		result, err := c.compare(s, iter.Value().Interface(), c.value, currentRow)
+		if _, ok := err.(*ErrTypeMismatch); ok && dynamic {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if result == 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// testString tests the criterion against a string value, nil values never match
+func (c *Criterion) testString(recordValue interface{}) (bool, error) {
+	value := reflect.ValueOf(recordValue)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return false, nil
+		}
+		value = value.Elem()
+	}
+
+	if !value.IsValid() {
+		return false, nil
+	}
+
+	if value.Kind() != reflect.String {
+		return false, &ErrTypeMismatch{recordValue, c.value}
+	}
+
+	str := value.String()
+	switch c.operator {
+	case hp:
+		return strings.HasPrefix(str, c.value.(string)), nil
+	case hs:
+		return strings.HasSuffix(str, c.value.(string)), nil
+	case cs:
+		return strings.Contains(str, c.value.(string)), nil
+	default:
+		return strings.EqualFold(str, c.value.(string)), nil
+	}
 }
 
 func (c *Criterion) String() string {
@@ -678,6 +1339,9 @@ func (c *Criterion) String() string {
 	if c.negate {
 		s += "NOT "
 	}
+	if c.length {
+		s += "length "
+	}
 	switch c.operator {
 	case eq:
 		s += "=="
@@ -699,6 +1363,20 @@ func (c *Criterion) String() string {
 		s += "matches the function"
 	case isnil:
 		return "is nil"
+	case hk:
+		s += "has the key"
+	case hv:
+		s += "has the value"
+	case hp:
+		s += "starts with"
+	case hs:
+		s += "ends with"
+	case cs:
+		s += "contains the string"
+	case ef:
+		s += "equals ignoring case"
+	case em:
+		return s + "has an element matching " + queryGroup{query: c.value.(*Query)}.text()
 	case contains:
 		s += "contains"
 	case any:
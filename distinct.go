@@ -0,0 +1,254 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// FindDistinct sets the unique values of the field, in the records of dataType that match the query, on the result
+// slice in ascending order.  Result must be a pointer to a slice of the field's type, or the type it points to for
+// pointer fields.  Nil values are left out, the same as they're left out of indexes.
+// If the field has an index, and the query's criteria are only on that field, the values are read from the index
+// without reading any records.
+func (s *Store) FindDistinct(dataType interface{}, field string, query *Query, result interface{}) error {
+	return s.Bolt().View(func(tx *bolt.Tx) error {
+		return s.TxFindDistinct(tx, dataType, field, query, result)
+	})
+}
+
+// TxFindDistinct is the same as FindDistinct but you get to specify your transaction
+func (s *Store) TxFindDistinct(tx *bolt.Tx, dataType interface{}, field string, query *Query,
+	result interface{}) error {
+	return s.findDistinct(tx, dataType, field, query, result)
+}
+
+// FindDistinctInBucket is the same as FindDistinct but you get to specify your parent bucket
+func (s *Store) FindDistinctInBucket(parent *bolt.Bucket, dataType interface{}, field string, query *Query,
+	result interface{}) error {
+	return s.findDistinct(parent, dataType, field, query, result)
+}
+
+func (s *Store) findDistinct(source BucketSource, dataType interface{}, field string, query *Query,
+	result interface{}) error {
+	if query == nil {
+		query = &Query{}
+	}
+
+	if !startsUpper(field) {
+		panic("The first letter of a field must be upper-case")
+	}
+
+	resultVal := reflect.ValueOf(result)
+	if resultVal.Kind() != reflect.Ptr || resultVal.Elem().Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	fieldType, err := sortFieldType(tp, field)
+	if err != nil {
+		return err
+	}
+
+	storer := s.newStorer(dataType)
+
+	var values []reflect.Value
+	if index := s.distinctIndex(source, storer, field, fieldType, query); index != "" {
+		values, err = s.indexDistinct(source, storer, index, field, fieldType, query)
+	} else {
+		values, err = s.queryDistinct(source, dataType, field, query)
+	}
+	if err != nil {
+		return err
+	}
+
+	sliceVal := resultVal.Elem()
+	elType := sliceVal.Type().Elem()
+
+	for i := range values {
+		if !values[i].Type().AssignableTo(elType) {
+			return fmt.Errorf("Values of the field %s are of type %s, and can't be put in a slice of %s", field,
+				values[i].Type(), elType)
+		}
+		sliceVal = reflect.Append(sliceVal, values[i])
+	}
+
+	resultVal.Elem().Set(sliceVal)
+
+	return nil
+}
+
+// distinctIndex returns the index the distinct values of the field can be read from, or an empty string if they have
+// to be read from the records.  Like a covered query, only indexes defined with struct tags, on kinds of values
+// that are read back from the index exactly as they were written, are used.
+func (s *Store) distinctIndex(source BucketSource, storer Storer, field string, fieldType reflect.Type,
+	query *Query) string {
+	if _, ok := storer.(*anonStorer); !ok {
+		return ""
+	}
+
+	if !coveredKind(fieldType) || len(query.ors) > 0 || len(query.groups) > 0 || query.skip != 0 ||
+		query.limit != 0 || query.page != nil {
+		return ""
+	}
+
+	for queryField, criteria := range query.fieldCriteria {
+		if queryField != field || !canUseIndex(criteria) {
+			return ""
+		}
+		for _, c := range criteria {
+			if _, ok := c.value.(Field); ok {
+				return ""
+			}
+		}
+	}
+
+	names := make([]string, 0, len(storer.Indexes()))
+	for name := range storer.Indexes() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fields := indexFields(storer, name)
+		if len(fields) != 1 || fields[0] != field {
+			continue
+		}
+
+		iBucket := source.Bucket(indexBucketName(storer.Type(), name))
+		if iBucket != nil && iBucket.Sequence() == indexVersion {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// indexDistinct reads the distinct values of the field from the keys of its index
+func (s *Store) indexDistinct(source BucketSource, storer Storer, index, field string, fieldType reflect.Type,
+	query *Query) ([]reflect.Value, error) {
+	iBucket := source.Bucket(indexBucketName(storer.Type(), index))
+	fields := []string{field}
+	qCopy := *query
+	qCopy.indexFields = fields
+	query = &qCopy
+
+	var values []reflect.Value
+
+	cursor := iBucket.Cursor()
+	rng := s.newIndexRange(cursor, fields, query.fieldCriteria)
+
+	for k, v := rng.seek(cursor); k != nil && !rng.past(k); k, v = cursor.Next() {
+		err := query.contextErr()
+		if err != nil {
+			return nil, err
+		}
+
+		if v != nil || iBucket.Bucket(k).Sequence() == 0 {
+			continue
+		}
+
+		ok, err := s.matchesIndexKey(k, fields, query, s.decodeIndexKey)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		value := reflect.New(fieldType).Elem()
+		_, err = s.decodeIndexKeyValue(k, value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// queryDistinct runs the query, and returns the distinct values of the field in the matching records in order
+func (s *Store) queryDistinct(source BucketSource, dataType interface{}, field string,
+	query *Query) ([]reflect.Value, error) {
+	// only the field is needed, so the query can be read from an index that covers it
+	qCopy := *query
+	qCopy.selected = []string{field}
+
+	var values []reflect.Value
+	seen := make(map[interface{}]struct{})
+
+	err := s.runQuery(source, dataType, &qCopy, nil, qCopy.skip, func(r *record) error {
+		fVal, err := fieldValue(r.value.Elem(), field)
+		if err != nil {
+			return err
+		}
+
+		value := reflect.ValueOf(fVal)
+		if rv, ok := fVal.(reflect.Value); ok {
+			value = rv
+		}
+		for value.IsValid() && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return nil
+			}
+			value = value.Elem()
+		}
+		if !value.IsValid() {
+			return nil
+		}
+
+		// values of other kinds can hold values that can't be map keys, and are only removed once they're sorted
+		if coveredKind(value.Type()) {
+			if _, ok := seen[value.Interface()]; ok {
+				return nil
+			}
+			seen[value.Interface()] = struct{}{}
+		}
+		values = append(values, value)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// values that aren't equal with == can still compare as equal, so the duplicates left are removed once the values
+	// are sorted
+	var cmpErr error
+	sort.SliceStable(values, func(i, j int) bool {
+		c, err := s.compare(values[i].Interface(), values[j].Interface())
+		if err != nil && cmpErr == nil {
+			cmpErr = err
+		}
+		return c < 0
+	})
+	if cmpErr != nil {
+		return nil, cmpErr
+	}
+
+	distinct := values[:0]
+	for i := range values {
+		if len(distinct) > 0 {
+			c, err := s.compare(distinct[len(distinct)-1].Interface(), values[i].Interface())
+			if err != nil {
+				return nil, err
+			}
+			if c == 0 {
+				continue
+			}
+		}
+		distinct = append(distinct, values[i])
+	}
+
+	return distinct, nil
+}
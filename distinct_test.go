@@ -0,0 +1,79 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/timshannon/bolthold"
+)
+
+// distinctColors returns the distinct colors of the test data in the category, in order
+func distinctColors(category string) []string {
+	seen := make(map[string]bool)
+	var colors []string
+	for i := range testData {
+		if testData[i].Category == category && !seen[testData[i].Color] {
+			seen[testData[i].Color] = true
+			colors = append(colors, testData[i].Color)
+		}
+	}
+	sort.Strings(colors)
+	return colors
+}
+
+func TestFindDistinct(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		var categories []string
+		ok(t, store.FindDistinct(&ItemTest{}, "Category", nil, &categories))
+		equals(t, []string{"animal", "food", "vehicle"}, categories)
+
+		categories = nil
+		ok(t, store.FindDistinct(&ItemTest{}, "Category", bolthold.Where("Category").Gt("animal"), &categories))
+		equals(t, []string{"food", "vehicle"}, categories)
+
+		var colors []string
+		ok(t, store.FindDistinct(&ItemTest{}, "Color", bolthold.Where("Category").Eq("food"), &colors))
+		equals(t, distinctColors("food"), colors)
+
+		// the query's limit applies to the records read, not the values
+		colors = nil
+		ok(t, store.FindDistinct(&ItemTest{}, "Color", bolthold.Where("Category").Eq("food").Limit(1), &colors))
+		equals(t, 1, len(colors))
+
+		var empty []string
+		ok(t, store.FindDistinct(&ItemTest{}, "Color", bolthold.Where("Category").Eq("none"), &empty))
+		equals(t, 0, len(empty))
+
+		var ints []int
+		err := store.FindDistinct(&ItemTest{}, "Color", nil, &ints)
+		assert(t, err != nil, "No error putting string values in an int slice")
+
+		err = store.FindDistinct(&ItemTest{}, "DoesntExist", nil, &ints)
+		assert(t, err != nil, "No error finding the values of a field that doesn't exist")
+	})
+}
+
+func TestFindDistinctIndex(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+		corruptRecord(t, store, "ItemTest", testData[0].Key)
+
+		// indexed values are read without reading the records
+		var categories []string
+		ok(t, store.FindDistinct(&ItemTest{}, "Category", bolthold.Where("Category").Ne("food"), &categories))
+		equals(t, []string{"animal", "vehicle"}, categories)
+
+		var colors []string
+		err := store.FindDistinct(&ItemTest{}, "Color", nil, &colors)
+		assert(t, err != nil, "Values of a field without an index were read without reading the records")
+
+		err = store.FindDistinct(&ItemTest{}, "Category", bolthold.Where("Color").Eq("blue"), &categories)
+		assert(t, err != nil, "Values were read from the index with criteria on other fields")
+	})
+}
@@ -0,0 +1,167 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/timshannon/bolthold"
+)
+
+type LineItem struct {
+	SKU     string
+	Qty     int
+	Shipped int
+}
+
+type ElemOrder struct {
+	ID       int `boltholdKey:"ID"`
+	Items    []LineItem
+	Returns  []*LineItem
+	ByWeek   map[string]LineItem
+	Codes    []int
+	Customer string
+}
+
+func insertElemData(t *testing.T, store *bolthold.Store) []ElemOrder {
+	orders := []ElemOrder{
+		{ID: 1, Items: []LineItem{{SKU: "a", Qty: 10, Shipped: 10}, {SKU: "b", Qty: 1}}, Codes: []int{1, 2},
+			Customer: "Acme"},
+		{ID: 2, Items: []LineItem{{SKU: "a", Qty: 2, Shipped: 1}, {SKU: "c", Qty: 7, Shipped: 3}},
+			Returns: []*LineItem{{SKU: "c", Qty: 1}}, Codes: []int{5}},
+		{ID: 3, ByWeek: map[string]LineItem{"w1": {SKU: "a", Qty: 6}, "w2": {SKU: "d", Qty: 1}},
+			Customer: "Globex"},
+		{ID: 4},
+	}
+
+	for i := range orders {
+		ok(t, store.Insert(orders[i].ID, &orders[i]))
+	}
+	return orders
+}
+
+func TestElemMatch(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertElemData(t, store)
+
+		tests := []struct {
+			name  string
+			query *bolthold.Query
+			want  []int
+		}{
+			{"Struct Slice",
+				bolthold.Where("Items").ElemMatch(bolthold.Where("SKU").Eq("a").And("Qty").Gt(5)),
+				[]int{1}},
+			{"Criteria on Different Elements",
+				bolthold.Where("Items").ElemMatch(bolthold.Where("SKU").Eq("b").And("Qty").Gt(5)),
+				[]int{}},
+			{"Pointer Slice",
+				bolthold.Where("Returns").ElemMatch(bolthold.Where("SKU").Eq("c")),
+				[]int{2}},
+			{"Map Values",
+				bolthold.Where("ByWeek").ElemMatch(bolthold.Where("SKU").Eq("a").And("Qty").Ge(6)),
+				[]int{3}},
+			{"Values",
+				bolthold.Where("Codes").ElemMatch(bolthold.Where(bolthold.Key).Ge(2).And(bolthold.Key).Lt(5)),
+				[]int{1}},
+			{"Field",
+				bolthold.Where("Items").ElemMatch(bolthold.Where("Shipped").Lt(bolthold.Field("Qty"))),
+				[]int{1, 2}},
+			{"Or",
+				bolthold.Where("Items").ElemMatch(bolthold.Where("SKU").Eq("b").Or(bolthold.Where("Qty").Eq(7))),
+				[]int{1, 2}},
+			{"Not",
+				bolthold.Where("Items").Not().ElemMatch(bolthold.Where("SKU").Eq("c")),
+				[]int{1, 3, 4}},
+			{"Empty Query",
+				bolthold.Where("Items").ElemMatch(&bolthold.Query{}),
+				[]int{1, 2}},
+			{"Len",
+				bolthold.Where("Items").Len().Eq(2),
+				[]int{1, 2}},
+			{"Len Nil",
+				bolthold.Where("Codes").Len().Eq(0),
+				[]int{3, 4}},
+			{"Len Map",
+				bolthold.Where("ByWeek").Len().Gt(1),
+				[]int{3}},
+			{"Len String",
+				bolthold.Where("Customer").Len().In(4, 6),
+				[]int{1, 3}},
+			{"Not Len",
+				bolthold.Where("Codes").Not().Len().Lt(2),
+				[]int{1}},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				var result []ElemOrder
+				ok(t, store.Find(&result, tst.query))
+
+				ids := []int{}
+				for i := range result {
+					ids = append(ids, result[i].ID)
+				}
+				equals(t, tst.want, ids)
+
+				ok(t, tst.query.Validate(&ElemOrder{}))
+
+				parsed, err := bolthold.ParseQuery(tst.query.String())
+				ok(t, err)
+				equals(t, tst.query.String(), parsed.String())
+
+				data, err := json.Marshal(tst.query)
+				ok(t, err)
+				unmarshaled := &bolthold.Query{}
+				ok(t, json.Unmarshal(data, unmarshaled))
+				equals(t, tst.query.String(), unmarshaled.String())
+
+				result = nil
+				ok(t, store.Find(&result, parsed))
+				equals(t, len(tst.want), len(result))
+			})
+		}
+	})
+}
+
+func TestElemMatchErrors(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertElemData(t, store)
+
+		var result []ElemOrder
+		err := store.Find(&result, bolthold.Where("Customer").ElemMatch(bolthold.Where("SKU").Eq("a")))
+		assert(t, err != nil, "No error running ElemMatch on a string field")
+
+		err = store.Find(&result, bolthold.Where("ID").Len().Eq(1))
+		assert(t, err != nil, "No error running Len on an int field")
+
+		invalid := []*bolthold.Query{
+			bolthold.Where("Customer").ElemMatch(bolthold.Where("SKU").Eq("a")),
+			bolthold.Where("Items").ElemMatch(bolthold.Where("DoesntExist").Eq("a")),
+			bolthold.Where("Items").ElemMatch(bolthold.Where("Qty").Eq("a")),
+			bolthold.Where("ID").Len().Eq(1),
+			bolthold.Where("Items").Len().Eq(int64(1)),
+		}
+		for _, query := range invalid {
+			assert(t, query.Validate(&ElemOrder{}) != nil, "No error validating %s", query)
+		}
+
+		_, err = bolthold.ParseQuery(`Items ELEMMATCH SKU = "a"`)
+		assert(t, err != nil, "No error parsing ELEMMATCH without parentheses")
+
+		_, err = bolthold.ParseQuery(`LEN(Items > 2`)
+		assert(t, err != nil, "No error parsing LEN without a closing parenthesis")
+
+		err = json.Unmarshal([]byte(`{"criteria":[{"field":"Items","op":"ElemMatch"}]}`), &bolthold.Query{})
+		assert(t, err != nil, "No error unmarshaling ElemMatch without a query")
+
+		assert(t, func() (panicked bool) {
+			defer func() { panicked = recover() != nil }()
+			bolthold.Where("Items").ElemMatch(nil)
+			return false
+		}(), "ElemMatch with a nil query didn't panic")
+	})
+}
@@ -449,6 +449,11 @@ var testResults = []test{
 		query:  bolthold.Where("Category").Eq("vehicle").Or(bolthold.Where("Category").Eq("animal")).Skip(8),
 		result: []int{16, 9, 13, 14},
 	},
+	{
+		name:   "Limit with Or query, that crosses or boundary",
+		query:  bolthold.Where("Category").Eq("vehicle").Or(bolthold.Where("Category").Eq("animal")).Limit(7),
+		result: []int{0, 1, 3, 6, 11, 2, 5},
+	},
 	{
 		name:   "Limit",
 		query:  bolthold.Where(bolthold.Key).Gt(testData[10].Key).Limit(5),
@@ -779,45 +784,14 @@ func TestQueryStringPrint(t *testing.T) {
 		And("SecondField").ContainsAny("val1", "val2", "val3").
 		And("ThirdField").ContainsAll("val1", "val2", "val3")
 
-	contains := []string{
-		"FirstField == first value",
-		"SecondField > Second Value",
-		"ThirdField < Third Value",
-		"FourthField >= FourthValue",
-		"FifthField <= FifthValue",
-		"SixthField != Sixth Value",
-		"FirstField in [val1 val2 val3]",
-		"FirstField matches the function",
-		"SecondField is nil",
-		"ThirdField matches the regular expression test",
-		"Using Index [IndexName]",
-		"FirstField NOT == negative",
-		"FirstField contains value",
-		"SecondField contains any of [val1 val2 val3]",
-		"ThirdField contains all of [val1 val2 val3]",
-	}
-
-	// map order isn't guaranteed, check if all needed lines exist
-
-	tst := q.String()
-
-	tstLines := strings.Split(tst, "\n")
-
-	for i := range contains {
-		found := false
-		for k := range tstLines {
-			if strings.Contains(tstLines[k], contains[i]) {
-				found = true
-				break
-			}
-		}
-
-		if !found {
-			t.Fatalf("Line %s was not found in the result \n%s", contains[i], tst)
-		}
-
-	}
+	expected := `FifthField <= "FifthValue" AND FirstField == "first value" AND NOT FirstField == "negative" AND ` +
+		`FirstField CONTAINS "value" AND FourthField >= "FourthValue" AND SecondField > "Second Value" AND ` +
+		`SecondField CONTAINS ANY ("val1", "val2", "val3") AND SixthField != "Sixth Value" AND ` +
+		`ThirdField < "Third Value" AND ThirdField CONTAINS ALL ("val1", "val2", "val3") ` +
+		`OR FirstField IN ("val1", "val2", "val3") AND FirstField MATCHFUNC AND SecondField IS NIL AND ` +
+		`ThirdField =~ /test/ USING INDEX IndexName`
 
+	equals(t, expected, q.String())
 }
 
 func TestSkip(t *testing.T) {
@@ -1174,9 +1148,13 @@ func TestFindOne(t *testing.T) {
 					t.Fatalf("Error finding one data from bolthold: %s", err)
 				}
 
-				if !result.equal(&testData[tst.result[0]]) {
-					t.Fatalf("Result doesnt match the first record in the testing result set. "+
-						"Expected key of %d got %d", &testData[tst.result[0]].Key, result.Key)
+				// the results are checked against the testing result set, in any order, by the Find tests
+				var first []ItemTest
+				ok(t, store.Find(&first, tst.query))
+
+				if !result.equal(&first[0]) {
+					t.Fatalf("Result doesnt match the first record found by the same query. "+
+						"Expected key of %d got %d", first[0].Key, result.Key)
 				}
 			})
 		}
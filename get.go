@@ -7,7 +7,6 @@ package bolthold
 import (
 	"errors"
 	"reflect"
-	"strings"
 
 	bolt "go.etcd.io/bbolt"
 )
@@ -61,17 +60,8 @@ func (s *Store) get(source BucketSource, key, result interface{}) error {
 		tp = tp.Elem()
 	}
 
-	var keyField string
-
-	for i := 0; i < tp.NumField(); i++ {
-		if strings.Contains(string(tp.Field(i).Tag), BoltholdKeyTag) {
-			keyField = tp.Field(i).Name
-			break
-		}
-	}
-
-	if keyField != "" {
-		err := s.decode(gk, reflect.ValueOf(result).Elem().FieldByName(keyField).Addr().Interface())
+	if field, ok := keyField(tp); ok {
+		err := s.decode(gk, reflect.ValueOf(result).Elem().FieldByName(field.Name).Addr().Interface())
 		if err != nil {
 			return err
 		}
@@ -1,6 +1,6 @@
 module github.com/timshannon/bolthold
 
-go 1.16
+go 1.18
 
 require (
 	go.etcd.io/bbolt v1.3.8
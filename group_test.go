@@ -0,0 +1,124 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/timshannon/bolthold"
+)
+
+// matchingKeys returns the keys of the test data that match, in key order
+func matchingKeys(match func(item *ItemTest) bool) []int {
+	keys := []int{}
+	for i := range testData {
+		if match(&testData[i]) {
+			keys = append(keys, testData[i].Key)
+		}
+	}
+	return keys
+}
+
+func TestGroups(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		tests := []struct {
+			name  string
+			query *bolthold.Query
+			match func(item *ItemTest) bool
+		}{
+			{"And Or Group",
+				bolthold.Where("Category").Eq("food").AndGroup(bolthold.Where("Color").Eq("red").
+					Or(bolthold.Where("Color").Eq("yellow"))),
+				func(item *ItemTest) bool {
+					return item.Category == "food" && (item.Color == "red" || item.Color == "yellow")
+				}},
+			{"Not",
+				bolthold.Not(bolthold.Where("Category").Eq("food").And("Color").Eq("red")),
+				func(item *ItemTest) bool {
+					return !(item.Category == "food" && item.Color == "red")
+				}},
+			{"Not Or",
+				bolthold.Where("Category").Ne("animal").AndGroup(bolthold.Not(bolthold.Where("Name").Eq("car").
+					Or(bolthold.Where(bolthold.Key).Lt(4)))),
+				func(item *ItemTest) bool {
+					return item.Category != "animal" && !(item.Name == "car" || item.Key < 4)
+				}},
+			{"Nested",
+				bolthold.Where("Category").Eq("food").AndGroup(bolthold.Where("Color").Eq("red").
+					AndGroup(bolthold.Not(bolthold.Where("Fruit").Eq("apple")))).
+					Or(bolthold.Where("Category").Eq("animal")),
+				func(item *ItemTest) bool {
+					return (item.Category == "food" && item.Color == "red" && item.Fruit != "apple") ||
+						item.Category == "animal"
+				}},
+			{"Empty Group",
+				bolthold.Where("Category").Eq("vehicle").AndGroup(&bolthold.Query{}),
+				func(item *ItemTest) bool {
+					return item.Category == "vehicle"
+				}},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				var result []ItemTest
+				ok(t, store.Find(&result, tst.query))
+
+				keys := []int{}
+				for i := range result {
+					keys = append(keys, result[i].Key)
+				}
+				equals(t, matchingKeys(tst.match), keys)
+
+				count, err := store.Count(&ItemTest{}, tst.query)
+				ok(t, err)
+				equals(t, len(keys), count)
+
+				parsed, err := bolthold.ParseQuery(tst.query.String())
+				ok(t, err)
+				equals(t, tst.query.String(), parsed.String())
+
+				data, err := json.Marshal(tst.query)
+				ok(t, err)
+				unmarshaled := &bolthold.Query{}
+				ok(t, json.Unmarshal(data, unmarshaled))
+				equals(t, tst.query.String(), unmarshaled.String())
+
+				result = nil
+				ok(t, store.Find(&result, parsed))
+				equals(t, len(keys), len(result))
+			})
+		}
+	})
+}
+
+func TestGroupIndex(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		query := bolthold.Where("Category").Eq("food").AndGroup(bolthold.Not(bolthold.Where("Color").Eq("red").
+			Or(bolthold.Where("Color").Eq("yellow"))))
+
+		plan, err := store.Profile(&ItemTest{}, query)
+		ok(t, err)
+		equals(t, "Category", plan.Index)
+		assert(t, !plan.Scan, "Grouped query didn't use the index")
+		equals(t, []string{`NOT (Color == "red" OR Color == "yellow")`}, plan.Criteria)
+		equals(t, len(matchingKeys(func(item *ItemTest) bool { return item.Category == "food" })), plan.Scanned)
+
+		// groups are tested against the record, so the query can't be read from the index
+		plan, err = store.Explain(&ItemTest{}, bolthold.Where("Category").Eq("food").
+			AndGroup(bolthold.Where("Name").Ne("")).Select("Category"))
+		ok(t, err)
+		assert(t, !plan.Covered, "Grouped query is covered by the index")
+
+		equals(t, `Category == "food" AND (Color == "red" OR Fruit == "apple") AND NOT (Name == "x")`,
+			bolthold.Where("Category").Eq("food").AndGroup(bolthold.Where("Color").Eq("red").
+				Or(bolthold.Where("Fruit").Eq("apple"))).AndGroup(bolthold.Not(bolthold.Where("Name").Eq("x"))).
+				String())
+	})
+}
@@ -22,15 +22,37 @@ const BoltholdUniqueTag = "boltholdUnique"
 // slice is indexed separately rather than as one index
 const BoltholdSliceIndexTag = "boltholdSliceIndex"
 
+// BoltholdMapIndexTag is the struct tag used to index entries of a map field, with a comma separated list of the
+// map keys to index.  Each map key gets its own index, named after the path of the map entry, i.e. a Attrs field
+// tagged with `boltholdMapIndex:"color,size"` gets the indexes Attrs[color] and Attrs[size]
+const BoltholdMapIndexTag = "boltholdMapIndex"
+
 const indexBucketPrefix = "_index"
 
+// indexVersion is stored as the sequence of each index bucket, to tell indexes written by older versions of bolthold
+// apart from the current layout
+const indexVersion = 1
+
 // size of iterator keys stored in memory before more are fetched
 const iteratorKeyMinCacheSize = 100
 
 // Index is a function that returns the indexable, encoded bytes of the passed in value
+// Fields are the names of the fields, in order, that make up the index.  If no Fields are specified, the index is
+// on the field with the same name as the index, and its keys are decoded with the store's decoder.  An index that
+// specifies its Fields must encode its keys with Store.EncodeIndexKey, passing in the field values in the same order
+// as Fields
 type Index struct {
 	IndexFunc func(name string, value interface{}) ([]byte, error)
 	Unique    bool
+	Fields    []string
+}
+
+// indexFields returns the fields covered by the named index on the storer
+func indexFields(storer Storer, indexName string) []string {
+	if index, ok := storer.Indexes()[indexName]; ok && len(index.Fields) != 0 {
+		return index.Fields
+	}
+	return []string{indexName}
 }
 
 // SliceIndex is a function that returns all of the indexable values in a slice
@@ -48,81 +70,247 @@ func (s *Store) deleteIndexes(storer Storer, source BucketSource, key []byte, or
 }
 
 func (s *Store) updateIndexes(storer Storer, source BucketSource, key []byte, data interface{}, delete bool) error {
-	indexes := storer.Indexes()
-	for name, index := range indexes {
-		indexKey, err := index.IndexFunc(name, data)
+	for name := range storer.Indexes() {
+		err := s.updateIndex(storer, name, source, key, data, delete)
 		if err != nil {
 			return err
 		}
-		if indexKey == nil {
+	}
+
+	for name := range storer.SliceIndexes() {
+		err := s.updateIndex(storer, name, source, key, data, delete)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexKeys returns the index values of the data for the named index, and whether or not the index is unique
+func indexKeys(storer Storer, indexName string, data interface{}) ([][]byte, bool, error) {
+	if index, ok := storer.Indexes()[indexName]; ok {
+		indexKey, err := index.IndexFunc(indexName, data)
+		if err != nil {
+			return nil, false, err
+		}
+		return [][]byte{indexKey}, index.Unique, nil
+	}
+
+	indexKeys, err := storer.SliceIndexes()[indexName](indexName, data)
+	return indexKeys, false, err
+}
+
+// adds or removes a specific index on an item
+func (s *Store) updateIndex(storer Storer, indexName string, source BucketSource, key []byte, data interface{},
+	delete bool) error {
+	if b := source.Bucket(indexBucketName(storer.Type(), indexName)); b != nil && b.Sequence() != indexVersion {
+		return s.updateLegacyIndex(storer, indexName, b, key, data, delete)
+	}
+
+	indexKeys, unique, err := indexKeys(storer, indexName, data)
+	if err != nil {
+		return err
+	}
+
+	hasKeys := false
+	for i := range indexKeys {
+		if indexKeys[i] != nil {
+			hasKeys = true
+			break
+		}
+	}
+
+	if !hasKeys || (delete && source.Bucket(indexBucketName(storer.Type(), indexName)) == nil) {
+		return nil
+	}
+
+	b, err := s.indexBucket(storer, indexName, source, data)
+	if err != nil {
+		return err
+	}
+
+	for i := range indexKeys {
+		if indexKeys[i] == nil {
 			continue
 		}
-		err = s.updateIndex(storer.Type(), name, index.Unique, indexKey, source, key, delete)
+		if delete {
+			err = removeIndexKey(b, indexKeys[i], key)
+		} else {
+			err = addIndexKey(b, unique, indexKeys[i], key)
+		}
 		if err != nil {
 			return err
 		}
 	}
 
-	sliceIndexes := storer.SliceIndexes()
-	for name, index := range sliceIndexes {
-		indexKeys, err := index(name, data)
+	return nil
+}
+
+// indexBucket returns the bucket for the named index.  New indexes are built from the records already in the data
+// bucket, so that an index always covers every record and can be picked automatically by queries.
+func (s *Store) indexBucket(storer Storer, indexName string, source BucketSource, dataType interface{}) (
+	*bolt.Bucket, error) {
+	b := source.Bucket(indexBucketName(storer.Type(), indexName))
+	if b != nil {
+		return b, nil
+	}
+
+	b, err := source.CreateBucketIfNotExists(indexBucketName(storer.Type(), indexName))
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.SetSequence(indexVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	dataBucket := source.Bucket([]byte(storer.Type()))
+	if dataBucket == nil {
+		return b, nil
+	}
+
+	c := dataBucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		value := reflect.New(reflect.TypeOf(dataType)).Interface()
+		err = s.decode(v, value)
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		indexKeys, unique, err := indexKeys(storer, indexName, reflect.ValueOf(value).Elem().Interface())
+		if err != nil {
+			return nil, err
 		}
 
 		for i := range indexKeys {
 			if indexKeys[i] == nil {
 				continue
 			}
-			err = s.updateIndex(storer.Type(), name, false, indexKeys[i], source, key, delete)
+			err = addIndexKey(b, unique, indexKeys[i], k)
 			if err != nil {
-				return err
+				return nil, err
 			}
 		}
 	}
 
-	return nil
+	return b, nil
 }
 
-// adds or removes a specific index on an item
-func (s *Store) updateIndex(typeName, indexName string, unique bool, indexKey []byte, source BucketSource, key []byte,
+// updateLegacyIndex adds or removes the record in an index written by an older version of bolthold, where each index
+// value holds an encoded keyList of the keys of its records.  The index keeps that layout until it's rebuilt by
+// ReIndex, rather than being rebuilt by whichever write happens to touch it first
+func (s *Store) updateLegacyIndex(storer Storer, indexName string, b *bolt.Bucket, key []byte, data interface{},
 	delete bool) error {
-	indexValue := make(keyList, 0)
-
-	b, err := source.CreateBucketIfNotExists(indexBucketName(typeName, indexName))
+	indexKeys, unique, err := indexKeys(storer, indexName, data)
 	if err != nil {
 		return err
 	}
 
-	iVal := b.Get(indexKey)
-	if iVal != nil {
-		if unique && !delete {
-			return ErrUniqueExists
+	if anon, ok := storer.(*anonStorer); ok {
+		legacyKeys, ok, err := anon.legacyIndexKeys(s, indexName, data)
+		if err != nil {
+			return err
+		}
+		if ok {
+			indexKeys = legacyKeys
+		}
+	}
+
+	for _, indexKey := range indexKeys {
+		if indexKey == nil {
+			continue
+		}
+
+		indexValue := make(keyList, 0)
+
+		iVal := b.Get(indexKey)
+		if iVal != nil {
+			if unique && !delete {
+				return ErrUniqueExists
+			}
+
+			err = s.decode(iVal, &indexValue)
+			if err != nil {
+				return err
+			}
+		}
+
+		if delete {
+			indexValue.remove(key)
+		} else {
+			indexValue.add(key)
+		}
+
+		if len(indexValue) == 0 {
+			err = b.Delete(indexKey)
+			if err != nil {
+				return err
+			}
+			continue
 		}
 
-		err = s.decode(iVal, &indexValue)
+		iVal, err = s.encode(indexValue)
 		if err != nil {
 			return err
 		}
 
+		err = b.Put(indexKey, iVal)
+		if err != nil {
+			return err
+		}
 	}
 
-	if delete {
-		indexValue.remove(key)
-	} else {
-		indexValue.add(key)
+	return nil
+}
+
+// addIndexKey adds the record key to the index value.  Each index value is a bucket in the index bucket, containing
+// the keys of every record with that value, and the number of keys stored as the bucket's sequence
+func addIndexKey(b *bolt.Bucket, unique bool, indexKey, key []byte) error {
+	keys := b.Bucket(indexKey)
+	if keys == nil {
+		var err error
+		keys, err = b.CreateBucket(indexKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	if keys.Get(key) != nil {
+		// already added
+		return nil
 	}
 
-	if len(indexValue) == 0 {
-		return b.Delete(indexKey)
+	if unique && keys.Sequence() > 0 {
+		return ErrUniqueExists
 	}
 
-	iVal, err = s.encode(indexValue)
+	err := keys.Put(key, []byte{})
 	if err != nil {
 		return err
 	}
 
-	return b.Put(indexKey, iVal)
+	return keys.SetSequence(keys.Sequence() + 1)
+}
+
+// removeIndexKey removes the record key from the index value, and removes the index value once it no longer has
+// any keys
+func removeIndexKey(b *bolt.Bucket, indexKey, key []byte) error {
+	keys := b.Bucket(indexKey)
+	if keys == nil || keys.Get(key) == nil {
+		return nil
+	}
+
+	if keys.Sequence() <= 1 {
+		return b.DeleteBucket(indexKey)
+	}
+
+	err := keys.Delete(key)
+	if err != nil {
+		return err
+	}
+	return keys.SetSequence(keys.Sequence() - 1)
 }
 
 // IndexExists tests if an index exists for the passed in field name
@@ -178,7 +366,11 @@ func (v *keyList) in(key []byte) bool {
 func (s *Store) seekCursor(cursor *bolt.Cursor, criteria []*Criterion) (key, value []byte) {
 	firstKey, firstValue := cursor.First()
 
-	if len(criteria) != 1 || criteria[0].negate {
+	if len(criteria) != 1 || criteria[0].negate || criteria[0].length {
+		return firstKey, firstValue
+	}
+
+	if criteria[0].value != nil && s.hasComparer(reflect.TypeOf(criteria[0].value)) {
 		return firstKey, firstValue
 	}
 
@@ -196,6 +388,214 @@ func (s *Store) seekCursor(cursor *bolt.Cursor, criteria []*Criterion) (key, val
 	return cursor.First()
 }
 
+// indexRange is the range of index keys that can match a set of criteria.  A nil lower bound starts at the beginning
+// of the index, and a nil upper bound continues to the end of the index.  Keys outside of the prefix are out of range
+type indexRange struct {
+	prefix []byte
+	lower  []byte
+	upper  []byte
+}
+
+// newIndexRange builds the range of keys the cursor needs to walk to find all the index values that could match the
+// criteria on the fields of the index.  Each leading field with an equality criterion narrows the range to a prefix,
+// and range criteria on the next field set the bounds within that prefix.
+// Bounds are only set when the index keys preserve the order of their values, and the first value in every key in
+// the index is of the same type as the bound, otherwise the entire index is walked.  Bounds on later fields are
+// dropped when they're a different type than the field's value in the first key
+func (s *Store) newIndexRange(cursor *bolt.Cursor, fields []string, fieldCriteria map[string][]*Criterion) *indexRange {
+	rng := &indexRange{}
+
+	if cursor.Bucket().Sequence() != indexVersion {
+		// the keys of indexes written by older versions of bolthold aren't in the order of their values
+		return rng
+	}
+
+	first, _ := cursor.First()
+	last, _ := cursor.Last()
+
+	if !isOrderedIndexKey(first) || !isOrderedIndexKey(last) || first[0] != last[0] {
+		return rng
+	}
+
+	// the type of each value in the first key, bounds of a different type, such as a float64 criterion on an int
+	// field with NumericPromotion, can't be seeked to
+	tags := []byte{first[0]}
+	if parts, err := splitIndexKey(first); err == nil {
+		tags = tags[:0]
+		for _, part := range parts {
+			tags = append(tags, part[0])
+		}
+	}
+
+	var prefix []byte
+
+	for i, field := range fields {
+		lower, upper := s.criteriaBounds(fieldCriteria[field])
+
+		if i < len(tags) {
+			if lower != nil && lower[0] != tags[i] {
+				lower = nil
+			}
+			if upper != nil && upper[0] != tags[i] {
+				upper = nil
+			}
+		}
+
+		if lower != nil && bytes.Equal(lower, upper) {
+			prefix = append(prefix, lower...)
+			continue
+		}
+
+		rng.prefix = prefix
+		if lower != nil {
+			rng.lower = append(append([]byte{}, prefix...), lower...)
+		} else if len(prefix) != 0 {
+			rng.lower = prefix
+		}
+		if upper != nil {
+			rng.upper = append(append([]byte{}, prefix...), upper...)
+		}
+		return rng
+	}
+
+	rng.prefix = prefix
+	if len(prefix) != 0 {
+		rng.lower = prefix
+	}
+	return rng
+}
+
+// criteriaBounds returns the encoded lower and upper bounds of the values that can match the criteria, a nil bound is
+// unbounded
+func (s *Store) criteriaBounds(criteria []*Criterion) (lower, upper []byte) {
+	for _, c := range criteria {
+		if c.negate || c.length {
+			continue
+		}
+
+		if c.operator == hp {
+			// every key starting with the prefix is within the bounds, with no terminator after the prefix
+			bound, err := s.encodeIndexKey(c.value)
+			if err != nil || len(bound) < 3 {
+				continue
+			}
+			bound = bound[:len(bound)-2]
+
+			if lower == nil || bytes.Compare(bound, lower) > 0 {
+				lower = bound
+			}
+			end := append(append([]byte{}, bound...), 0xFF)
+			if upper == nil || bytes.Compare(end, upper) < 0 {
+				upper = end
+			}
+			continue
+		}
+
+		if c.operator != eq && c.operator != gt && c.operator != ge && c.operator != lt && c.operator != le {
+			continue
+		}
+
+		if _, ok := c.value.(Field); ok {
+			continue
+		}
+
+		if c.value != nil && s.hasComparer(reflect.TypeOf(c.value)) {
+			// the index isn't in the order of the registered comparer
+			continue
+		}
+
+		bound, err := s.encodeIndexKey(c.value)
+		if err != nil || !isOrderedIndexKey(bound) {
+			continue
+		}
+
+		if c.operator == eq || c.operator == gt || c.operator == ge {
+			if lower == nil || bytes.Compare(bound, lower) > 0 {
+				lower = bound
+			}
+		}
+
+		if c.operator == eq || c.operator == lt || c.operator == le {
+			if upper == nil || bytes.Compare(bound, upper) < 0 {
+				upper = bound
+			}
+		}
+	}
+
+	return lower, upper
+}
+
+// seek moves the cursor to the first key in the range
+func (r *indexRange) seek(cursor *bolt.Cursor) (key, value []byte) {
+	if r.lower == nil {
+		return cursor.First()
+	}
+	return cursor.Seek(r.lower)
+}
+
+// seekLast moves the cursor to the last key in the range
+func (r *indexRange) seekLast(cursor *bolt.Cursor) (key, value []byte) {
+	bound := r.upper
+	if bound == nil {
+		bound = r.prefix
+	}
+
+	// every key starting with the bound is within the range, so the last key is the one before the first key past
+	// all of them
+	end := prefixEnd(bound)
+	if end == nil {
+		key, value = cursor.Last()
+	} else if key, _ = cursor.Seek(end); key == nil {
+		key, value = cursor.Last()
+	} else {
+		key, value = cursor.Prev()
+	}
+
+	for key != nil && r.past(key) {
+		key, value = cursor.Prev()
+	}
+	return key, value
+}
+
+// prefixEnd returns the first key that sorts after every key starting with prefix, or nil if there isn't one
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// before returns true if the key is below the lower bound of the range, and there is no need to read any further
+// back
+func (r *indexRange) before(key []byte) bool {
+	if len(r.prefix) != 0 && !bytes.HasPrefix(key, r.prefix) {
+		return true
+	}
+
+	return r.lower != nil && bytes.Compare(key, r.lower) < 0
+}
+
+// past returns true if the key is beyond the upper bound of the range, and there is no need to read any further
+// Keys may have more values after the bound, so only the part of the key the length of the bound is compared
+func (r *indexRange) past(key []byte) bool {
+	if len(r.prefix) != 0 && !bytes.HasPrefix(key, r.prefix) {
+		return true
+	}
+
+	if r.upper == nil {
+		return false
+	}
+
+	if len(key) > len(r.upper) {
+		key = key[:len(r.upper)]
+	}
+	return bytes.Compare(key, r.upper) > 0
+}
+
 type iterator struct {
 	keyCache    [][]byte
 	dataBucket  *bolt.Bucket
@@ -203,9 +603,22 @@ type iterator struct {
 	nextKeys    func(bool, *bolt.Cursor) ([][]byte, error)
 	prepCursor  bool
 	err         error
+
+	scan bool        // every record is read, rather than the records in the index
+	rng  *indexRange // range of the index read
+
+	// records are read from the index keys, rather than decoded, so Next doesn't return their values
+	covered       bool
+	indexKeyCache [][]byte
+	indexKey      []byte // index key of the last record returned by Next
 }
 
-func (s *Store) newIterator(source BucketSource, typeName string, query *Query) *iterator {
+// newIterator returns an iterator over the keys of the records that can match the query, read through the index.
+// If after is not nil, the iterator starts after that position in the index
+func (s *Store) newIterator(source BucketSource, storer Storer, query *Query, index string,
+	after *pagePosition) *iterator {
+	typeName := storer.Type()
+	query.badIndex = false
 
 	iter := &iterator{
 		dataBucket: source.Bucket([]byte(typeName)),
@@ -216,10 +629,13 @@ func (s *Store) newIterator(source BucketSource, typeName string, query *Query)
 		return iter
 	}
 
-	criteria := query.fieldCriteria[query.index]
+	criteria := query.fieldCriteria[index]
 
 	//   Key field
-	if query.index == Key && !query.badIndex {
+	if index == Key && !query.badIndex {
+		query.indexFields = []string{Key}
+		iter.scan = true
+
 		iter.indexCursor = source.Bucket([]byte(typeName)).Cursor()
 
 		iter.nextKeys = func(prepCursor bool, cursor *bolt.Cursor) ([][]byte, error) {
@@ -227,7 +643,13 @@ func (s *Store) newIterator(source BucketSource, typeName string, query *Query)
 
 			for len(nKeys) < iteratorKeyMinCacheSize {
 				var k []byte
-				if prepCursor {
+				if prepCursor && after != nil {
+					k, _ = cursor.Seek(after.key)
+					if bytes.Equal(k, after.key) {
+						k, _ = cursor.Next()
+					}
+					prepCursor = false
+				} else if prepCursor {
 					// k, _ = cursor.First()
 					k, _ = s.seekCursor(cursor, criteria)
 					prepCursor = false
@@ -245,13 +667,15 @@ func (s *Store) newIterator(source BucketSource, typeName string, query *Query)
 					return nil, err
 				}
 
-				ok, err := matchesAllCriteria(s, criteria, k, true, val.Interface())
+				ok, err := matchesAllCriteria(s, criteria, k, s.decode, val.Interface())
 				if err != nil {
 					return nil, err
 				}
 
 				if ok {
 					nKeys = append(nKeys, k)
+				} else if query.profile != nil {
+					query.profile.Scanned++
 				}
 			}
 			return nKeys, nil
@@ -262,12 +686,24 @@ func (s *Store) newIterator(source BucketSource, typeName string, query *Query)
 
 	var iBucket *bolt.Bucket
 	if !query.badIndex {
-		iBucket = source.Bucket(indexBucketName(typeName, query.index))
+		iBucket = source.Bucket(indexBucketName(typeName, index))
 	}
 
-	if iBucket == nil || !canUseIndex(criteria) {
+	fields := indexFields(storer, index)
+
+	// only fields with criteria that can be tested against the index are handled by the iterator, the rest are
+	// tested against the full record
+	query.indexFields = nil
+	for _, field := range fields {
+		if canUseIndex(query.fieldCriteria[field]) {
+			query.indexFields = append(query.indexFields, field)
+		}
+	}
+
+	if iBucket == nil || (len(fields) == 1 && len(query.indexFields) == 0) {
 		// bad index or criteria that can't use indexes, filter through entire store
 		query.badIndex = true
+		iter.scan = true
 
 		iter.indexCursor = source.Bucket([]byte(typeName)).Cursor()
 
@@ -276,7 +712,13 @@ func (s *Store) newIterator(source BucketSource, typeName string, query *Query)
 
 			for len(nKeys) < iteratorKeyMinCacheSize {
 				var k []byte
-				if prepCursor {
+				if prepCursor && after != nil {
+					k, _ = cursor.Seek(after.key)
+					if bytes.Equal(k, after.key) {
+						k, _ = cursor.Next()
+					}
+					prepCursor = false
+				} else if prepCursor {
 					// k, _ = cursor.First()
 					k, _ = s.seekCursor(cursor, criteria)
 					prepCursor = false
@@ -296,41 +738,122 @@ func (s *Store) newIterator(source BucketSource, typeName string, query *Query)
 	}
 
 	//   indexed field
+	decode := s.indexKeyDecoder(storer, index, iBucket)
 	iter.indexCursor = iBucket.Cursor()
+	rng := s.newIndexRange(iter.indexCursor, fields, query.fieldCriteria)
+	iter.rng = rng
+
+	// queries sorted by the field of the index read it in the direction of the sort
+	var desc, keysDesc bool
+	if s.sortIndex(source, storer, query, index) == index {
+		desc, keysDesc = sortDirection(query)
+	}
+
+	// cursor through the record keys of the current index value
+	var valueKeys *bolt.Cursor
+	var valueIndexKey []byte
 
 	iter.nextKeys = func(prepCursor bool, cursor *bolt.Cursor) ([][]byte, error) {
 		var nKeys [][]byte
 
 		for len(nKeys) < iteratorKeyMinCacheSize {
+			if valueKeys != nil {
+				var k []byte
+				if keysDesc {
+					k, _ = valueKeys.Prev()
+				} else {
+					k, _ = valueKeys.Next()
+				}
+				if k != nil {
+					nKeys = append(nKeys, k)
+					iter.indexKeyCache = append(iter.indexKeyCache, valueIndexKey)
+					continue
+				}
+				valueKeys = nil
+			}
+
 			var k, v []byte
-			if prepCursor {
-				// k, v = cursor.First()
-				k, v = s.seekCursor(cursor, criteria)
-				prepCursor = false
+			if desc {
+				if prepCursor {
+					k, v = rng.seekLast(cursor)
+					prepCursor = false
+				} else {
+					k, v = cursor.Prev()
+				}
+				if k == nil || rng.before(k) {
+					return nKeys, nil
+				}
 			} else {
-				k, v = cursor.Next()
-			}
-			if k == nil {
-				return nKeys, nil
+				if prepCursor {
+					k, v = rng.seek(cursor)
+					if after != nil && k != nil && bytes.Compare(k, after.indexKey) < 0 {
+						k, v = cursor.Seek(after.indexKey)
+					}
+					prepCursor = false
+				} else {
+					k, v = cursor.Next()
+				}
+				if k == nil || rng.past(k) {
+					return nKeys, nil
+				}
 			}
 
-			// no currentRow on indexes as it refers to multiple rows
-			ok, err := matchesAllCriteria(s, criteria, k, true, nil)
+			ok, err := s.matchesIndexKey(k, fields, query, decode)
 			if err != nil {
 				return nil, err
 			}
 
-			if ok {
-				// append the slice of keys stored in the index
-				var keys = make(keyList, 0)
-				err := s.decode(v, &keys)
-				if err != nil {
-					return nil, err
+			if !ok {
+				continue
+			}
+
+			// the keys of the index value at the position of after are only read from after its record key
+			var start []byte
+			if after != nil && bytes.Equal(k, after.indexKey) {
+				start = after.key
+			}
+
+			if v == nil {
+				valueKeys = iBucket.Bucket(k).Cursor()
+				valueIndexKey = k
+				first, _ := valueKeys.First()
+				if keysDesc {
+					first, _ = valueKeys.Last()
+				}
+				if start != nil {
+					first, _ = valueKeys.Seek(start)
+					if bytes.Equal(first, start) {
+						first, _ = valueKeys.Next()
+					}
 				}
+				if first != nil {
+					nKeys = append(nKeys, first)
+					iter.indexKeyCache = append(iter.indexKeyCache, k)
+				}
+				continue
+			}
 
-				nKeys = append(nKeys, [][]byte(keys)...)
+			// index value written by an older version of bolthold, append the slice of keys stored in the index
+			var keys = make(keyList, 0)
+			err = s.decode(v, &keys)
+			if err != nil {
+				return nil, err
 			}
 
+			for start != nil && len(keys) != 0 && bytes.Compare(keys[0], start) <= 0 {
+				keys = keys[1:]
+			}
+
+			if keysDesc {
+				for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+					keys[i], keys[j] = keys[j], keys[i]
+				}
+			}
+
+			nKeys = append(nKeys, [][]byte(keys)...)
+			for range keys {
+				iter.indexKeyCache = append(iter.indexKeyCache, k)
+			}
 		}
 		return nKeys, nil
 
@@ -340,6 +863,52 @@ func (s *Store) newIterator(source BucketSource, typeName string, query *Query)
 
 }
 
+// indexKeyDecoder returns the func that decodes the keys of the named index.  Keys are encoded with EncodeIndexKey,
+// except in indexes written by older versions of bolthold, which are told apart by the version stored as the
+// sequence of the index bucket, and in the indexes of custom Storers that don't set their Fields.  Both of those
+// encode their keys with the store's encoder
+func (s *Store) indexKeyDecoder(storer Storer, indexName string, iBucket *bolt.Bucket) DecodeFunc {
+	if iBucket.Sequence() != indexVersion {
+		return s.decode
+	}
+	if _, ok := storer.(*anonStorer); !ok && len(storer.Indexes()[indexName].Fields) == 0 {
+		return s.decode
+	}
+	return s.decodeIndexKey
+}
+
+// matchesIndexKey tests the index key against the criteria on the fields handled by the index, decoding the key with
+// decode
+func (s *Store) matchesIndexKey(key []byte, fields []string, query *Query, decode DecodeFunc) (bool, error) {
+	values := [][]byte{key}
+	if len(fields) > 1 {
+		var err error
+		values, err = splitIndexKey(key)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	for i, field := range fields {
+		if i >= len(values) || !query.indexHandles(field) {
+			continue
+		}
+
+		match := matchesAllCriteria
+		if isMapField(field) {
+			match = matchesMapCriteria
+		}
+
+		// no currentRow on indexes as it refers to multiple rows
+		ok, err := match(s, query.fieldCriteria[field], values[i], decode, nil)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
 // Next returns the next key value that matches the iterators criteria
 // If no more kv's are available the return nil, if there is an error, they return nil
 // and iterator.Error() will return the error
@@ -374,6 +943,15 @@ func (i *iterator) Next() (key []byte, value []byte) {
 	nextKey := i.keyCache[0]
 	i.keyCache = i.keyCache[1:]
 
+	if len(i.indexKeyCache) != 0 {
+		i.indexKey = i.indexKeyCache[0]
+		i.indexKeyCache = i.indexKeyCache[1:]
+	}
+
+	if i.covered {
+		return nextKey, nil
+	}
+
 	val := i.dataBucket.Get(nextKey)
 
 	return nextKey, val
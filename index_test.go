@@ -5,7 +5,15 @@
 package bolthold_test
 
 import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	bh "github.com/timshannon/bolthold"
 	bolt "go.etcd.io/bbolt"
@@ -122,3 +130,678 @@ func TestSliceIndexWithPointers(t *testing.T) {
 		equals(t, len(es), 1)
 	})
 }
+
+type IndexRangeTest struct {
+	Key      int
+	Int      int        `boltholdIndex:"Int"`
+	Uint     uint64     `boltholdIndex:"Uint"`
+	Float    float64    `boltholdIndex:"Float"`
+	Name     string     `boltholdIndex:"Name"`
+	Created  time.Time  `boltholdIndex:"Created"`
+	BigInt   *big.Int   `boltholdIndex:"BigInt"`
+	BigFloat *big.Float `boltholdIndex:"BigFloat"`
+}
+
+func TestIndexRange(t *testing.T) {
+	testWrap(t, func(store *bh.Store, t *testing.T) {
+		names := []string{"", "a", "a\x00", "ab", "abc", "b", "ba", "zebra", "\xff"}
+		base := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+
+		var data []IndexRangeTest
+		for i := -20; i <= 20; i++ {
+			bi := new(big.Int).Exp(big.NewInt(int64(i)), big.NewInt(30), nil)
+			if i < 0 {
+				bi.Neg(bi)
+			}
+
+			data = append(data, IndexRangeTest{
+				Key:      i,
+				Int:      i * 1000,
+				Uint:     uint64(i+20) << 50,
+				Float:    float64(i) / 3,
+				Name:     names[(i+20)%len(names)],
+				Created:  base.Add(time.Duration(i) * 36 * time.Hour),
+				BigInt:   bi,
+				BigFloat: new(big.Float).SetFloat64(float64(i) * 1.5e-10),
+			})
+		}
+
+		for i := range data {
+			ok(t, store.Insert(data[i].Key, data[i]))
+		}
+
+		tests := []struct {
+			name   string
+			query  *bh.Query
+			filter func(r IndexRangeTest) bool
+		}{
+			{
+				name:   "Int Gt Lt",
+				query:  bh.Where("Int").Gt(-5000).And("Int").Lt(3000).Index("Int"),
+				filter: func(r IndexRangeTest) bool { return r.Int > -5000 && r.Int < 3000 },
+			},
+			{
+				name:   "Int Ge Le",
+				query:  bh.Where("Int").Ge(-5000).And("Int").Le(3000).Index("Int"),
+				filter: func(r IndexRangeTest) bool { return r.Int >= -5000 && r.Int <= 3000 },
+			},
+			{
+				name:   "Int Eq",
+				query:  bh.Where("Int").Eq(-7000).Index("Int"),
+				filter: func(r IndexRangeTest) bool { return r.Int == -7000 },
+			},
+			{
+				name:   "Int Eq and Lt",
+				query:  bh.Where("Int").Eq(-7000).And("Int").Lt(-8000).Index("Int"),
+				filter: func(r IndexRangeTest) bool { return false },
+			},
+			{
+				name:   "Int Lt only",
+				query:  bh.Where("Int").Lt(-15000).Index("Int"),
+				filter: func(r IndexRangeTest) bool { return r.Int < -15000 },
+			},
+			{
+				name:   "Int multiple lower bounds",
+				query:  bh.Where("Int").Gt(-15000).And("Int").Ge(2000).Index("Int"),
+				filter: func(r IndexRangeTest) bool { return r.Int >= 2000 },
+			},
+			{
+				name:   "Int Not Lt",
+				query:  bh.Where("Int").Not().Lt(15000).Index("Int"),
+				filter: func(r IndexRangeTest) bool { return r.Int >= 15000 },
+			},
+			{
+				name:   "Int different int type",
+				query:  bh.Where("Int").Ge(int64(18000)).Index("Int"),
+				filter: func(r IndexRangeTest) bool { return r.Int >= 18000 },
+			},
+			{
+				name:   "Uint Gt Le",
+				query:  bh.Where("Uint").Gt(uint64(10) << 50).And("Uint").Le(uint64(30) << 50).Index("Uint"),
+				filter: func(r IndexRangeTest) bool { return r.Uint > uint64(10)<<50 && r.Uint <= uint64(30)<<50 },
+			},
+			{
+				name:   "Float Ge Lt",
+				query:  bh.Where("Float").Ge(-2.5).And("Float").Lt(1.0).Index("Float"),
+				filter: func(r IndexRangeTest) bool { return r.Float >= -2.5 && r.Float < 1.0 },
+			},
+			{
+				name:   "Float Eq zero",
+				query:  bh.Where("Float").Eq(0.0).Index("Float"),
+				filter: func(r IndexRangeTest) bool { return r.Float == 0 },
+			},
+			{
+				name:   "String Ge Lt",
+				query:  bh.Where("Name").Ge("a").And("Name").Lt("b").Index("Name"),
+				filter: func(r IndexRangeTest) bool { return r.Name >= "a" && r.Name < "b" },
+			},
+			{
+				name:   "String Gt",
+				query:  bh.Where("Name").Gt("a").Index("Name"),
+				filter: func(r IndexRangeTest) bool { return r.Name > "a" },
+			},
+			{
+				name:   "String Le empty",
+				query:  bh.Where("Name").Le("").Index("Name"),
+				filter: func(r IndexRangeTest) bool { return r.Name <= "" },
+			},
+			{
+				name:   "String Eq",
+				query:  bh.Where("Name").Eq("ab").Index("Name"),
+				filter: func(r IndexRangeTest) bool { return r.Name == "ab" },
+			},
+			{
+				name: "Time Gt Le",
+				query: bh.Where("Created").Gt(base.Add(-100 * time.Hour)).
+					And("Created").Le(base.Add(200 * time.Hour)).Index("Created"),
+				filter: func(r IndexRangeTest) bool {
+					return r.Created.After(base.Add(-100*time.Hour)) && !r.Created.After(base.Add(200*time.Hour))
+				},
+			},
+			{
+				name:   "Time Eq in different location",
+				query:  bh.Where("Created").Eq(base.Add(72 * time.Hour).In(time.FixedZone("test", 3600))).Index("Created"),
+				filter: func(r IndexRangeTest) bool { return r.Created.Equal(base.Add(72 * time.Hour)) },
+			},
+			{
+				name: "BigInt Gt Lt",
+				query: bh.Where("BigInt").Gt(new(big.Int).Neg(new(big.Int).Exp(big.NewInt(12), big.NewInt(30), nil))).
+					And("BigInt").Lt(new(big.Int).Exp(big.NewInt(3), big.NewInt(30), nil)).Index("BigInt"),
+				filter: func(r IndexRangeTest) bool { return r.Key > -12 && r.Key < 3 },
+			},
+			{
+				name:   "BigFloat Ge Le",
+				query:  bh.Where("BigFloat").Ge(big.NewFloat(-4.5e-10)).And("BigFloat").Le(big.NewFloat(6e-10)).Index("BigFloat"),
+				filter: func(r IndexRangeTest) bool { return r.Key >= -3 && r.Key <= 4 },
+			},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				var result []IndexRangeTest
+				ok(t, store.Find(&result, tst.query))
+
+				expected := make(map[int]bool)
+				for i := range data {
+					if tst.filter(data[i]) {
+						expected[data[i].Key] = true
+					}
+				}
+
+				got := make(map[int]bool)
+				for i := range result {
+					got[result[i].Key] = true
+				}
+
+				equals(t, len(expected), len(result))
+				equals(t, expected, got)
+			})
+		}
+	})
+}
+
+func TestIndexRangeMixedTypes(t *testing.T) {
+	type MixedIndex struct {
+		Value interface{} `boltholdIndex:"Value"`
+	}
+
+	testWrap(t, func(store *bh.Store, t *testing.T) {
+		ok(t, store.Insert(1, &MixedIndex{Value: 5}))
+		ok(t, store.Insert(2, &MixedIndex{Value: 10}))
+		ok(t, store.Insert(3, &MixedIndex{Value: "a string"}))
+
+		var result []MixedIndex
+		err := store.Find(&result, bh.Where("Value").Gt(6).Index("Value"))
+		assert(t, err != nil, "Comparing mixed types in an index did not return an error")
+	})
+}
+
+type TenantRecord struct {
+	Key      int
+	TenantID int       `boltholdIndex:"TenantCreated,1"`
+	Created  time.Time `boltholdIndex:"TenantCreated,2"`
+	Email    string    `boltholdUnique:"TenantEmail,2"`
+	Tenant   string    `boltholdUnique:"TenantEmail,1"`
+	Name     string
+}
+
+func TestCompositeIndex(t *testing.T) {
+	testWrap(t, func(store *bh.Store, t *testing.T) {
+		base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		var data []TenantRecord
+		for i := 0; i < 60; i++ {
+			data = append(data, TenantRecord{
+				Key:      i,
+				TenantID: i % 4,
+				Created:  base.Add(time.Duration(i) * time.Hour),
+				Email:    fmt.Sprintf("user%d@example.com", i/4),
+				Tenant:   fmt.Sprintf("tenant%d", i%4),
+				Name:     fmt.Sprintf("name %d", i%3),
+			})
+		}
+
+		for i := range data {
+			ok(t, store.Insert(data[i].Key, data[i]))
+		}
+
+		tests := []struct {
+			name   string
+			query  *bh.Query
+			filter func(r TenantRecord) bool
+		}{
+			{
+				name:   "Leading field equality",
+				query:  bh.Where("TenantID").Eq(2).Index("TenantCreated"),
+				filter: func(r TenantRecord) bool { return r.TenantID == 2 },
+			},
+			{
+				name: "Leading field equality with range",
+				query: bh.Where("TenantID").Eq(1).And("Created").Gt(base.Add(10 * time.Hour)).
+					And("Created").Le(base.Add(40 * time.Hour)).Index("TenantCreated"),
+				filter: func(r TenantRecord) bool {
+					return r.TenantID == 1 && r.Created.After(base.Add(10*time.Hour)) &&
+						!r.Created.After(base.Add(40*time.Hour))
+				},
+			},
+			{
+				name:   "All fields equal",
+				query:  bh.Where("TenantID").Eq(3).And("Created").Eq(base.Add(7 * time.Hour)).Index("TenantCreated"),
+				filter: func(r TenantRecord) bool { return r.Key == 7 },
+			},
+			{
+				name:   "Leading field range",
+				query:  bh.Where("TenantID").Ge(2).And("Created").Lt(base.Add(20 * time.Hour)).Index("TenantCreated"),
+				filter: func(r TenantRecord) bool { return r.TenantID >= 2 && r.Created.Before(base.Add(20*time.Hour)) },
+			},
+			{
+				name:   "Trailing field only",
+				query:  bh.Where("Created").Ge(base.Add(50 * time.Hour)).Index("TenantCreated"),
+				filter: func(r TenantRecord) bool { return !r.Created.Before(base.Add(50 * time.Hour)) },
+			},
+			{
+				name: "Criteria outside of the index",
+				query: bh.Where("TenantID").Eq(0).And("Name").Eq("name 1").And("Created").
+					Gt(base.Add(5 * time.Hour)).Index("TenantCreated"),
+				filter: func(r TenantRecord) bool {
+					return r.TenantID == 0 && r.Name == "name 1" && r.Created.After(base.Add(5*time.Hour))
+				},
+			},
+			{
+				name: "Not criteria on leading field",
+				query: bh.Where("TenantID").Not().Eq(0).And("Created").Lt(base.Add(6 * time.Hour)).
+					Index("TenantCreated"),
+				filter: func(r TenantRecord) bool { return r.TenantID != 0 && r.Created.Before(base.Add(6*time.Hour)) },
+			},
+			{
+				name: "MatchFunc on trailing field",
+				query: bh.Where("TenantID").Eq(1).And("Created").MatchFunc(func(created time.Time) (bool, error) {
+					return created.Hour()%2 == 1, nil
+				}).Index("TenantCreated"),
+				filter: func(r TenantRecord) bool { return r.TenantID == 1 && r.Created.Hour()%2 == 1 },
+			},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				var result []TenantRecord
+				ok(t, store.Find(&result, tst.query))
+
+				expected := make(map[int]bool)
+				for i := range data {
+					if tst.filter(data[i]) {
+						expected[data[i].Key] = true
+					}
+				}
+
+				got := make(map[int]bool)
+				for i := range result {
+					got[result[i].Key] = true
+				}
+
+				equals(t, len(expected), len(result))
+				equals(t, expected, got)
+			})
+		}
+
+		// composite unique constraint
+		dupe := data[5]
+		dupe.Key = 100
+		equals(t, bh.ErrUniqueExists, store.Insert(dupe.Key, dupe))
+
+		dupe.Tenant = "another tenant"
+		ok(t, store.Insert(dupe.Key, dupe))
+	})
+}
+
+type CustomComposite struct {
+	Category string
+	Rank     int
+}
+
+func (c *CustomComposite) Type() string { return "CustomComposite" }
+
+func (c *CustomComposite) Indexes() map[string]bh.Index {
+	return map[string]bh.Index{
+		"CategoryRank": {
+			IndexFunc: func(_ string, value interface{}) ([]byte, error) {
+				v := value.(*CustomComposite)
+				return customCompositeStore.EncodeIndexKey(v.Category, v.Rank)
+			},
+			Fields: []string{"Category", "Rank"},
+		},
+	}
+}
+
+func (c *CustomComposite) SliceIndexes() map[string]bh.SliceIndex {
+	return map[string]bh.SliceIndex{}
+}
+
+var customCompositeStore *bh.Store
+
+func TestCompositeIndexCustomStorer(t *testing.T) {
+	testWrap(t, func(store *bh.Store, t *testing.T) {
+		customCompositeStore = store
+
+		for i := 0; i < 20; i++ {
+			ok(t, store.Insert(i, &CustomComposite{
+				Category: []string{"a", "b"}[i%2],
+				Rank:     i,
+			}))
+		}
+
+		var result []CustomComposite
+		ok(t, store.Find(&result, bh.Where("Category").Eq("b").And("Rank").Lt(9).Index("CategoryRank")))
+		equals(t, 4, len(result))
+		for i := range result {
+			equals(t, "b", result[i].Category)
+			assert(t, result[i].Rank < 9, "Rank %d is not less than 9", result[i].Rank)
+		}
+	})
+}
+
+func TestCompositeIndexInvalidPosition(t *testing.T) {
+	type BadComposite struct {
+		First  string `boltholdIndex:"FirstSecond,one"`
+		Second string `boltholdIndex:"FirstSecond,two"`
+	}
+
+	testWrap(t, func(store *bh.Store, t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("Inserting a type with an invalid composite index position did not panic!")
+			}
+		}()
+
+		_ = store.Insert(1, &BadComposite{})
+	})
+}
+
+func TestCompositeIndexDuplicatePosition(t *testing.T) {
+	type DupComposite struct {
+		First  string `boltholdIndex:"FirstSecond,0"`
+		Second string `boltholdIndex:"FirstSecond,0"`
+	}
+
+	testWrap(t, func(store *bh.Store, t *testing.T) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				t.Fatalf("Inserting a type with a duplicate composite index position did not panic!")
+			}
+			assert(t, strings.Contains(fmt.Sprint(r), "position 0"), "Unexpected panic message: %v", r)
+		}()
+
+		_ = store.Insert(1, &DupComposite{})
+	})
+}
+
+func TestIndexLayout(t *testing.T) {
+	testWrap(t, func(store *bh.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		ok(t, store.Bolt().View(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(indexName("ItemTest", "Category"))
+			assert(t, bucket != nil, "No index bucket found for Category index")
+
+			counts := make(map[string]int)
+			for i := range testData {
+				counts[testData[i].Category]++
+			}
+
+			return bucket.ForEach(func(k, v []byte) error {
+				assert(t, v == nil, "Index value %v is not stored as a bucket", k)
+
+				keys := bucket.Bucket(k)
+				stats := keys.Stats()
+
+				equals(t, uint64(stats.KeyN), keys.Sequence())
+				found := false
+				for category, count := range counts {
+					if count == stats.KeyN {
+						found = true
+						delete(counts, category)
+						break
+					}
+				}
+				assert(t, found, "Index value %v has an unexpected number of keys %d", k, stats.KeyN)
+				return nil
+			})
+		}))
+
+		// removing all records for an index value removes the value from the index
+		ok(t, store.DeleteMatching(&ItemTest{}, bh.Where("Category").Eq("food")))
+
+		var result []ItemTest
+		ok(t, store.Find(&result, bh.Where("Category").Eq("food").Index("Category")))
+		equals(t, 0, len(result))
+
+		ok(t, store.Bolt().View(func(tx *bolt.Tx) error {
+			equals(t, 2, tx.Bucket(indexName("ItemTest", "Category")).Stats().BucketN-1)
+			return nil
+		}))
+	})
+}
+
+// writeOlderCategoryIndex rewrites the Category index the way older versions of bolthold stored it, as an encoded list
+// of keys for each encoded index value
+func writeOlderCategoryIndex(t *testing.T, store *bh.Store, encode bh.EncodeFunc) {
+	ok(t, store.Bolt().Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket(indexName("ItemTest", "Category"))
+		if err != nil {
+			return err
+		}
+
+		bucket, err := tx.CreateBucket(indexName("ItemTest", "Category"))
+		if err != nil {
+			return err
+		}
+
+		keys := make(map[string][][]byte)
+		for i := range testData {
+			key, err := encode(testData[i].Key)
+			if err != nil {
+				return err
+			}
+			keys[testData[i].Category] = append(keys[testData[i].Category], key)
+		}
+
+		for category, list := range keys {
+			k, err := encode(category)
+			if err != nil {
+				return err
+			}
+			v, err := encode(list)
+			if err != nil {
+				return err
+			}
+			err = bucket.Put(k, v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func TestIndexOlderLayout(t *testing.T) {
+	testWrap(t, func(store *bh.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		writeOlderCategoryIndex(t, store, bh.DefaultEncode)
+
+		var result []ItemTest
+		ok(t, store.Find(&result, bh.Where("Category").Eq("vehicle").Index("Category")))
+		equals(t, 5, len(result))
+
+		// writing to an older index keeps its layout
+		ok(t, store.Delete(testData[0].Key, &ItemTest{}))
+		ok(t, store.Insert(100, &ItemTest{Key: 100, Name: "new", Category: "vehicle"}))
+
+		layout := func() (legacy, current int) {
+			ok(t, store.Bolt().View(func(tx *bolt.Tx) error {
+				return tx.Bucket(indexName("ItemTest", "Category")).ForEach(func(k, v []byte) error {
+					if v == nil {
+						current++
+					} else {
+						legacy++
+					}
+					return nil
+				})
+			}))
+			return legacy, current
+		}
+
+		legacy, current := layout()
+		assert(t, legacy > 0 && current == 0, "Writing to the index changed its layout")
+
+		result = nil
+		ok(t, store.Find(&result, bh.Where("Category").Eq("vehicle").Index("Category")))
+		equals(t, 5, len(result))
+
+		// until it's rebuilt by ReIndex
+		ok(t, store.ReIndex(&ItemTest{}, nil))
+
+		legacy, current = layout()
+		assert(t, legacy == 0 && current > 0, "ReIndex didn't rebuild the index in the current layout")
+
+		result = nil
+		ok(t, store.Find(&result, bh.Where("Category").Eq("vehicle").Index("Category")))
+		equals(t, 5, len(result))
+	})
+}
+
+// taggedEncode encodes values the same as gob, but starting with a byte that's also the tag of an index key, the
+// same way msgpack starts short strings
+func taggedEncode(value interface{}) ([]byte, error) {
+	data, err := bh.DefaultEncode(value)
+	return append([]byte{0xA5}, data...), err
+}
+
+func taggedDecode(data []byte, value interface{}) error {
+	return bh.DefaultDecode(data[1:], value)
+}
+
+func TestIndexOlderLayoutTaggedEncoding(t *testing.T) {
+	filename := tempfile()
+	store, err := bh.Open(filename, 0666, &bh.Options{Encoder: taggedEncode, Decoder: taggedDecode})
+	ok(t, err)
+	defer os.Remove(filename)
+	defer store.Close()
+
+	insertTestData(t, store)
+	writeOlderCategoryIndex(t, store, taggedEncode)
+
+	// the keys of the older index are told apart by the index's version, not by their first byte
+	for _, query := range []*bh.Query{
+		bh.Where("Category").Eq("vehicle"),
+		bh.Where("Category").Gt("food"),
+		bh.Where("Category").In("animal", "food"),
+	} {
+		keys := func(index string) []int {
+			var result []ItemTest
+			ok(t, store.Find(&result, query.Index(index)))
+			keys := []int{}
+			for i := range result {
+				keys = append(keys, result[i].Key)
+			}
+			sort.Ints(keys)
+			return keys
+		}
+		want := keys(bh.Key)
+		assert(t, len(want) > 0, "No records match %s", query)
+		equals(t, want, keys("Category"))
+	}
+}
+
+type IndexTimeItem struct {
+	ID      int       `boltholdKey:"ID"`
+	Created time.Time `boltholdIndex:"Created"`
+}
+
+func TestIndexTimeLocation(t *testing.T) {
+	testWrap(t, func(store *bh.Store, t *testing.T) {
+		zone := time.FixedZone("test", -5*3600)
+		created := time.Date(2021, 3, 4, 5, 6, 7, 8, zone)
+		ok(t, store.Insert(1, &IndexTimeItem{Created: created}))
+		ok(t, store.Insert(2, &IndexTimeItem{Created: created.Add(time.Hour).In(time.Local)}))
+
+		// the comparer is passed the times decoded from the index keys
+		var locations []*time.Location
+		store.RegisterComparer(reflect.TypeOf(time.Time{}), func(a, b interface{}) (int, error) {
+			value, other := a.(time.Time), b.(time.Time)
+			locations = append(locations, value.Location())
+			switch {
+			case value.Before(other):
+				return -1, nil
+			case value.After(other):
+				return 1, nil
+			}
+			return 0, nil
+		})
+
+		var result []IndexTimeItem
+		ok(t, store.Find(&result, bh.Where("Created").Eq(created.UTC()).Index("Created")))
+		equals(t, 1, len(result))
+		equals(t, 1, result[0].ID)
+		assert(t, result[0].Created.Equal(created), "Record time %s isn't %s", result[0].Created, created)
+
+		equals(t, 2, len(locations))
+		for _, location := range locations {
+			equals(t, time.UTC, location)
+		}
+	})
+}
+
+type ZeroPointer struct {
+	ID    int    `boltholdKey:"ID"`
+	P     *int   `boltholdIndex:"P"`
+	Other *int   `boltholdIndex:"PointerComposite,0"`
+	Name  string `boltholdIndex:"PointerComposite,1"`
+}
+
+func insertZeroPointers(t *testing.T, store *bh.Store) {
+	zero, five := 0, 5
+	data := []ZeroPointer{
+		{ID: 1, P: &zero, Other: &zero, Name: "a"},
+		{ID: 2, P: &five, Other: &five, Name: "a"},
+		{ID: 3, Name: "a"},
+	}
+	for i := range data {
+		ok(t, store.Insert(data[i].ID, &data[i]))
+	}
+}
+
+func zeroPointerIDs(t *testing.T, store *bh.Store, query *bh.Query) []int {
+	var result []ZeroPointer
+	ok(t, store.Find(&result, query))
+
+	ids := []int{}
+	for i := range result {
+		ids = append(ids, result[i].ID)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func TestIndexZeroPointer(t *testing.T) {
+	testWrap(t, func(store *bh.Store, t *testing.T) {
+		insertZeroPointers(t, store)
+
+		// gob reads a pointer to a zero value back as nil, so it isn't indexed as the value it pointed to
+		for _, query := range []*bh.Query{
+			bh.Where("P").Ge(-2),
+			bh.Where("P").Ge(-2).Index("P"),
+			bh.Where("P").Ge(-2).Index(bh.Key),
+		} {
+			equals(t, []int{2}, zeroPointerIDs(t, store, query))
+		}
+
+		equals(t, []int{1, 3}, zeroPointerIDs(t, store, bh.Where("P").IsNil()))
+		equals(t, []int{2}, zeroPointerIDs(t, store,
+			bh.Where("Other").Ge(-2).And("Name").Eq("a").Index("PointerComposite")))
+		equals(t, []int{2}, zeroPointerIDs(t, store, bh.Where("Other").Ge(-2).Index(bh.Key)))
+
+		// the index entries match the records as they're read back, so updates and deletes don't leave any behind
+		three := 3
+		ok(t, store.Update(1, &ZeroPointer{ID: 1, P: &three, Name: "a"}))
+		equals(t, []int{1, 2}, zeroPointerIDs(t, store, bh.Where("P").Ge(-2).Index("P")))
+		ok(t, store.Delete(1, &ZeroPointer{}))
+		equals(t, []int{2}, zeroPointerIDs(t, store, bh.Where("P").Ge(-2).Index("P")))
+	})
+
+	filename := tempfile()
+	store, err := bh.Open(filename, 0666, &bh.Options{
+		Encoder: json.Marshal,
+		Decoder: json.Unmarshal,
+	})
+	ok(t, err)
+	defer os.Remove(filename)
+	defer store.Close()
+
+	insertZeroPointers(t, store)
+
+	// json keeps pointers to zero values, so they're indexed
+	equals(t, []int{1, 2}, zeroPointerIDs(t, store, bh.Where("P").Ge(-2).Index("P")))
+	equals(t, []int{1, 2}, zeroPointerIDs(t, store, bh.Where("P").Ge(-2).Index(bh.Key)))
+}
@@ -0,0 +1,641 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+	"reflect"
+	"time"
+)
+
+// Index keys are encoded so that the byte order of the encoded keys matches the sort order of the values they were
+// encoded from.  This allows index cursors to seek to the lower bound of a range, and stop at the upper bound,
+// rather than walking the entire index.
+//
+// Every key starts with a tag byte identifying the kind of value that follows.  Keys written by older versions of
+// bolthold were encoded with the store's encoder, and could start with any byte, so the indexes holding them are
+// told apart by the version stored as the sequence of the index bucket rather than by their keys, see indexVersion.
+//
+// Times are stored as the instant they represent, so the same instant in different locations is the same key, and
+// they're decoded in UTC.
+//
+// Variable length values are escaped (0x00 -> 0x00 0xFF) and terminated with 0x00 0x01 so that no key is a prefix of
+// another key of the same kind.
+const (
+	keyTagNil      byte = 0x80
+	keyTagBool     byte = 0x81
+	keyTagInt      byte = 0x82
+	keyTagUint     byte = 0x83
+	keyTagFloat    byte = 0x84
+	keyTagBigInt   byte = 0x85
+	keyTagBigFloat byte = 0x86
+	keyTagString   byte = 0x87
+	keyTagBytes    byte = 0x88
+	keyTagTime     byte = 0x89
+
+	// values that don't have an order preserving encoding are encoded with the store's encoder
+	keyTagEncoded byte = 0xBF
+)
+
+const (
+	keyEscape     byte = 0x00
+	keyEscaped    byte = 0xFF
+	keyTerminator byte = 0x01
+)
+
+// big.Float classes, in sort order
+const (
+	bigFloatNegInf byte = iota
+	bigFloatNeg
+	bigFloatZero
+	bigFloatPos
+	bigFloatPosInf
+)
+
+// EncodeIndexKey encodes the passed in values into an index key which preserves the sort order of the values.  Use
+// it in the IndexFunc of a custom Storer to allow queries to seek through the index rather than reading all of it.
+// Indexes on more than one field must pass in one value for each of the index's Fields, in the same order.
+// If all of the values are nil, the key is nil and the record will not be indexed
+func (s *Store) EncodeIndexKey(values ...interface{}) ([]byte, error) {
+	if len(values) == 1 {
+		return s.encodeIndexKey(values[0])
+	}
+
+	var key []byte
+	allNil := true
+
+	for i := range values {
+		start := len(key)
+		var err error
+		key, err = s.appendIndexKey(key, values[i])
+		if err != nil {
+			return nil, err
+		}
+		if len(key) == start {
+			key = append(key, keyTagNil)
+			continue
+		}
+		allNil = false
+	}
+
+	if allNil {
+		return nil, nil
+	}
+
+	return key, nil
+}
+
+// encodeIndexKey encodes the passed in value into an index key.  Nil values (and nil pointers) return a nil key, which
+// is never written to an index
+func (s *Store) encodeIndexKey(value interface{}) ([]byte, error) {
+	return s.appendIndexKey(nil, value)
+}
+
+// storedValue returns the value of a record's field as it's read back from the store, which is nil for pointers to
+// empty values that the store's encoder doesn't keep.  Gob, for one, doesn't send zero values, so a pointer to a
+// zero value is decoded as a nil pointer, and mustn't be indexed as the value it pointed to when it was written
+func (s *Store) storedValue(value interface{}) interface{} {
+	val := reflect.ValueOf(value)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return value
+	}
+
+	elem := val
+	for elem.Kind() == reflect.Ptr && !elem.IsNil() {
+		elem = elem.Elem()
+	}
+	switch {
+	case elem.Kind() == reflect.Ptr:
+		return value
+	case elem.IsZero():
+	case (elem.Kind() == reflect.Slice || elem.Kind() == reflect.Map) && elem.Len() == 0:
+	default:
+		return value
+	}
+
+	if s.keepsPointer(val) {
+		return value
+	}
+	return nil
+}
+
+// keepsPointer returns whether a struct field with the pointer, which points to an empty value, is still set when
+// it's encoded and decoded by the store.  The answer is the same for every empty value of a type, so it's only
+// worked out once for each type
+func (s *Store) keepsPointer(ptr reflect.Value) bool {
+	s.keptPointersLock.RLock()
+	kept, ok := s.keptPointers[ptr.Type()]
+	s.keptPointersLock.RUnlock()
+	if ok {
+		return kept
+	}
+
+	tp := reflect.StructOf([]reflect.StructField{{Name: "Value", Type: ptr.Type()}})
+	probe := reflect.New(tp)
+	probe.Elem().Field(0).Set(ptr)
+
+	kept = true
+	data, err := s.encode(probe.Interface())
+	if err == nil {
+		decoded := reflect.New(tp)
+		if s.decode(data, decoded.Interface()) == nil {
+			kept = !decoded.Elem().Field(0).IsNil()
+		}
+	}
+
+	s.keptPointersLock.Lock()
+	defer s.keptPointersLock.Unlock()
+	if s.keptPointers == nil {
+		s.keptPointers = make(map[reflect.Type]bool)
+	}
+	s.keptPointers[ptr.Type()] = kept
+	return kept
+}
+
+func (s *Store) appendIndexKey(dst []byte, value interface{}) ([]byte, error) {
+	if value == nil {
+		return dst, nil
+	}
+
+	val := reflect.ValueOf(value)
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return dst, nil
+		}
+		val = val.Elem()
+	}
+
+	value = val.Interface()
+
+	switch v := value.(type) {
+	case time.Time:
+		dst = append(dst, keyTagTime)
+		dst = appendUint64(dst, uint64(v.Unix())^(1<<63))
+		var nsec [4]byte
+		binary.BigEndian.PutUint32(nsec[:], uint32(v.Nanosecond()))
+		return append(dst, nsec[:]...), nil
+	case big.Int:
+		return appendBigInt(append(dst, keyTagBigInt), &v), nil
+	case big.Float:
+		return appendBigFloat(append(dst, keyTagBigFloat), &v), nil
+	case bool:
+		if v {
+			return append(dst, keyTagBool, 1), nil
+		}
+		return append(dst, keyTagBool, 0), nil
+	case int:
+		return appendUint64(append(dst, keyTagInt), uint64(v)^(1<<63)), nil
+	case int8:
+		return appendUint64(append(dst, keyTagInt), uint64(v)^(1<<63)), nil
+	case int16:
+		return appendUint64(append(dst, keyTagInt), uint64(v)^(1<<63)), nil
+	case int32:
+		return appendUint64(append(dst, keyTagInt), uint64(v)^(1<<63)), nil
+	case int64:
+		return appendUint64(append(dst, keyTagInt), uint64(v)^(1<<63)), nil
+	case uint:
+		return appendUint64(append(dst, keyTagUint), uint64(v)), nil
+	case uint8:
+		return appendUint64(append(dst, keyTagUint), uint64(v)), nil
+	case uint16:
+		return appendUint64(append(dst, keyTagUint), uint64(v)), nil
+	case uint32:
+		return appendUint64(append(dst, keyTagUint), uint64(v)), nil
+	case uint64:
+		return appendUint64(append(dst, keyTagUint), v), nil
+	case float32:
+		return appendFloat(append(dst, keyTagFloat), float64(v)), nil
+	case float64:
+		return appendFloat(append(dst, keyTagFloat), v), nil
+	case string:
+		return appendEscaped(append(dst, keyTagString), []byte(v)), nil
+	case []byte:
+		return appendEscaped(append(dst, keyTagBytes), v), nil
+	}
+
+	encoded, err := s.encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return appendEscaped(append(dst, keyTagEncoded), encoded), nil
+}
+
+// decodeIndexKey decodes an index key encoded with encodeIndexKey into value, which must be a pointer
+func (s *Store) decodeIndexKey(data []byte, value interface{}) error {
+	if !isIndexKey(data) {
+		return errInvalidIndexKey
+	}
+
+	_, err := s.decodeIndexKeyValue(data, reflect.ValueOf(value).Elem())
+	return err
+}
+
+// decodeIndexKeyValue decodes the first value from data into target, and returns the remaining data
+func (s *Store) decodeIndexKeyValue(data []byte, target reflect.Value) ([]byte, error) {
+	if data[0] == keyTagEncoded {
+		encoded, rest, err := readEscaped(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		return rest, s.decode(encoded, target.Addr().Interface())
+	}
+
+	natural, rest, err := readIndexKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if natural == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return rest, nil
+	}
+
+	nVal := reflect.ValueOf(natural)
+
+	switch {
+	case target.Kind() == reflect.Interface:
+		target.Set(nVal)
+		return rest, nil
+	case target.Type() == nVal.Type():
+		target.Set(nVal)
+		return rest, nil
+	}
+
+	switch n := natural.(type) {
+	case int64:
+		switch target.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if !target.OverflowInt(n) {
+				target.SetInt(n)
+				return rest, nil
+			}
+		}
+	case uint64:
+		switch target.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if !target.OverflowUint(n) {
+				target.SetUint(n)
+				return rest, nil
+			}
+		}
+	case float64:
+		switch target.Kind() {
+		case reflect.Float32, reflect.Float64:
+			target.SetFloat(n)
+			return rest, nil
+		}
+	case string:
+		if target.Kind() == reflect.String {
+			target.SetString(n)
+			return rest, nil
+		}
+	case []byte:
+		if target.Kind() == reflect.Slice && target.Type().Elem().Kind() == reflect.Uint8 {
+			target.SetBytes(n)
+			return rest, nil
+		}
+	case bool:
+		if target.Kind() == reflect.Bool {
+			target.SetBool(n)
+			return rest, nil
+		}
+	}
+
+	return nil, &ErrTypeMismatch{natural, target.Interface()}
+}
+
+// splitIndexKey splits a key made up of more than one value into the encoded values
+func splitIndexKey(data []byte) ([][]byte, error) {
+	var parts [][]byte
+
+	for len(data) > 0 {
+		if !isIndexKey(data) {
+			return nil, errInvalidIndexKey
+		}
+
+		var rest []byte
+		var err error
+
+		if data[0] == keyTagEncoded {
+			_, rest, err = readEscaped(data[1:])
+		} else {
+			_, rest, err = readIndexKey(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, data[:len(data)-len(rest)])
+		data = rest
+	}
+
+	return parts, nil
+}
+
+// isNilIndexKey returns whether the data is the key of a nil value in a composite index key
+func isNilIndexKey(data []byte) bool {
+	return len(data) == 1 && data[0] == keyTagNil
+}
+
+// isIndexKey returns whether or not the data starts with the tag of a value encoded with encodeIndexKey
+func isIndexKey(data []byte) bool {
+	return len(data) > 0 && data[0] >= keyTagNil && data[0] <= keyTagEncoded
+}
+
+// isOrderedIndexKey returns whether or not the data is a whole index key, and the byte order of the key matches the
+// order of the value it was encoded from
+func isOrderedIndexKey(data []byte) bool {
+	if !isIndexKey(data) || data[0] == keyTagEncoded {
+		return false
+	}
+	_, err := splitIndexKey(data)
+	return err == nil
+}
+
+// readIndexKey reads the first value in data, and returns it in its natural type, along with the remaining data
+// int kinds are returned as int64, uint kinds as uint64, and floats as float64
+func readIndexKey(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, errInvalidIndexKey
+	}
+
+	tag := data[0]
+	data = data[1:]
+
+	switch tag {
+	case keyTagNil:
+		return nil, data, nil
+	case keyTagBool:
+		if len(data) < 1 {
+			return nil, nil, errInvalidIndexKey
+		}
+		return data[0] == 1, data[1:], nil
+	case keyTagInt:
+		if len(data) < 8 {
+			return nil, nil, errInvalidIndexKey
+		}
+		return int64(binary.BigEndian.Uint64(data) ^ (1 << 63)), data[8:], nil
+	case keyTagUint:
+		if len(data) < 8 {
+			return nil, nil, errInvalidIndexKey
+		}
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	case keyTagFloat:
+		if len(data) < 8 {
+			return nil, nil, errInvalidIndexKey
+		}
+		bits := binary.BigEndian.Uint64(data)
+		if bits&(1<<63) != 0 {
+			bits ^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return math.Float64frombits(bits), data[8:], nil
+	case keyTagTime:
+		if len(data) < 12 {
+			return nil, nil, errInvalidIndexKey
+		}
+		sec := int64(binary.BigEndian.Uint64(data) ^ (1 << 63))
+		nsec := int64(binary.BigEndian.Uint32(data[8:]))
+		return time.Unix(sec, nsec).UTC(), data[12:], nil
+	case keyTagString:
+		value, rest, err := readEscaped(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return string(value), rest, nil
+	case keyTagBytes:
+		return readEscaped(data)
+	case keyTagBigInt:
+		return readBigInt(data)
+	case keyTagBigFloat:
+		return readBigFloat(data)
+	}
+
+	return nil, nil, errInvalidIndexKey
+}
+
+var errInvalidIndexKey = errors.New("Invalid index key")
+
+func appendUint64(dst []byte, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(dst, b[:]...)
+}
+
+// appendFloat flips the sign bit of positive numbers, and all the bits of negative numbers so that the ordering
+// of the bytes matches the order of the floats
+func appendFloat(dst []byte, v float64) []byte {
+	if v == 0 {
+		// -0 == 0
+		v = 0
+	}
+	bits := math.Float64bits(v)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits ^= 1 << 63
+	}
+	return appendUint64(dst, bits)
+}
+
+func appendEscaped(dst, src []byte) []byte {
+	for _, b := range src {
+		if b == keyEscape {
+			dst = append(dst, keyEscape, keyEscaped)
+			continue
+		}
+		dst = append(dst, b)
+	}
+	return append(dst, keyEscape, keyTerminator)
+}
+
+func readEscaped(data []byte) (value, rest []byte, err error) {
+	value = make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] != keyEscape {
+			value = append(value, data[i])
+			continue
+		}
+		if i+1 >= len(data) {
+			return nil, nil, errInvalidIndexKey
+		}
+		i++
+		switch data[i] {
+		case keyEscaped:
+			value = append(value, keyEscape)
+		case keyTerminator:
+			return value, data[i+1:], nil
+		default:
+			return nil, nil, errInvalidIndexKey
+		}
+	}
+	return nil, nil, errInvalidIndexKey
+}
+
+// appendBigInt writes the sign, followed by the length and bytes of the magnitude.  The length and magnitude of
+// negative numbers are inverted, so larger magnitudes sort first
+func appendBigInt(dst []byte, v *big.Int) []byte {
+	sign := v.Sign()
+	dst = append(dst, byte(sign+1))
+	if sign == 0 {
+		return dst
+	}
+
+	mag := v.Bytes()
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(mag)))
+
+	start := len(dst)
+	dst = append(dst, length[:]...)
+	dst = append(dst, mag...)
+	if sign < 0 {
+		invert(dst[start:])
+	}
+	return dst
+}
+
+func readBigInt(data []byte) (interface{}, []byte, error) {
+	if len(data) < 1 {
+		return nil, nil, errInvalidIndexKey
+	}
+	sign := int(data[0]) - 1
+	data = data[1:]
+
+	var v big.Int
+	if sign == 0 {
+		return v, data, nil
+	}
+
+	if len(data) < 4 {
+		return nil, nil, errInvalidIndexKey
+	}
+
+	var length [4]byte
+	copy(length[:], data)
+	if sign < 0 {
+		invert(length[:])
+	}
+	n := int(binary.BigEndian.Uint32(length[:]))
+	data = data[4:]
+	if len(data) < n {
+		return nil, nil, errInvalidIndexKey
+	}
+
+	mag := make([]byte, n)
+	copy(mag, data)
+	if sign < 0 {
+		invert(mag)
+	}
+	v.SetBytes(mag)
+	if sign < 0 {
+		v.Neg(&v)
+	}
+	return v, data[n:], nil
+}
+
+// appendBigFloat writes the class (infinities, negative, zero, positive), followed by the exponent and the
+// mantissa bits of finite, non-zero numbers.  The exponent and mantissa of negative numbers are inverted
+func appendBigFloat(dst []byte, v *big.Float) []byte {
+	switch {
+	case v.IsInf() && v.Sign() < 0:
+		return append(dst, bigFloatNegInf)
+	case v.IsInf():
+		return append(dst, bigFloatPosInf)
+	case v.Sign() == 0:
+		return append(dst, bigFloatZero)
+	case v.Sign() < 0:
+		dst = append(dst, bigFloatNeg)
+	default:
+		dst = append(dst, bigFloatPos)
+	}
+
+	mant := new(big.Float).SetPrec(v.Prec())
+	exp := v.MantExp(mant)
+	mant.Abs(mant)
+
+	// scale the mantissa to an integer with exactly MinPrec bits, then left align it on a byte boundary
+	bits := int(v.MinPrec())
+	mant.SetMantExp(mant, bits)
+	mi, _ := mant.Int(nil)
+	mi.Lsh(mi, uint((8-bits%8)%8))
+
+	start := len(dst)
+	var e [4]byte
+	binary.BigEndian.PutUint32(e[:], uint32(int32(exp))^(1<<31))
+	dst = append(dst, e[:]...)
+	dst = appendEscaped(dst, mi.Bytes())
+	if v.Sign() < 0 {
+		invert(dst[start:])
+	}
+	return dst
+}
+
+func readBigFloat(data []byte) (interface{}, []byte, error) {
+	if len(data) < 1 {
+		return nil, nil, errInvalidIndexKey
+	}
+
+	class := data[0]
+	data = data[1:]
+
+	var v big.Float
+	switch class {
+	case bigFloatNegInf:
+		v.SetInf(true)
+		return v, data, nil
+	case bigFloatPosInf:
+		v.SetInf(false)
+		return v, data, nil
+	case bigFloatZero:
+		return v, data, nil
+	case bigFloatNeg, bigFloatPos:
+	default:
+		return nil, nil, errInvalidIndexKey
+	}
+
+	if len(data) < 4 {
+		return nil, nil, errInvalidIndexKey
+	}
+
+	neg := class == bigFloatNeg
+	buf := data
+	if neg {
+		// the escaped mantissa is inverted, so find its end before reading it
+		buf = make([]byte, len(data))
+		copy(buf, data)
+		invert(buf)
+	}
+
+	exp := int(int32(binary.BigEndian.Uint32(buf) ^ (1 << 31)))
+	mantBytes, rest, err := readEscaped(buf[4:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mi := new(big.Int).SetBytes(mantBytes)
+	if mi.Sign() == 0 {
+		return nil, nil, errInvalidIndexKey
+	}
+	mi.Rsh(mi, mi.TrailingZeroBits())
+
+	bits := mi.BitLen()
+	v.SetPrec(uint(bits)).SetInt(mi)
+	v.SetMantExp(&v, exp-bits)
+	if neg {
+		v.Neg(&v)
+	}
+
+	return v, data[len(data)-len(rest):], nil
+}
+
+func invert(b []byte) {
+	for i := range b {
+		b[i] = ^b[i]
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold
+
+import (
+	"fmt"
+	"reflect"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Iterator reads the records that match a query one at a time, as Next is called.  Records are returned in the same
+// order, and with the same Skip, Limit and Or'd queries, as Find.
+/*
+Iterator Example
+
+	iter := store.Iter(tx, bolthold.Where("Category").Eq("books"), &Item{})
+	defer iter.Close()
+
+	for iter.Next() {
+		var item Item
+		if err := iter.Scan(&item); err != nil {
+			return err
+		}
+		// do stuff with item
+	}
+
+	return iter.Err()
+*/
+type Iterator struct {
+	store    *Store
+	source   BucketSource
+	dataType interface{}
+	query    *Query
+
+	it      *queryIterator
+	current *record
+	err     error
+	closed  bool
+}
+
+// Iter returns an Iterator over the records of dataType that match the query, read within the passed in
+// transaction.  The Iterator can't be used once the transaction is closed.
+func (s *Store) Iter(tx *bolt.Tx, query *Query, dataType interface{}) *Iterator {
+	return s.newRecordIterator(tx, query, dataType)
+}
+
+// IterInBucket is the same as Iter but you get to specify your parent bucket
+func (s *Store) IterInBucket(parent *bolt.Bucket, query *Query, dataType interface{}) *Iterator {
+	return s.newRecordIterator(parent, query, dataType)
+}
+
+func (s *Store) newRecordIterator(source BucketSource, query *Query, dataType interface{}) *Iterator {
+	if query == nil {
+		query = &Query{}
+	}
+
+	return &Iterator{
+		store:    s,
+		source:   source,
+		dataType: dataType,
+		query:    query,
+	}
+}
+
+// Next moves the iterator to the next record that matches the query, and returns false if there are no more
+// records, or an error occurred
+func (i *Iterator) Next() bool {
+	i.current = nil
+
+	if i.closed || i.err != nil {
+		return false
+	}
+
+	if i.it == nil {
+		i.it, i.err = i.store.newQueryIterator(i.source, i.dataType, i.query, nil, i.query.skip)
+		if i.err != nil {
+			return false
+		}
+	}
+
+	i.current, i.err = i.it.next()
+
+	return i.current != nil
+}
+
+// Scan copies the current record into dest, which must be a pointer to the type of the records, or a pointer to a
+// pointer of that type.  If the type has a field with the boltholdKey tag, it's set to the record's key.
+// Scan will panic if it's called without a current record
+func (i *Iterator) Scan(dest interface{}) error {
+	if i.current == nil {
+		panic("Scan called without a current record, Next must be called and return true first")
+	}
+
+	destVal := reflect.ValueOf(dest)
+	if destVal.Kind() != reflect.Ptr || destVal.IsNil() {
+		panic("dest argument must be a pointer")
+	}
+
+	value, err := i.query.project(i.current.value)
+	if err != nil {
+		return err
+	}
+	target := destVal.Elem()
+
+	switch target.Type() {
+	case value.Type():
+		target.Set(value)
+	case value.Elem().Type():
+		target.Set(value.Elem())
+		value = target.Addr()
+	default:
+		return fmt.Errorf("Cannot scan a record of type %s into a %s", value.Elem().Type(), target.Type())
+	}
+
+	if field, ok := keyField(value.Elem().Type()); ok {
+		return i.store.decode(i.current.key, value.Elem().FieldByIndex(field.Index).Addr().Interface())
+	}
+
+	return nil
+}
+
+// Key returns the encoded key of the current record, or nil if there is no current record
+func (i *Iterator) Key() []byte {
+	if i.current == nil {
+		return nil
+	}
+	return i.current.key
+}
+
+// ScanKey decodes the key of the current record into dest.
+// ScanKey will panic if it's called without a current record
+func (i *Iterator) ScanKey(dest interface{}) error {
+	if i.current == nil {
+		panic("ScanKey called without a current record, Next must be called and return true first")
+	}
+
+	return i.store.decode(i.current.key, dest)
+}
+
+// Err returns the error that stopped the iterator, if any
+func (i *Iterator) Err() error {
+	return i.err
+}
+
+// Close stops the iterator, after which Next always returns false, and removes any temporary file the records of
+// a sorted query were spilled to.  Close doesn't close the transaction the iterator reads from
+func (i *Iterator) Close() error {
+	i.closed = true
+	i.current = nil
+	if i.it != nil {
+		i.it.close()
+	}
+	i.it = nil
+	return nil
+}
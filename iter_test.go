@@ -0,0 +1,143 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"testing"
+
+	"github.com/timshannon/bolthold"
+	bolt "go.etcd.io/bbolt"
+)
+
+func iterAll(t *testing.T, store *bolthold.Store, query *bolthold.Query) []ItemTest {
+	var result []ItemTest
+	ok(t, store.Bolt().View(func(tx *bolt.Tx) error {
+		iter := store.Iter(tx, query, &ItemTest{})
+		defer iter.Close()
+
+		for iter.Next() {
+			var item ItemTest
+			ok(t, iter.Scan(&item))
+			result = append(result, item)
+		}
+		return iter.Err()
+	}))
+	return result
+}
+
+func TestIter(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+		for _, tst := range testResults {
+			t.Run(tst.name, func(t *testing.T) {
+				var expected []ItemTest
+				ok(t, store.Find(&expected, tst.query))
+
+				equals(t, expected, iterAll(t, store, tst.query))
+			})
+		}
+
+		t.Run("Or Skip Limit", func(t *testing.T) {
+			query := func() *bolthold.Query {
+				return bolthold.Where("Category").Eq("vehicle").Or(bolthold.Where("Category").Eq("animal")).
+					Skip(3).Limit(5)
+			}
+
+			var expected []ItemTest
+			ok(t, store.Find(&expected, query()))
+			equals(t, 5, len(expected))
+
+			equals(t, expected, iterAll(t, store, query()))
+		})
+
+		t.Run("Sorted", func(t *testing.T) {
+			query := func() *bolthold.Query {
+				return bolthold.Where("Category").Eq("food").SortBy("Name").Reverse().Limit(3)
+			}
+
+			var expected []ItemTest
+			ok(t, store.Find(&expected, query()))
+
+			equals(t, expected, iterAll(t, store, query()))
+		})
+	})
+}
+
+func TestIterKey(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPageData(t, store)
+
+		ok(t, store.Bolt().View(func(tx *bolt.Tx) error {
+			iter := store.Iter(tx, bolthold.Where("Group").Eq("group2"), &PageItem{})
+			defer iter.Close()
+
+			count := 0
+			for iter.Next() {
+				count++
+
+				item := &PageItem{}
+				ok(t, iter.Scan(&item))
+				equals(t, "group2", item.Group)
+
+				var key int
+				ok(t, iter.ScanKey(&key))
+				equals(t, key, item.ID)
+
+				encoded, err := bolthold.DefaultEncode(key)
+				ok(t, err)
+				equals(t, encoded, iter.Key())
+			}
+
+			ok(t, iter.Err())
+			equals(t, 14, count)
+			return nil
+		}))
+	})
+}
+
+func TestIterClose(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		ok(t, store.Bolt().View(func(tx *bolt.Tx) error {
+			iter := store.Iter(tx, nil, &ItemTest{})
+			assert(t, iter.Next(), "Iterator has no records")
+			ok(t, iter.Close())
+
+			assert(t, !iter.Next(), "Closed iterator returned a record")
+			assert(t, iter.Key() == nil, "Closed iterator has a current key")
+			ok(t, iter.Err())
+			return nil
+		}))
+	})
+}
+
+func TestIterErrors(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		ok(t, store.Bolt().View(func(tx *bolt.Tx) error {
+			iter := store.Iter(tx, bolthold.Where("Name").Eq("test").Index("BadIndex"), &ItemTest{})
+			assert(t, !iter.Next(), "Iterator on a missing index returned a record")
+			assert(t, iter.Err() != nil, "Iterator on a missing index didn't return an error")
+
+			iter = store.Iter(tx, nil, &ItemTest{})
+			assert(t, iter.Next(), "Iterator has no records")
+
+			var wrong PageItem
+			assert(t, iter.Scan(&wrong) != nil, "Scan into the wrong type didn't return an error")
+			return nil
+		}))
+
+		defer func() {
+			assert(t, recover() != nil, "Scan without a current record didn't panic")
+		}()
+
+		ok(t, store.Bolt().View(func(tx *bolt.Tx) error {
+			var item ItemTest
+			return store.Iter(tx, nil, &ItemTest{}).Scan(&item)
+		}))
+	})
+}
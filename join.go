@@ -0,0 +1,533 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold
+
+import (
+	"fmt"
+	"reflect"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// JoinSpec describes how the records of another type are joined to the records returned by FindJoin, see Join
+type JoinSpec struct {
+	field     string
+	dataType  interface{}
+	joinField string
+	into      string
+}
+
+// Join joins the records of dataType whose joinField matches the field of the records returned by FindJoin.  Either
+// field can be bolthold.Key.  If field is a slice, records matching any of its values are joined.  The joined
+// records are set on the field of the result's type that is of dataType, or a pointer or slice of it, unless a field
+// is named with Into.
+/*
+Join Example
+
+	type OrderWithCustomer struct {
+		Order
+		Customer *Customer
+	}
+
+	var result []OrderWithCustomer
+	err := store.FindJoin(&result, bolthold.Where("Status").Eq("open"),
+		bolthold.Join("CustomerID", &Customer{}, bolthold.Key))
+*/
+func Join(field string, dataType interface{}, joinField string) *JoinSpec {
+	if !startsUpper(field) || !startsUpper(joinField) {
+		panic("The first letter of a field in a bolthold join must be upper-case")
+	}
+
+	return &JoinSpec{
+		field:     field,
+		dataType:  dataType,
+		joinField: joinField,
+	}
+}
+
+// Into sets the field of the result that the joined records are set on
+func (j *JoinSpec) Into(field string) *JoinSpec {
+	if !startsUpper(field) {
+		panic("The first letter of a field in a bolthold join must be upper-case")
+	}
+	j.into = field
+	return j
+}
+
+// FindJoin runs the query, and sets the matching records on the result slice along with the records joined to each
+// of them.  Result must be a pointer to a slice of the queried type, or of a struct that embeds the queried type as
+// its first field.  A queried type that itself embeds a struct as its first field has to be wrapped this way.
+// Joined records are read in the same transaction, by key when joined on bolthold.Key, through an index on the
+// joined field of the other type if there is one, or else by reading every record of the other type once.  The
+// values of both fields must be of the same type.
+func (s *Store) FindJoin(result interface{}, query *Query, joins ...*JoinSpec) error {
+	return s.Bolt().View(func(tx *bolt.Tx) error {
+		return s.findJoin(tx, result, query, joins)
+	})
+}
+
+// TxFindJoin is the same as FindJoin but you get to specify your transaction
+func (s *Store) TxFindJoin(tx *bolt.Tx, result interface{}, query *Query, joins ...*JoinSpec) error {
+	return s.findJoin(tx, result, query, joins)
+}
+
+// FindJoinInBucket is the same as FindJoin but you get to specify your parent bucket
+func (s *Store) FindJoinInBucket(parent *bolt.Bucket, result interface{}, query *Query, joins ...*JoinSpec) error {
+	return s.findJoin(parent, result, query, joins)
+}
+
+func (s *Store) findJoin(source BucketSource, result interface{}, query *Query, joins []*JoinSpec) error {
+	if query == nil {
+		query = &Query{}
+	}
+
+	resultVal := reflect.ValueOf(result)
+	if resultVal.Kind() != reflect.Ptr || resultVal.Elem().Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
+
+	sliceVal := resultVal.Elem()
+	elType := sliceVal.Type().Elem()
+
+	tp := elType
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	if tp.Kind() != reflect.Struct {
+		panic("result argument must be a slice of structs")
+	}
+
+	// the queried type is the result's type, or the struct the result's type embeds first
+	recordType := tp
+	embedded := false
+	if tp.NumField() > 0 && tp.Field(0).Anonymous {
+		first := tp.Field(0).Type
+		for first.Kind() == reflect.Ptr {
+			first = first.Elem()
+		}
+		if first.Kind() == reflect.Struct {
+			recordType = first
+			embedded = true
+		}
+	}
+
+	joiners := make([]*joiner, len(joins))
+	for i := range joins {
+		var err error
+		joiners[i], err = s.newJoiner(source, joins[i], tp, recordType)
+		if err != nil {
+			return err
+		}
+	}
+
+	run := query
+	if len(query.selected) > 0 {
+		// the fields records are joined on have to be read, even when they aren't selected
+		qCopy := *query
+		qCopy.selected = append([]string{}, query.selected...)
+		for i := range joins {
+			if joins[i].field != Key {
+				qCopy.selected = append(qCopy.selected, joins[i].field)
+			}
+		}
+		run = &qCopy
+	}
+
+	var records []*record
+	err := s.runQuery(source, reflect.New(recordType).Interface(), run, nil, run.skip, func(r *record) error {
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	recordKey, hasKey := keyField(recordType)
+
+	for _, r := range records {
+		if hasKey {
+			err = s.decode(r.key, r.value.Elem().FieldByIndex(recordKey.Index).Addr().Interface())
+			if err != nil {
+				return err
+			}
+		}
+
+		value, err := query.project(r.value)
+		if err != nil {
+			return err
+		}
+
+		row := value
+		if embedded {
+			row = reflect.New(tp)
+			first := row.Elem().Field(0)
+			if first.Kind() == reflect.Ptr {
+				first.Set(value)
+			} else {
+				first.Set(value.Elem())
+			}
+		}
+
+		for _, j := range joiners {
+			// the whole record is joined, even if the fields it's joined on weren't selected
+			err = j.join(r.value.Elem(), row.Elem())
+			if err != nil {
+				return err
+			}
+		}
+
+		if elType.Kind() == reflect.Ptr {
+			sliceVal = reflect.Append(sliceVal, row)
+		} else {
+			sliceVal = reflect.Append(sliceVal, row.Elem())
+		}
+	}
+
+	resultVal.Elem().Set(sliceVal)
+
+	return nil
+}
+
+// joiner looks up the records joined to each record returned by FindJoin
+type joiner struct {
+	store    *Store
+	spec     *JoinSpec
+	source   BucketSource
+	joinType reflect.Type // the struct type of the joined records
+	keyField string       // the key field of the joined type
+	typeName string
+
+	recordKeyField string // the key field of the queried type, for joins on its Key
+
+	into  []int // the index of the field of the result the joined records are set on
+	slice bool  // whether the field is a slice of joined records
+
+	index   string                     // the index on the joined field, if it can be used
+	scanned map[string][]reflect.Value // the joined records by encoded join field, when they're all read
+	cache   map[string][]reflect.Value
+}
+
+func (s *Store) newJoiner(source BucketSource, spec *JoinSpec, resultType, recordType reflect.Type) (*joiner, error) {
+	joinType := reflect.TypeOf(spec.dataType)
+	for joinType != nil && joinType.Kind() == reflect.Ptr {
+		joinType = joinType.Elem()
+	}
+	if joinType == nil || joinType.Kind() != reflect.Struct {
+		panic("The data type of a join must be a struct")
+	}
+
+	storer := s.newStorer(spec.dataType)
+	joinKey, _ := keyField(joinType)
+	recordKey, _ := keyField(recordType)
+
+	j := &joiner{
+		store:          s,
+		spec:           spec,
+		source:         source,
+		joinType:       joinType,
+		keyField:       joinKey.Name,
+		typeName:       storer.Type(),
+		recordKeyField: recordKey.Name,
+		cache:          make(map[string][]reflect.Value),
+	}
+
+	if spec.field == Key && j.recordKeyField == "" {
+		return nil, fmt.Errorf("The type %s needs a field tagged %s to be joined on its Key", recordType,
+			BoltholdKeyTag)
+	}
+
+	if spec.field != Key {
+		_, err := sortFieldType(recordType, spec.field)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.joinField != Key {
+		_, err := sortFieldType(joinType, spec.joinField)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the field the joined records are set on
+	isJoinType := func(tp reflect.Type) (ok, slice bool) {
+		if tp.Kind() == reflect.Slice {
+			slice = true
+			tp = tp.Elem()
+		}
+		for tp.Kind() == reflect.Ptr {
+			tp = tp.Elem()
+		}
+		return tp == joinType, slice
+	}
+
+	if spec.into != "" {
+		field, found := resultType.FieldByName(spec.into)
+		if !found {
+			return nil, fmt.Errorf("The field %s does not exist in the type %s", spec.into, resultType)
+		}
+		ok, slice := isJoinType(field.Type)
+		if !ok {
+			return nil, fmt.Errorf("The field %s of the type %s can't hold records of the type %s", spec.into,
+				resultType, joinType)
+		}
+		j.into = field.Index
+		j.slice = slice
+	} else {
+		for _, field := range reflect.VisibleFields(resultType) {
+			if !field.IsExported() || field.Anonymous {
+				continue
+			}
+			ok, slice := isJoinType(field.Type)
+			if !ok {
+				continue
+			}
+			if j.into != nil {
+				return nil, fmt.Errorf("The type %s has more than one field for records of the type %s, use Into "+
+					"to pick one", resultType, joinType)
+			}
+			j.into = field.Index
+			j.slice = slice
+		}
+		if j.into == nil {
+			return nil, fmt.Errorf("The type %s has no field for records of the type %s", resultType, joinType)
+		}
+	}
+
+	if spec.joinField == Key {
+		return j, nil
+	}
+
+	if _, ok := storer.(*anonStorer); !ok {
+		// the index keys of custom indexes can't be built from a single value
+		return j, nil
+	}
+
+	for name, index := range storer.Indexes() {
+		if len(index.Fields) != 1 || index.Fields[0] != spec.joinField {
+			continue
+		}
+		iBucket := source.Bucket(indexBucketName(j.typeName, name))
+		if iBucket != nil && iBucket.Sequence() == indexVersion {
+			j.index = name
+			break
+		}
+	}
+
+	return j, nil
+}
+
+// join sets the records joined to the record on the row
+func (j *joiner) join(record, row reflect.Value) error {
+	var local interface{}
+	if j.spec.field == Key {
+		local = record.FieldByName(j.recordKeyField).Interface()
+	} else {
+		fVal, err := fieldValue(record, j.spec.field)
+		if err != nil {
+			return err
+		}
+		local = fVal
+	}
+
+	value := reflect.ValueOf(local)
+	if rv, ok := local.(reflect.Value); ok {
+		value = rv
+	}
+	for value.IsValid() && value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if !value.IsValid() {
+		return nil
+	}
+
+	values := []reflect.Value{value}
+	if value.Kind() == reflect.Slice && value.Type().Elem().Kind() != reflect.Uint8 {
+		values = make([]reflect.Value, value.Len())
+		for i := range values {
+			values[i] = value.Index(i)
+		}
+	}
+
+	dest, err := row.FieldByIndexErr(j.into)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		joined, err := j.lookup(v.Interface())
+		if err != nil {
+			return err
+		}
+
+		for _, jv := range joined {
+			copied := reflect.New(j.joinType)
+			copied.Elem().Set(jv.Elem())
+
+			if !j.slice {
+				setJoined(dest, copied)
+				return nil
+			}
+
+			elem := reflect.New(dest.Type().Elem()).Elem()
+			setJoined(elem, copied)
+			dest.Set(reflect.Append(dest, elem))
+		}
+	}
+
+	return nil
+}
+
+// setJoined sets the joined record, a pointer to the joined type, on the field, which is the joined type or a
+// pointer to it
+func setJoined(field, joined reflect.Value) {
+	if field.Kind() != reflect.Ptr {
+		field.Set(joined.Elem())
+		return
+	}
+
+	for field.Type() != joined.Type() {
+		ptr := reflect.New(joined.Type())
+		ptr.Elem().Set(joined)
+		joined = ptr
+	}
+	field.Set(joined)
+}
+
+// lookup returns the records of the joined type whose join field matches the value
+func (j *joiner) lookup(value interface{}) ([]reflect.Value, error) {
+	s := j.store
+
+	if j.spec.joinField == Key {
+		key, err := s.encode(value)
+		if err != nil {
+			return nil, err
+		}
+		if cached, ok := j.cache[string(key)]; ok {
+			return cached, nil
+		}
+
+		var joined []reflect.Value
+		if bkt := j.source.Bucket([]byte(j.typeName)); bkt != nil {
+			if data := bkt.Get(key); data != nil {
+				record, err := j.decode(key, data)
+				if err != nil {
+					return nil, err
+				}
+				joined = append(joined, record)
+			}
+		}
+
+		j.cache[string(key)] = joined
+		return joined, nil
+	}
+
+	indexKey, err := s.encodeIndexKey(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if j.index == "" {
+		if j.scanned == nil {
+			err = j.scan()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return j.scanned[string(indexKey)], nil
+	}
+
+	if cached, ok := j.cache[string(indexKey)]; ok {
+		return cached, nil
+	}
+
+	var joined []reflect.Value
+
+	bkt := j.source.Bucket([]byte(j.typeName))
+	keys := j.source.Bucket(indexBucketName(j.typeName, j.index)).Bucket(indexKey)
+	if bkt != nil && keys != nil {
+		err = keys.ForEach(func(key, _ []byte) error {
+			data := bkt.Get(key)
+			if data == nil {
+				return nil
+			}
+			record, err := j.decode(key, data)
+			if err != nil {
+				return err
+			}
+			joined = append(joined, record)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	j.cache[string(indexKey)] = joined
+	return joined, nil
+}
+
+// scan reads every record of the joined type once, and groups them by their join field
+func (j *joiner) scan() error {
+	j.scanned = make(map[string][]reflect.Value)
+
+	bkt := j.source.Bucket([]byte(j.typeName))
+	if bkt == nil {
+		return nil
+	}
+
+	return bkt.ForEach(func(key, data []byte) error {
+		record, err := j.decode(key, data)
+		if err != nil {
+			return err
+		}
+
+		fVal, err := fieldValue(record.Elem(), j.spec.joinField)
+		if err != nil {
+			return err
+		}
+		if rv, ok := fVal.(reflect.Value); ok {
+			if !rv.IsValid() {
+				return nil
+			}
+			fVal = rv.Interface()
+		}
+
+		indexKey, err := j.store.encodeIndexKey(fVal)
+		if err != nil {
+			return err
+		}
+		if indexKey == nil {
+			// nil values are never joined
+			return nil
+		}
+
+		j.scanned[string(indexKey)] = append(j.scanned[string(indexKey)], record)
+		return nil
+	})
+}
+
+// decode decodes a record of the joined type, and sets its key field
+func (j *joiner) decode(key, data []byte) (reflect.Value, error) {
+	record := reflect.New(j.joinType)
+	err := j.store.decode(data, record.Interface())
+	if err != nil {
+		return record, err
+	}
+
+	if j.keyField != "" {
+		err = j.store.decode(key, record.Elem().FieldByName(j.keyField).Addr().Interface())
+		if err != nil {
+			return record, err
+		}
+	}
+
+	return record, nil
+}
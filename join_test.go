@@ -0,0 +1,159 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"testing"
+
+	"github.com/timshannon/bolthold"
+)
+
+type JoinCustomer struct {
+	ID     int `boltholdKey:"ID"`
+	Name   string
+	Region string `boltholdIndex:"Region"`
+}
+
+type JoinOrder struct {
+	ID           int `boltholdKey:"ID"`
+	CustomerID   int
+	CustomerName string
+	Status       string
+	ItemIDs      []int
+}
+
+type JoinItem struct {
+	ID      int `boltholdKey:"ID"`
+	OrderID int `boltholdIndex:"OrderID"`
+	Name    string
+}
+
+type OrderWithCustomer struct {
+	JoinOrder
+	Customer *JoinCustomer
+}
+
+type OrderDetail struct {
+	*JoinOrder
+	Customer JoinCustomer
+	Items    []JoinItem
+	Listed   []*JoinItem
+	ByName   []JoinCustomer
+}
+
+func insertJoinData(t *testing.T, store *bolthold.Store) {
+	customers := []JoinCustomer{
+		{ID: 1, Name: "Acme", Region: "east"},
+		{ID: 2, Name: "Globex", Region: "west"},
+		{ID: 3, Name: "Initech", Region: "east"},
+	}
+	for i := range customers {
+		ok(t, store.Insert(customers[i].ID, &customers[i]))
+	}
+
+	orders := []JoinOrder{
+		{ID: 10, CustomerID: 1, CustomerName: "Acme", Status: "open", ItemIDs: []int{100, 102}},
+		{ID: 11, CustomerID: 2, CustomerName: "Globex", Status: "open", ItemIDs: []int{101}},
+		{ID: 12, CustomerID: 1, CustomerName: "Acme", Status: "closed"},
+		{ID: 13, CustomerID: 4, CustomerName: "Nobody", Status: "open"},
+	}
+	for i := range orders {
+		ok(t, store.Insert(orders[i].ID, &orders[i]))
+	}
+
+	items := []JoinItem{
+		{ID: 100, OrderID: 10, Name: "anvil"},
+		{ID: 101, OrderID: 11, Name: "rocket"},
+		{ID: 102, OrderID: 10, Name: "magnet"},
+		{ID: 103, OrderID: 12, Name: "spring"},
+	}
+	for i := range items {
+		ok(t, store.Insert(items[i].ID, &items[i]))
+	}
+}
+
+func TestFindJoin(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertJoinData(t, store)
+
+		var result []OrderWithCustomer
+		ok(t, store.FindJoin(&result, bolthold.Where("Status").Eq("open"),
+			bolthold.Join("CustomerID", &JoinCustomer{}, bolthold.Key)))
+		equals(t, 3, len(result))
+		equals(t, 10, result[0].ID)
+		equals(t, &JoinCustomer{ID: 1, Name: "Acme", Region: "east"}, result[0].Customer)
+		equals(t, "Globex", result[1].Customer.Name)
+		assert(t, result[2].Customer == nil, "Order joined to a customer that doesn't exist")
+
+		// each order gets its own copy of a customer joined to several orders
+		result = nil
+		ok(t, store.FindJoin(&result, bolthold.Where("CustomerID").Eq(1),
+			bolthold.Join("CustomerID", &JoinCustomer{}, bolthold.Key)))
+		equals(t, 2, len(result))
+		assert(t, result[0].Customer != result[1].Customer, "Joined records are shared between results")
+
+		var details []*OrderDetail
+		ok(t, store.FindJoin(&details, bolthold.Where(bolthold.Key).In(10, 12),
+			bolthold.Join("CustomerID", &JoinCustomer{}, bolthold.Key).Into("Customer"),
+			bolthold.Join(bolthold.Key, &JoinItem{}, "OrderID").Into("Items"),
+			bolthold.Join("ItemIDs", &JoinItem{}, bolthold.Key).Into("Listed"),
+			bolthold.Join("CustomerName", &JoinCustomer{}, "Name").Into("ByName")))
+		equals(t, 2, len(details))
+
+		equals(t, "Acme", details[0].Customer.Name)
+		equals(t, []JoinItem{{ID: 100, OrderID: 10, Name: "anvil"}, {ID: 102, OrderID: 10, Name: "magnet"}},
+			details[0].Items)
+		equals(t, []*JoinItem{{ID: 100, OrderID: 10, Name: "anvil"}, {ID: 102, OrderID: 10, Name: "magnet"}},
+			details[0].Listed)
+		equals(t, []JoinCustomer{{ID: 1, Name: "Acme", Region: "east"}}, details[0].ByName)
+
+		equals(t, []JoinItem{{ID: 103, OrderID: 12, Name: "spring"}}, details[1].Items)
+		equals(t, 0, len(details[1].Listed))
+
+		// records joined to a field of the queried type, on fields that weren't selected
+		var east []JoinCustomerOrders
+		ok(t, store.FindJoin(&east, bolthold.Where("Region").Eq("east").Select("Name"),
+			bolthold.Join(bolthold.Key, &JoinOrder{}, "CustomerID")))
+		equals(t, 2, len(east))
+		equals(t, "", east[0].Region)
+		equals(t, 2, len(east[0].Orders))
+		equals(t, 12, east[0].Orders[1].ID)
+		equals(t, 0, len(east[1].Orders))
+	})
+}
+
+// JoinCustomerOrders reads the JoinCustomer records, with a field for their orders
+type JoinCustomerOrders struct {
+	ID     int `boltholdKey:"ID"`
+	Name   string
+	Region string `boltholdIndex:"Region"`
+	Orders []JoinOrder
+}
+
+func (c *JoinCustomerOrders) Type() string { return "JoinCustomer" }
+
+func (c *JoinCustomerOrders) Indexes() map[string]bolthold.Index { return nil }
+
+func (c *JoinCustomerOrders) SliceIndexes() map[string]bolthold.SliceIndex { return nil }
+
+func TestFindJoinErrors(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertJoinData(t, store)
+
+		var result []OrderWithCustomer
+		err := store.FindJoin(&result, nil, bolthold.Join("DoesntExist", &JoinCustomer{}, bolthold.Key))
+		assert(t, err != nil, "No error joining on a field that doesn't exist")
+
+		err = store.FindJoin(&result, nil, bolthold.Join("CustomerID", &JoinItem{}, bolthold.Key))
+		assert(t, err != nil, "No error joining a type the result has no field for")
+
+		err = store.FindJoin(&result, nil, bolthold.Join("CustomerID", &JoinCustomer{}, bolthold.Key).Into("Status"))
+		assert(t, err != nil, "No error joining into a field of the wrong type")
+
+		var details []OrderDetail
+		err = store.FindJoin(&details, nil, bolthold.Join("CustomerID", &JoinCustomer{}, bolthold.Key))
+		assert(t, err != nil, "No error joining a type the result has more than one field for")
+	})
+}
@@ -0,0 +1,165 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/timshannon/bolthold"
+)
+
+type AttrItem struct {
+	ID     int               `boltholdKey:"ID"`
+	Attrs  map[string]string `boltholdMapIndex:"color, size"`
+	Extra  map[string]interface{}
+	Counts map[int]int
+	Nested map[string]map[string]int
+	Parts  map[string]*LineItem
+}
+
+func insertAttrData(t *testing.T, store *bolthold.Store) {
+	items := []AttrItem{
+		{ID: 1, Attrs: map[string]string{"color": "red", "size": "small"},
+			Extra: map[string]interface{}{"weight": 10, "tag": "sale"}, Counts: map[int]int{1: 5},
+			Nested: map[string]map[string]int{"stock": {"east": 3}}, Parts: map[string]*LineItem{"a": {SKU: "x1"}}},
+		{ID: 2, Attrs: map[string]string{"color": "blue", "size": "large"},
+			Extra: map[string]interface{}{"weight": "heavy"}, Counts: map[int]int{1: 2, 2: 7},
+			Nested: map[string]map[string]int{"stock": {"east": 0, "west": 4}}},
+		{ID: 3, Attrs: map[string]string{"color": "red"}, Extra: map[string]interface{}{"tag": "new"},
+			Parts: map[string]*LineItem{"a": {SKU: "y1"}, "b": {SKU: "x2"}}},
+		{ID: 4},
+	}
+
+	for i := range items {
+		ok(t, store.Insert(items[i].ID, &items[i]))
+	}
+}
+
+func TestMapCriteria(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertAttrData(t, store)
+
+		tests := []struct {
+			name  string
+			query *bolthold.Query
+			want  []int
+		}{
+			{"Map Entry", bolthold.Where("Attrs[color]").Eq("red"), []int{1, 3}},
+			{"Missing Entry is the Zero Value", bolthold.Where("Attrs[size]").Eq(""), []int{3, 4}},
+			{"Not Map Entry", bolthold.Where("Attrs[color]").Not().Eq("red"), []int{2, 4}},
+			{"Interface Entry", bolthold.Where("Extra[weight]").Gt(5), []int{1}},
+			{"Interface Entry Other Type", bolthold.Where("Extra[weight]").Eq("heavy"), []int{2}},
+			{"Missing Interface Entry", bolthold.Where("Extra[tag]").IsNil(), []int{2, 4}},
+			{"Int Map Key", bolthold.Where("Counts[1]").Ge(2), []int{1, 2}},
+			{"Nested Maps", bolthold.Where("Nested[stock][east]").Lt(3), []int{2, 3, 4}},
+			{"Map Entry Field", bolthold.Where("Parts[a].SKU").HasPrefix("y"), []int{3}},
+			{"HasValue", bolthold.Where("Attrs").HasValue("large"), []int{2}},
+			{"HasValue Interface", bolthold.Where("Extra").HasValue("sale"), []int{1}},
+			{"Not HasValue", bolthold.Where("Attrs").Not().HasValue("red"), []int{2, 4}},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				var result []AttrItem
+				ok(t, store.Find(&result, tst.query))
+
+				ids := []int{}
+				for i := range result {
+					ids = append(ids, result[i].ID)
+				}
+				// records read through an index are in index order
+				sort.Ints(ids)
+				equals(t, tst.want, ids)
+
+				ok(t, tst.query.Validate(&AttrItem{}))
+
+				parsed, err := bolthold.ParseQuery(tst.query.String())
+				ok(t, err)
+				equals(t, tst.query.String(), parsed.String())
+
+				data, err := json.Marshal(tst.query)
+				ok(t, err)
+				unmarshaled := &bolthold.Query{}
+				ok(t, json.Unmarshal(data, unmarshaled))
+				equals(t, tst.query.String(), unmarshaled.String())
+			})
+		}
+
+		invalid := []*bolthold.Query{
+			bolthold.Where("Attrs[color]").Eq(1),
+			bolthold.Where("Counts[one]").Eq(1),
+			bolthold.Where("ID[1]").Eq(1),
+			bolthold.Where("Attrs[color").Eq("red"),
+			bolthold.Where("Attrs[color]x").Eq("red"),
+			bolthold.Where("ID").HasValue(1),
+			bolthold.Where("Attrs").HasValue(1),
+		}
+		for _, query := range invalid {
+			assert(t, query.Validate(&AttrItem{}) != nil, "No error validating %s", query)
+		}
+
+		var result []AttrItem
+		err := store.Find(&result, bolthold.Where("ID[1]").Eq(1))
+		assert(t, err != nil, "No error reading a map entry of an int field")
+
+		ok(t, store.Find(&result, bolthold.Where("Attrs[size]").Ne("").SortBy("Attrs[size]")))
+		equals(t, 2, len(result))
+		equals(t, 2, result[0].ID)
+		equals(t, 1, result[1].ID)
+
+		parsed, err := bolthold.ParseQuery("`Attrs[color]` = \"red\" AND Extra HAS VALUE 'new'")
+		ok(t, err)
+		equals(t, bolthold.Where("Attrs[color]").Eq("red").And("Extra").HasValue("new").String(), parsed.String())
+	})
+}
+
+func TestMapIndex(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertAttrData(t, store)
+
+		query := bolthold.Where("Attrs[color]").Eq("red")
+		plan, err := store.Profile(&AttrItem{}, query)
+		ok(t, err)
+		equals(t, "Attrs[color]", plan.Index)
+		assert(t, plan.Selected, "Map entry index wasn't picked automatically")
+		assert(t, !plan.Scan, "Map entry query scanned every record")
+		equals(t, 2, plan.Scanned)
+		equals(t, 2, plan.Matched)
+
+		ok(t, store.UpdateMatching(&AttrItem{}, bolthold.Where("ID").Eq(3), func(record interface{}) error {
+			record.(*AttrItem).Attrs["color"] = "green"
+			return nil
+		}))
+
+		var result []AttrItem
+		ok(t, store.Find(&result, query))
+		equals(t, 1, len(result))
+		equals(t, 1, result[0].ID)
+
+		result = nil
+		ok(t, store.Find(&result, bolthold.Where("Attrs[size]").Eq("").Index("Attrs[size]")))
+		equals(t, 2, len(result))
+
+		assert(t, func() (panicked bool) {
+			type BadMapIndex struct {
+				Name string `boltholdMapIndex:"color"`
+			}
+			defer func() { panicked = recover() != nil }()
+			_ = store.Insert(1, &BadMapIndex{})
+			return false
+		}(), "No panic for a map index tag on a string field")
+
+		assert(t, func() (panicked bool) {
+			type BadMapKey struct {
+				Counts map[int]int `boltholdMapIndex:"one"`
+			}
+			defer func() { panicked = recover() != nil }()
+			_ = store.Insert(1, &BadMapKey{})
+			return false
+		}(), "No panic for a map index key that isn't an int")
+	})
+}
@@ -0,0 +1,191 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/timshannon/bolthold"
+)
+
+type Reading struct {
+	ID    int `boltholdKey:"ID"`
+	Value *int
+	Note  interface{}
+}
+
+func insertReadings(t *testing.T, store *bolthold.Store) {
+	five, two, nine := 5, 2, 9
+	readings := []Reading{
+		{ID: 1, Value: &five, Note: "b"},
+		{ID: 2},
+		{ID: 3, Value: &two, Note: "a"},
+		{ID: 4, Note: "c"},
+		{ID: 5, Value: &nine},
+	}
+
+	for i := range readings {
+		ok(t, store.Insert(readings[i].ID, &readings[i]))
+	}
+}
+
+func readingIDs(readings []Reading) []int {
+	ids := []int{}
+	for i := range readings {
+		ids = append(ids, readings[i].ID)
+	}
+	return ids
+}
+
+func TestSortNulls(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertReadings(t, store)
+
+		tests := []struct {
+			name  string
+			query *bolthold.Query
+			want  []int
+		}{
+			{"Default", (&bolthold.Query{}).SortBy("Value"), []int{3, 1, 5, 2, 4}},
+			{"Default Desc", (&bolthold.Query{}).SortByDesc("Value"), []int{2, 4, 5, 1, 3}},
+			{"Default Reverse", (&bolthold.Query{}).SortBy("Value").Reverse(), []int{4, 2, 5, 1, 3}},
+			{"Nulls First", (&bolthold.Query{}).SortBy("Value").NullsFirst(), []int{2, 4, 3, 1, 5}},
+			{"Nulls Last Desc", (&bolthold.Query{}).SortByDesc("Value").NullsLast(), []int{5, 1, 3, 2, 4}},
+			{"Nulls Last Reverse", (&bolthold.Query{}).SortBy("Value").NullsLast().Reverse(), []int{5, 1, 3, 4, 2}},
+			{"Interface Nulls First", (&bolthold.Query{}).SortBy("Note").NullsFirst(), []int{2, 5, 3, 1, 4}},
+			{"Second Field", (&bolthold.Query{}).SortBy("Note").NullsFirst().SortByDesc("Value").NullsLast(),
+				[]int{5, 2, 3, 1, 4}},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				var result []Reading
+				ok(t, store.Find(&result, tst.query))
+				equals(t, tst.want, readingIDs(result))
+
+				parsed, err := bolthold.ParseQuery(tst.query.String())
+				ok(t, err)
+				equals(t, tst.query.String(), parsed.String())
+
+				data, err := json.Marshal(tst.query)
+				ok(t, err)
+				unmarshaled := &bolthold.Query{}
+				ok(t, json.Unmarshal(data, unmarshaled))
+				equals(t, tst.query.String(), unmarshaled.String())
+			})
+		}
+
+		// pages are continued across the nil values
+		query := func() *bolthold.Query { return (&bolthold.Query{}).SortBy("Value").NullsFirst().Limit(2) }
+		var ids []int
+		page := &bolthold.Page{}
+		for {
+			var result []Reading
+			q := query()
+			if page.Next != "" {
+				q = q.After(page.Next)
+			}
+			var err error
+			page, err = store.FindPage(&result, q)
+			ok(t, err)
+			ids = append(ids, readingIDs(result)...)
+			if page.Next == "" {
+				break
+			}
+		}
+		equals(t, []int{2, 4, 3, 1, 5}, ids)
+
+		parsed, err := bolthold.ParseQuery("ORDER BY Value desc nulls last, Note NULLS FIRST")
+		ok(t, err)
+		equals(t, (&bolthold.Query{}).SortByDesc("Value").NullsLast().SortBy("Note").NullsFirst().String(),
+			parsed.String())
+
+		_, err = bolthold.ParseQuery("ORDER BY Value NULLS MIDDLE")
+		assert(t, err != nil, "No error parsing NULLS MIDDLE")
+
+		unmarshaled := &bolthold.Query{}
+		err = json.Unmarshal([]byte(`{"sort":["Value"],"sortNulls":["middle"]}`), unmarshaled)
+		assert(t, err != nil, "No error unmarshaling an invalid sortNulls value")
+
+		assert(t, func() (panicked bool) {
+			defer func() { panicked = recover() != nil }()
+			bolthold.Where("ID").Eq(1).NullsFirst()
+			return false
+		}(), "No panic calling NullsFirst on a query without a sort")
+	})
+}
+
+func TestNilCriteria(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertReadings(t, store)
+
+		tests := []struct {
+			name  string
+			query *bolthold.Query
+			want  []int
+		}{
+			{"Gt", bolthold.Where("Value").Gt(3), []int{1, 5}},
+			{"Lt", bolthold.Where("Value").Lt(3), []int{3}},
+			{"Ge", bolthold.Where("Value").Ge(5), []int{1, 5}},
+			{"Le", bolthold.Where("Value").Le(5), []int{1, 3}},
+			{"Eq", bolthold.Where("Value").Eq(5), []int{1}},
+			{"Ne", bolthold.Where("Value").Ne(5), []int{2, 3, 4, 5}},
+			{"Not Gt", bolthold.Where("Value").Not().Gt(3), []int{2, 3, 4}},
+			{"In", bolthold.Where("Value").In(2, 9), []int{3, 5}},
+			{"Interface Lt", bolthold.Where("Note").Lt("c"), []int{1, 3}},
+			{"IsNil", bolthold.Where("Value").IsNil(), []int{2, 4}},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				var result []Reading
+				ok(t, store.Find(&result, tst.query))
+
+				ids := readingIDs(result)
+				sort.Ints(ids)
+				equals(t, tst.want, ids)
+			})
+		}
+
+		var result []Reading
+		err := store.Find(&result, bolthold.Where("ID").Eq(nil))
+		assert(t, err != nil, "No error comparing with a nil criterion value")
+	})
+}
+
+func TestAggregateNulls(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertReadings(t, store)
+
+		aggs, err := store.FindAggregate(&Reading{}, nil)
+		ok(t, err)
+
+		var lowest, highest Reading
+		aggs[0].Min("Value", &lowest)
+		aggs[0].Max("Value", &highest)
+		equals(t, 3, lowest.ID)
+		equals(t, 5, highest.ID)
+
+		aggs[0].Min("Note", &lowest)
+		aggs[0].Max("Note", &highest)
+		equals(t, 3, lowest.ID)
+		equals(t, 4, highest.ID)
+
+		aggs[0].Sort("Value")
+		var sorted []Reading
+		aggs[0].Reduction(&sorted)
+		equals(t, []int{3, 1, 5}, readingIDs(sorted)[:3])
+
+		aggs, err = store.FindAggregate(&Reading{}, bolthold.Where("Value").IsNil())
+		ok(t, err)
+
+		var none Reading
+		aggs[0].Min("Value", &none)
+		aggs[0].Max("Value", &none)
+		equals(t, 0, none.ID)
+	})
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/timshannon/bolthold"
+)
+
+type Measurement struct {
+	ID    int `boltholdKey:"ID"`
+	Count int `boltholdIndex:"Count"`
+	Big   uint64
+	Ratio float32
+	Value interface{}
+}
+
+var measurements = []Measurement{
+	{ID: 1, Count: 3, Big: math.MaxUint64, Ratio: 0.5, Value: 7},
+	{ID: 2, Count: 10, Big: 5, Ratio: 1.25, Value: 2.5},
+	{ID: 3, Count: -2, Big: 1 << 63, Ratio: 3, Value: uint(1)},
+	{ID: 4, Count: 7, Big: 0, Ratio: -1, Value: int8(-3)},
+}
+
+func openPromoted(t *testing.T) (*bolthold.Store, func()) {
+	filename := tempfile()
+	store, err := bolthold.Open(filename, 0666, &bolthold.Options{NumericPromotion: true})
+	ok(t, err)
+
+	for i := range measurements {
+		ok(t, store.Insert(measurements[i].ID, &measurements[i]))
+	}
+
+	return store, func() {
+		store.Close()
+		os.Remove(filename)
+	}
+}
+
+func TestNumericPromotion(t *testing.T) {
+	store, cleanup := openPromoted(t)
+	defer cleanup()
+
+	tests := []struct {
+		name  string
+		query *bolthold.Query
+		want  []int
+	}{
+		{"Float on Int Index", bolthold.Where("Count").Eq(10.0), []int{2}},
+		{"Int64 on Int", bolthold.Where("Count").Gt(int64(3)), []int{2, 4}},
+		{"Uint on Negative Int", bolthold.Where("Count").Lt(uint(0)), []int{3}},
+		{"Big Int", bolthold.Where("Count").Ge(big.NewInt(7)), []int{2, 4}},
+		{"Big Rat", bolthold.Where("Count").Gt(big.NewRat(13, 2)), []int{2, 4}},
+		{"Big Float", bolthold.Where("Ratio").Lt(big.NewFloat(0.75)), []int{1, 4}},
+		{"In", bolthold.Where("Count").In(3.0, 7.5), []int{1}},
+		{"Uint64 past Int64", bolthold.Where("Big").Gt(int64(math.MaxInt64)), []int{1, 3}},
+		{"Uint64 and Negative", bolthold.Where("Big").Gt(-1), []int{1, 2, 3, 4}},
+		{"Float32 and Float64", bolthold.Where("Ratio").Eq(1.25), []int{2}},
+		{"Float32 and Int", bolthold.Where("Ratio").Eq(3), []int{3}},
+		{"Mixed Interface Values", bolthold.Where("Value").Gt(2), []int{1, 2}},
+		{"Infinity", bolthold.Where("Count").Gt(math.Inf(-1)), []int{1, 2, 3, 4}},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			var result []Measurement
+			ok(t, store.Find(&result, tst.query))
+
+			ids := []int{}
+			for i := range result {
+				ids = append(ids, result[i].ID)
+			}
+			// records read through an index are in index order
+			sort.Ints(ids)
+			equals(t, tst.want, ids)
+		})
+	}
+
+	var result []Measurement
+	err := store.Find(&result, bolthold.Where("Count").Lt(math.NaN()))
+	assert(t, err != nil, "No error comparing an int with NaN")
+
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		ok(t, store.Insert(measurements[1].ID, &measurements[1]))
+
+		err := store.Find(&result, bolthold.Where("Count").Eq(10.0))
+		assert(t, err != nil, "No error comparing an int with a float64 without NumericPromotion")
+	})
+}
+
+func TestNumericPromotionSort(t *testing.T) {
+	store, cleanup := openPromoted(t)
+	defer cleanup()
+
+	var result []Measurement
+	ok(t, store.Find(&result, (&bolthold.Query{}).SortBy("Value")))
+
+	ids := []int{}
+	for i := range result {
+		ids = append(ids, result[i].ID)
+	}
+	equals(t, []int{4, 3, 2, 1}, ids)
+
+	aggs, err := store.FindAggregate(&Measurement{}, nil)
+	ok(t, err)
+
+	var highest, lowest Measurement
+	aggs[0].Max("Value", &highest)
+	aggs[0].Min("Value", &lowest)
+	equals(t, 1, highest.ID)
+	equals(t, 4, lowest.ID)
+}
@@ -0,0 +1,398 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrInvalidPageToken is the error returned when a PageToken passed to After or Before can't be read, or doesn't
+// belong to the query it's used with
+var ErrInvalidPageToken = errors.New("Invalid page token")
+
+// PageToken is an opaque position in the results of a query.  Pass it to Query.After or Query.Before to continue
+// from that position
+type PageToken string
+
+// Page holds the positions around a page of results returned by FindPage
+type Page struct {
+	// Next is the position after the last record of the page, and is empty if there are no more records
+	Next PageToken
+	// Previous is the position before the first record of the page, and is empty on the first page
+	Previous PageToken
+}
+
+// pageToken is the decoded contents of a PageToken
+type pageToken struct {
+	Key      []byte
+	Index    string
+	Indexed  bool
+	IndexKey []byte
+	Sort     [][]byte
+}
+
+// pagePosition is the position of a record in the order a query returns its records: by the query's sort fields,
+// then the index value of the records when read through an index, then the record key
+type pagePosition struct {
+	key      []byte
+	indexKey []byte
+	values   []interface{}
+}
+
+// FindPage is the same as Find, but also returns the tokens to get the next and previous pages of results.  The
+// size of the page is set with Query.Limit.
+func (s *Store) FindPage(result interface{}, query *Query) (*Page, error) {
+	var page *Page
+	err := s.Bolt().View(func(tx *bolt.Tx) error {
+		var txErr error
+		page, txErr = s.TxFindPage(tx, result, query)
+		return txErr
+	})
+	return page, err
+}
+
+// TxFindPage is the same as FindPage but you get to specify your transaction
+func (s *Store) TxFindPage(tx *bolt.Tx, result interface{}, query *Query) (*Page, error) {
+	page := &Page{}
+	return page, s.find(tx, result, query, page)
+}
+
+// FindPageInBucket is the same as FindPage but you get to specify your parent bucket
+func (s *Store) FindPageInBucket(parent *bolt.Bucket, result interface{}, query *Query) (*Page, error) {
+	page := &Page{}
+	return page, s.find(parent, result, query, page)
+}
+
+// decodePageToken returns the contents of the query's PageToken, or nil if After or Before wasn't passed a PageToken
+func (s *Store) decodePageToken(query *Query) (*pageToken, error) {
+	token, ok := query.page.(PageToken)
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(string(token))
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	tkn := &pageToken{}
+	err = s.decode(data, tkn)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	if len(tkn.Sort) != len(query.sort) {
+		return nil, ErrInvalidPageToken
+	}
+
+	return tkn, nil
+}
+
+// queryIndex returns the index the query reads its records through.  Pages of results read through an index are
+// always read through the same index, so the order of the records doesn't change between pages.
+func (s *Store) queryIndex(source BucketSource, storer Storer, query *Query) (string, error) {
+	if query.page != nil {
+		token, err := s.decodePageToken(query)
+		if err != nil {
+			return "", err
+		}
+		if token != nil && token.Indexed {
+			return token.Index, nil
+		}
+	}
+
+	index := query.index
+	if !query.indexSet {
+		index = s.selectIndex(source, storer, query)
+	}
+
+	if index != Key {
+		// an index that can't be used for the query's criteria reads every record in key order
+		fields := indexFields(storer, index)
+		if source.Bucket(indexBucketName(storer.Type(), index)) == nil ||
+			(len(fields) == 1 && !canUseIndex(query.fieldCriteria[fields[0]])) {
+			index = Key
+		}
+	}
+
+	if sortIndex := s.sortIndex(source, storer, query, index); sortIndex != "" {
+		return sortIndex, nil
+	}
+
+	return index, nil
+}
+
+// indexOrdered returns whether the query returns its records in the order of the index they're read through
+func indexOrdered(query *Query, index string) bool {
+	return index != Key && len(query.sort) == 0 && len(query.ors) == 0
+}
+
+// pagePosition returns the position the query's After or Before refers to
+func (s *Store) pagePosition(source BucketSource, storer Storer, query *Query, index string) (*pagePosition, error) {
+	token, err := s.decodePageToken(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if token != nil {
+		pos := &pagePosition{
+			key:      token.Key,
+			indexKey: token.IndexKey,
+		}
+
+		for i := range token.Sort {
+			if token.Sort[i] == nil {
+				pos.values = append(pos.values, nil)
+				continue
+			}
+
+			tp, err := sortFieldType(query.dataType, query.sort[i])
+			if err != nil {
+				return nil, err
+			}
+
+			value := reflect.New(tp)
+			err = s.decode(token.Sort[i], value.Interface())
+			if err != nil {
+				return nil, ErrInvalidPageToken
+			}
+			pos.values = append(pos.values, value.Elem().Interface())
+		}
+
+		return pos, nil
+	}
+
+	key, err := s.encode(query.page)
+	if err != nil {
+		return nil, err
+	}
+
+	bkt := source.Bucket([]byte(storer.Type()))
+	if bkt == nil {
+		return nil, ErrNotFound
+	}
+
+	data := bkt.Get(key)
+	if data == nil {
+		return nil, ErrNotFound
+	}
+
+	value := reflect.New(query.dataType)
+	err = s.decode(data, value.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	return s.recordPosition(storer, query, index, &record{key: key, value: value})
+}
+
+// recordPosition returns the position of the record in the results of the query
+func (s *Store) recordPosition(storer Storer, query *Query, index string, r *record) (*pagePosition, error) {
+	pos := &pagePosition{
+		key: r.key,
+	}
+
+	for _, field := range query.sort {
+		if field == Key {
+			// records are compared by their key directly
+			pos.values = append(pos.values, nil)
+			continue
+		}
+
+		value, err := fieldValue(r.value.Elem(), field)
+		if err != nil {
+			return nil, err
+		}
+		if isNilValue(value) {
+			// nil pointers and interfaces are all the same nil value, and are stored in page tokens as nil
+			value = nil
+		}
+		pos.values = append(pos.values, value)
+	}
+
+	if !indexOrdered(query, index) {
+		return pos, nil
+	}
+
+	indexKeys, _, err := indexKeys(storer, index, r.value.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	// a record in a slice index is returned for each of its values, the first one is used as its position
+	for i := range indexKeys {
+		if indexKeys[i] == nil {
+			continue
+		}
+		if pos.indexKey == nil || bytes.Compare(indexKeys[i], pos.indexKey) < 0 {
+			pos.indexKey = indexKeys[i]
+		}
+	}
+
+	return pos, nil
+}
+
+// pageToken returns the token for the position of the record in the results of the query
+func (s *Store) pageToken(storer Storer, query *Query, index string, r *record) (PageToken, error) {
+	pos, err := s.recordPosition(storer, query, index, r)
+	if err != nil {
+		return "", err
+	}
+
+	token := &pageToken{
+		Key:      pos.key,
+		Index:    index,
+		Indexed:  indexOrdered(query, index),
+		IndexKey: pos.indexKey,
+	}
+
+	for i := range pos.values {
+		if pos.values[i] == nil {
+			token.Sort = append(token.Sort, nil)
+			continue
+		}
+
+		value, err := s.encode(pos.values[i])
+		if err != nil {
+			return "", err
+		}
+		token.Sort = append(token.Sort, value)
+	}
+
+	data, err := s.encode(token)
+	if err != nil {
+		return "", err
+	}
+
+	return PageToken(base64.RawURLEncoding.EncodeToString(data)), nil
+}
+
+// comparePositions compares the positions of two records in the results of the query
+func (s *Store) comparePositions(query *Query, a, b *pagePosition) int {
+	cmp := 0
+	for i := 0; i < len(a.values) && i < len(b.values); i++ {
+		nulls := query.sortNulls(i)
+		aNil, bNil := isNilValue(a.values[i]), isNilValue(b.values[i])
+
+		switch {
+		case i < len(query.sort) && query.sort[i] == Key:
+			cmp = bytes.Compare(a.key, b.key)
+		case (aNil || bNil) && nulls != nullsDefault:
+			// nils stay first or last whichever way the field is sorted, so they're returned before Reverse is applied
+			cmp = compareNils(aNil, bNil)
+			if nulls == nullsFirst {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp
+			}
+			continue
+		case aNil || bNil:
+			cmp = compareNils(aNil, bNil)
+		default:
+			cmp = s.compareSortValues(a.values[i], b.values[i])
+		}
+		if i < len(query.sortDesc) && query.sortDesc[i] {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			break
+		}
+	}
+
+	if cmp == 0 {
+		cmp = bytes.Compare(a.indexKey, b.indexKey)
+	}
+
+	if cmp == 0 {
+		cmp = bytes.Compare(a.key, b.key)
+	}
+
+	if query.reverse && len(query.sort) > 0 {
+		return -cmp
+	}
+	return cmp
+}
+
+// compareNils compares two values of a sort field when either one is nil, nil values are greater than any other
+func compareNils(aNil, bNil bool) int {
+	switch {
+	case aNil == bNil:
+		return 0
+	case aNil:
+		return 1
+	}
+	return -1
+}
+
+// compareSortValues compares two values of a sort field.  If for some reason they can't be compared, it falls back to
+// a lexicographic compare
+func (s *Store) compareSortValues(value, other interface{}) int {
+	cmp, err := s.compare(value, other)
+	if err != nil {
+		valS := fmt.Sprintf("%s", value)
+		otherS := fmt.Sprintf("%s", other)
+		if valS < otherS {
+			return -1
+		} else if valS > otherS {
+			return 1
+		}
+		return 0
+	}
+
+	return cmp
+}
+
+// sortFieldType returns the type of the sort field in the data type
+func sortFieldType(dataType reflect.Type, field string) (reflect.Type, error) {
+	current := dataType
+	remainder := field
+	for {
+		name, keys, rest, err := splitField(remainder)
+		if err != nil {
+			return nil, err
+		}
+
+		for current.Kind() == reflect.Ptr {
+			current = current.Elem()
+		}
+
+		if current.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("The field %s does not exist in the type %s", field, dataType)
+		}
+
+		structField, found := current.FieldByName(name)
+		if !found {
+			return nil, fmt.Errorf("The field %s does not exist in the type %s", field, dataType)
+		}
+		current = structField.Type
+
+		for _, key := range keys {
+			for current.Kind() == reflect.Ptr {
+				current = current.Elem()
+			}
+			if current.Kind() != reflect.Map {
+				return nil, fmt.Errorf("The map key [%s] in the field %s can't be used on a %s, which is not a map",
+					key, field, current)
+			}
+			_, err = mapKey(current.Key(), key)
+			if err != nil {
+				return nil, err
+			}
+			current = current.Elem()
+		}
+
+		if rest == "" {
+			return current, nil
+		}
+		remainder = rest
+	}
+}
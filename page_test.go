@@ -0,0 +1,211 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/timshannon/bolthold"
+)
+
+type PageItem struct {
+	ID    int    `boltholdKey:"ID"`
+	Group string `boltholdIndex:"Group"`
+	Score int
+}
+
+func insertPageData(t *testing.T, store *bolthold.Store) {
+	for i := 0; i < 95; i++ {
+		ok(t, store.Insert(i, &PageItem{
+			Group: fmt.Sprintf("group%d", i%7),
+			Score: i % 10,
+		}))
+	}
+}
+
+func pageIDs(items []PageItem) []int {
+	ids := make([]int, len(items))
+	for i := range items {
+		ids[i] = items[i].ID
+	}
+	return ids
+}
+
+// findPages pages forward through the results of the query, then back again, and checks that both match the
+// unpaged results.  Unordered only checks that the pages hold the same records as the unpaged results.
+func findPages(t *testing.T, store *bolthold.Store, query func() *bolthold.Query, unordered bool) {
+	var all []PageItem
+	ok(t, store.Find(&all, query()))
+
+	var pages [][]PageItem
+	var previous []bolthold.PageToken
+	var token bolthold.PageToken
+	for {
+		q := query().Limit(10)
+		if token != "" {
+			q = q.After(token)
+		}
+
+		var result []PageItem
+		page, err := store.FindPage(&result, q)
+		ok(t, err)
+
+		assert(t, len(result) <= 10, "Page has %d records", len(result))
+		assert(t, (token == "") == (page.Previous == ""), "Previous is %q on page %d", page.Previous, len(pages))
+		pages = append(pages, result)
+		previous = append(previous, page.Previous)
+
+		if page.Next == "" {
+			break
+		}
+		token = page.Next
+	}
+
+	var forward []PageItem
+	for i := range pages {
+		forward = append(forward, pages[i]...)
+	}
+
+	expected, got := pageIDs(all), pageIDs(forward)
+	if unordered {
+		sort.Ints(expected)
+		sort.Ints(got)
+	}
+	equals(t, expected, got)
+
+	// page back from the last page
+	token = previous[len(pages)-1]
+	for i := len(pages) - 1; i > 0; i-- {
+		var result []PageItem
+		page, err := store.FindPage(&result, query().Limit(10).Before(token))
+		ok(t, err)
+		equals(t, pageIDs(pages[i-1]), pageIDs(result))
+		assert(t, page.Next != "", "Next is empty before page %d", i)
+		assert(t, (i == 1) == (page.Previous == ""), "Previous is %q before page %d", page.Previous, i)
+		token = page.Previous
+	}
+}
+
+func TestFindPage(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPageData(t, store)
+
+		t.Run("Key", func(t *testing.T) {
+			findPages(t, store, func() *bolthold.Query { return &bolthold.Query{} }, false)
+		})
+
+		t.Run("Key Criteria", func(t *testing.T) {
+			findPages(t, store, func() *bolthold.Query { return bolthold.Where("Score").Lt(5) }, false)
+		})
+
+		t.Run("Index", func(t *testing.T) {
+			findPages(t, store, func() *bolthold.Query { return bolthold.Where("Group").Ge("group2") }, false)
+		})
+
+		t.Run("Whole Index", func(t *testing.T) {
+			findPages(t, store, func() *bolthold.Query { return (&bolthold.Query{}).Index("Group") }, false)
+		})
+
+		t.Run("Sorted", func(t *testing.T) {
+			findPages(t, store, func() *bolthold.Query { return bolthold.Where("Score").Ge(2).SortBy("Score") }, false)
+		})
+
+		t.Run("Sorted Reverse", func(t *testing.T) {
+			findPages(t, store, func() *bolthold.Query {
+				return bolthold.Where("Group").Eq("group1").SortBy("Score").Reverse()
+			}, false)
+		})
+
+		t.Run("Or", func(t *testing.T) {
+			// pages of queries with Or'd queries are ordered by key, rather than the order Find returns them in
+			findPages(t, store, func() *bolthold.Query {
+				return bolthold.Where("Group").Eq("group1").Or(bolthold.Where("Score").Eq(3))
+			}, true)
+		})
+	})
+}
+
+func TestPageStable(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPageData(t, store)
+
+		var first []PageItem
+		page, err := store.FindPage(&first, bolthold.Where("Group").Eq("group3").Limit(5))
+		ok(t, err)
+
+		var expected []PageItem
+		ok(t, store.Find(&expected, bolthold.Where("Group").Eq("group3").Skip(5).Limit(5)))
+
+		// removing records from the first page doesn't shift the records on the next page
+		for i := range first {
+			ok(t, store.Delete(first[i].ID, &PageItem{}))
+		}
+
+		var result []PageItem
+		_, err = store.FindPage(&result, bolthold.Where("Group").Eq("group3").Limit(5).After(page.Next))
+		ok(t, err)
+		equals(t, pageIDs(expected), pageIDs(result))
+	})
+}
+
+func TestAfterKey(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPageData(t, store)
+
+		var all []PageItem
+		ok(t, store.Find(&all, bolthold.Where("Group").Eq("group4")))
+
+		var result []PageItem
+		ok(t, store.Find(&result, bolthold.Where("Group").Eq("group4").After(all[3].ID)))
+		equals(t, pageIDs(all[4:]), pageIDs(result))
+
+		result = nil
+		ok(t, store.Find(&result, bolthold.Where("Group").Eq("group4").Before(all[3].ID).Limit(2)))
+		equals(t, pageIDs(all[1:3]), pageIDs(result))
+
+		result = nil
+		ok(t, store.Find(&result, bolthold.Where("Group").Eq("group4").Before(all[3].ID).Skip(1)))
+		equals(t, pageIDs(all[:2]), pageIDs(result))
+
+		var ids []int
+		ok(t, store.ForEach(bolthold.Where("Group").Eq("group4").After(all[5].ID), func(record *PageItem) error {
+			ids = append(ids, record.ID)
+			return nil
+		}))
+		equals(t, pageIDs(all[6:]), ids)
+
+		count, err := store.Count(&PageItem{}, bolthold.Where("Group").Eq("group4").After(all[5].ID))
+		ok(t, err)
+		equals(t, len(all)-6, count)
+	})
+}
+
+func TestPageErrors(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPageData(t, store)
+
+		var result []PageItem
+		err := store.Find(&result, bolthold.Where("Score").Eq(1).After(1000))
+		equals(t, bolthold.ErrNotFound, err)
+
+		err = store.Find(&result, bolthold.Where("Score").Eq(1).After(bolthold.PageToken("not a token")))
+		equals(t, bolthold.ErrInvalidPageToken, err)
+
+		page, err := store.FindPage(&result, bolthold.Where("Score").Eq(1).Limit(2))
+		ok(t, err)
+
+		// a token from an unsorted query can't be used on a sorted query
+		err = store.Find(&result, bolthold.Where("Score").Eq(1).SortBy("Group").After(page.Next))
+		equals(t, bolthold.ErrInvalidPageToken, err)
+
+		defer func() {
+			assert(t, recover() != nil, "After set twice didn't panic")
+		}()
+
+		bolthold.Where("Score").Eq(1).After(1).After(2)
+	})
+}
@@ -0,0 +1,1262 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParseError is the error returned by ParseQuery when the query text can't be parsed
+type ParseError struct {
+	// Offset is the byte offset of the problem in the query text, and Line and Column are its position starting
+	// from 1
+	Offset int
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Error parsing query at line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// ParseQuery parses a query written as text into a Query.  Query.String returns the text of a query, which parses
+// back into the same query.
+/*
+Query Text Example
+
+	query, err := bolthold.ParseQuery(`Age >= 21 AND Name =~ /^J/ OR Tags CONTAINS "x" ORDER BY Age DESC LIMIT 10`)
+
+	// the same as
+	query := bolthold.Where("Age").Ge(21).And("Name").RegExp(regexp.MustCompile("^J")).
+		Or(bolthold.Where("Tags").Contains("x")).SortBy("Age").Reverse().Limit(10)
+
+A query is made up of criteria joined with AND, and any number of Or'd queries, each starting with OR.  Criteria
+are a field, followed by an operator and a value:
+
+	Name = "John"           Name == "John"          Name != "John"
+	Age > 21                Age >= 21               Age < 21                Age <= 21
+	Name =~ /^J/            Name !~ /^J/            Name IN ("John", "Jane")
+	Parent IS NIL           Parent IS NOT NIL       MapVal HAS KEY "color"
+	Tags CONTAINS "x"       Tags CONTAINS ANY ("x", "y")                    Tags CONTAINS ALL ("x", "y")
+	Name HAS PREFIX "Jo"    Name HAS SUFFIX "hn"    Name CONTAINS STRING "oh"
+	Name EQFOLD "john"      LEN(Tags) > 2           Items ELEMMATCH (SKU = "x" AND Qty > 5)
+	MapVal HAS VALUE "red"  `MapVal[color]` = "red"
+
+Any criterion can be negated by starting it with NOT.  Criteria can be grouped in parentheses, with their own OR,
+such as Age >= 21 AND (Name = "John" OR Name = "Jane"), and a whole group can be negated with NOT (...).
+
+Values can be strings in single or double quotes, numbers, true, false, nil, a time as
+TIME("2006-01-02T15:04:05Z") or another field of the record as FIELD(Name).  Numbers without a decimal point or
+exponent are ints, and the rest are float64s, but either is compared with int, uint and float fields of any size
+by its exact value, as with the NumericPromotion option, so Age >= 21 works whether Age is an int, an int64 or a
+uint8.  KEY is the record's key, and field names that are
+the same as a keyword, or aren't made up of letters, digits and underscores, can be quoted in backticks, as can
+fields that read a map entry, such as `Attrs[color]`.
+
+After the criteria, each query, including the Or'd ones, can name the index it uses with USING INDEX Name.  The
+whole query can then be sorted with ORDER BY Field, OtherField DESC, KEY, and SKIP and LIMIT the records returned.
+A sort field can be followed by NULLS FIRST or NULLS LAST to set where records with a nil value for it are sorted.
+A query can start with SELECT Field, OtherField WHERE to select fields.  Keywords are case insensitive.
+*/
+func ParseQuery(text string) (*Query, error) {
+	p := &parser{
+		text: text,
+	}
+
+	err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parseQuery()
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenQuotedIdent
+	tokenString
+	tokenInt
+	tokenFloat
+	tokenRegexp
+	tokenOperator
+	tokenPunct
+)
+
+type token struct {
+	kind   tokenKind
+	text   string // the value of strings and quoted identifiers, and the source text of everything else
+	offset int
+}
+
+// keywords are reserved, and fields with the same name must be quoted
+var keywords = map[string]bool{
+	"SELECT": true, "WHERE": true, "AND": true, "OR": true, "NOT": true, "IN": true, "IS": true, "NIL": true,
+	"NULL": true, "CONTAINS": true, "ANY": true, "ALL": true, "HAS": true, "KEY": true, "USING": true,
+	"INDEX": true, "ORDER": true, "BY": true, "ASC": true, "DESC": true, "SKIP": true, "LIMIT": true,
+	"TRUE": true, "FALSE": true, "TIME": true, "FIELD": true, "MATCHFUNC": true, "LEN": true, "ELEMMATCH": true,
+}
+
+type parser struct {
+	text   string
+	offset int
+	tok    token
+}
+
+func (p *parser) errorAt(offset int, format string, args ...interface{}) error {
+	line := 1 + strings.Count(p.text[:offset], "\n")
+	column := offset + 1
+	if i := strings.LastIndex(p.text[:offset], "\n"); i >= 0 {
+		column = offset - i
+	}
+
+	return &ParseError{
+		Offset: offset,
+		Line:   line,
+		Column: column,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *parser) unexpected(expected string) error {
+	if p.tok.kind == tokenEOF {
+		return p.errorAt(p.tok.offset, "expected %s, found the end of the query", expected)
+	}
+	return p.errorAt(p.tok.offset, "expected %s, found %s", expected, p.text[p.tok.offset:p.end()])
+}
+
+// end returns the offset of the end of the current token
+func (p *parser) end() int {
+	return p.offset
+}
+
+// isKeyword returns whether the current token is the keyword
+func (p *parser) isKeyword(keyword string) bool {
+	return p.tok.kind == tokenIdent && strings.EqualFold(p.tok.text, keyword)
+}
+
+func (p *parser) isPunct(punct string) bool {
+	return p.tok.kind == tokenPunct && p.tok.text == punct
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if !p.isKeyword(keyword) {
+		return p.unexpected(keyword)
+	}
+	return p.next()
+}
+
+func (p *parser) expectPunct(punct string) error {
+	if !p.isPunct(punct) {
+		return p.unexpected(strconv.Quote(punct))
+	}
+	return p.next()
+}
+
+// next reads the next token from the text
+func (p *parser) next() error {
+	for p.offset < len(p.text) {
+		r, size := utf8.DecodeRuneInString(p.text[p.offset:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		p.offset += size
+	}
+
+	start := p.offset
+	p.tok = token{offset: start}
+
+	if start >= len(p.text) {
+		p.tok.kind = tokenEOF
+		return nil
+	}
+
+	r, size := utf8.DecodeRuneInString(p.text[start:])
+
+	switch {
+	case r == '_' || unicode.IsLetter(r):
+		end := start + size
+		for end < len(p.text) {
+			r, size := utf8.DecodeRuneInString(p.text[end:])
+			if r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+			end += size
+		}
+		p.tok.kind = tokenIdent
+		p.tok.text = p.text[start:end]
+		p.offset = end
+	case r == '`':
+		end := strings.IndexByte(p.text[start+1:], '`')
+		if end < 0 {
+			return p.errorAt(start, "unterminated quoted field")
+		}
+		p.tok.kind = tokenQuotedIdent
+		p.tok.text = p.text[start+1 : start+1+end]
+		p.offset = start + end + 2
+	case r == '"' || r == '\'':
+		value, end, err := p.readString(start, byte(r))
+		if err != nil {
+			return err
+		}
+		p.tok.kind = tokenString
+		p.tok.text = value
+		p.offset = end
+	case r == '/':
+		value, end, err := p.readRegexp(start)
+		if err != nil {
+			return err
+		}
+		p.tok.kind = tokenRegexp
+		p.tok.text = value
+		p.offset = end
+	case r == '-' || unicode.IsDigit(r):
+		return p.readNumber(start)
+	case strings.ContainsRune("=!<>", r):
+		end := start + 1
+		if end < len(p.text) && (p.text[end] == '=' || p.text[end] == '~') {
+			end++
+		}
+		p.tok.kind = tokenOperator
+		p.tok.text = p.text[start:end]
+		p.offset = end
+		if p.tok.text == "!" || p.tok.text == "<~" || p.tok.text == ">~" {
+			return p.errorAt(start, "unknown operator %s", p.tok.text)
+		}
+	case strings.ContainsRune("(),", r):
+		p.tok.kind = tokenPunct
+		p.tok.text = string(r)
+		p.offset = start + 1
+	default:
+		return p.errorAt(start, "unexpected character %q", r)
+	}
+
+	return nil
+}
+
+func (p *parser) readString(start int, quote byte) (string, int, error) {
+	var b strings.Builder
+	for i := start + 1; i < len(p.text); i++ {
+		c := p.text[i]
+		switch c {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\\':
+			if quote == '"' {
+				// double quoted strings use Go's escapes
+				end := i
+				for end < len(p.text) && p.text[end] != '"' {
+					if p.text[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(p.text) {
+					return "", 0, p.errorAt(start, "unterminated string")
+				}
+				value, err := strconv.Unquote(p.text[start : end+1])
+				if err != nil {
+					return "", 0, p.errorAt(start, "invalid string: %s", err)
+				}
+				return value, end + 1, nil
+			}
+
+			i++
+			if i >= len(p.text) {
+				return "", 0, p.errorAt(start, "unterminated string")
+			}
+			switch p.text[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(p.text[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, p.errorAt(start, "unterminated string")
+}
+
+func (p *parser) readRegexp(start int) (string, int, error) {
+	var b strings.Builder
+	for i := start + 1; i < len(p.text); i++ {
+		c := p.text[i]
+		switch c {
+		case '/':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 < len(p.text) && p.text[i+1] == '/' {
+				b.WriteByte('/')
+				i++
+				continue
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, p.errorAt(start, "unterminated regular expression")
+}
+
+func (p *parser) readNumber(start int) error {
+	end := start
+	if p.text[end] == '-' {
+		end++
+	}
+
+	isFloat := false
+	for end < len(p.text) {
+		c := p.text[end]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.':
+			isFloat = true
+		case c == 'e' || c == 'E':
+			isFloat = true
+			if end+1 < len(p.text) && (p.text[end+1] == '-' || p.text[end+1] == '+') {
+				end++
+			}
+		default:
+			goto done
+		}
+		end++
+	}
+done:
+	p.tok.text = p.text[start:end]
+	p.offset = end
+
+	if p.tok.text == "-" {
+		return p.errorAt(start, "invalid number")
+	}
+
+	p.tok.kind = tokenInt
+	if isFloat {
+		p.tok.kind = tokenFloat
+	}
+	return nil
+}
+
+func (p *parser) parseQuery() (*Query, error) {
+	query := &Query{}
+
+	if p.isKeyword("SELECT") {
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		for {
+			field, err := p.parseField(false)
+			if err != nil {
+				return nil, err
+			}
+			query.Select(field)
+
+			if !p.isPunct(",") {
+				break
+			}
+			err = p.next()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if p.isKeyword("WHERE") {
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !p.isKeyword("ORDER") && !p.isKeyword("SKIP") && !p.isKeyword("LIMIT") && p.tok.kind != tokenEOF {
+		err := p.parseBranch(query)
+		if err != nil {
+			return nil, err
+		}
+
+		for p.isKeyword("OR") {
+			err = p.next()
+			if err != nil {
+				return nil, err
+			}
+
+			or := &Query{}
+			err = p.parseBranch(or)
+			if err != nil {
+				return nil, err
+			}
+			query.Or(or)
+		}
+	}
+
+	if p.isKeyword("ORDER") {
+		err := p.parseOrder(query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.isKeyword("SKIP") {
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		amount, err := p.parseCount("SKIP")
+		if err != nil {
+			return nil, err
+		}
+		query.Skip(amount)
+	}
+
+	if p.isKeyword("LIMIT") {
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		amount, err := p.parseCount("LIMIT")
+		if err != nil {
+			return nil, err
+		}
+		query.Limit(amount)
+	}
+
+	if p.tok.kind != tokenEOF {
+		return nil, p.unexpected("the end of the query")
+	}
+
+	return query, nil
+}
+
+// parseBranch parses the criteria of a query, joined by AND, and its index
+func (p *parser) parseBranch(query *Query) error {
+	if !p.isKeyword("USING") {
+		err := p.parseCriteria(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.isKeyword("USING") {
+		err := p.next()
+		if err != nil {
+			return err
+		}
+		err = p.expectKeyword("INDEX")
+		if err != nil {
+			return err
+		}
+
+		var index string
+		switch {
+		case p.isKeyword("KEY"):
+			index = Key
+		case p.tok.kind == tokenIdent && !keywords[strings.ToUpper(p.tok.text)], p.tok.kind == tokenQuotedIdent:
+			index = p.tok.text
+		default:
+			return p.unexpected("an index name")
+		}
+		query.Index(index)
+
+		return p.next()
+	}
+
+	return nil
+}
+
+// parseCriteria parses criteria joined by AND
+func (p *parser) parseCriteria(query *Query) error {
+	for {
+		err := p.parseCriterion(query)
+		if err != nil {
+			return err
+		}
+
+		if !p.isKeyword("AND") {
+			return nil
+		}
+		err = p.next()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseGroup parses the criteria and Or'd criteria of a group in parentheses
+func (p *parser) parseGroup(query *Query, negate bool) error {
+	group, err := p.parseSubQuery()
+	if err != nil {
+		return err
+	}
+
+	query.groups = append(query.groups, queryGroup{query: group, negate: negate})
+	return nil
+}
+
+// parseSubQuery parses criteria and Or'd criteria in parentheses into their own query
+func (p *parser) parseSubQuery() (*Query, error) {
+	err := p.expectPunct("(")
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &Query{}
+
+	if !p.isPunct(")") {
+		err = p.parseCriteria(sub)
+		if err != nil {
+			return nil, err
+		}
+
+		for p.isKeyword("OR") {
+			err = p.next()
+			if err != nil {
+				return nil, err
+			}
+
+			or := &Query{}
+			err = p.parseCriteria(or)
+			if err != nil {
+				return nil, err
+			}
+			sub.Or(or)
+		}
+	}
+
+	return sub, p.expectPunct(")")
+}
+
+// parseField parses a field name.  If key is true, KEY is accepted for the record's key
+func (p *parser) parseField(key bool) (string, error) {
+	offset := p.tok.offset
+	var field string
+
+	switch {
+	case key && p.isKeyword("KEY"):
+		return Key, p.next()
+	case p.tok.kind == tokenIdent && !keywords[strings.ToUpper(p.tok.text)], p.tok.kind == tokenQuotedIdent:
+		field = p.tok.text
+	default:
+		return "", p.unexpected("a field")
+	}
+
+	if field == "" || !startsUpper(field) {
+		return "", p.errorAt(offset, "the first letter of the field %s must be upper-case", field)
+	}
+
+	return field, p.next()
+}
+
+func (p *parser) parseCriterion(query *Query) error {
+	negate := false
+	for p.isKeyword("NOT") {
+		negate = !negate
+		err := p.next()
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.isPunct("(") {
+		return p.parseGroup(query, negate)
+	}
+
+	length := p.isKeyword("LEN")
+	if length {
+		err := p.next()
+		if err != nil {
+			return err
+		}
+		err = p.expectPunct("(")
+		if err != nil {
+			return err
+		}
+	}
+
+	field, err := p.parseField(true)
+	if err != nil {
+		return err
+	}
+
+	c := query.And(field)
+	c.literal = true
+	if negate {
+		c.Not()
+	}
+
+	if length {
+		c.Len()
+		err = p.expectPunct(")")
+		if err != nil {
+			return err
+		}
+	}
+
+	offset := p.tok.offset
+
+	if p.isKeyword("NOT") {
+		c.Not()
+		err = p.next()
+		if err != nil {
+			return err
+		}
+		if !p.isKeyword("IN") && !p.isKeyword("CONTAINS") && !p.isKeyword("HAS") && !p.isKeyword("EQFOLD") {
+			return p.unexpected("IN, CONTAINS, HAS or EQFOLD")
+		}
+	}
+
+	switch {
+	case p.tok.kind == tokenOperator:
+		op := p.tok.text
+		err = p.next()
+		if err != nil {
+			return err
+		}
+
+		if op == "=~" || op == "!~" {
+			if p.tok.kind != tokenRegexp {
+				return p.unexpected("a regular expression")
+			}
+			expression, err := regexp.Compile(p.tok.text)
+			if err != nil {
+				return p.errorAt(p.tok.offset, "invalid regular expression: %s", err)
+			}
+			if op == "!~" {
+				c.Not()
+			}
+			c.RegExp(expression)
+			return p.next()
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case "=", "==":
+			c.Eq(value)
+		case "!=":
+			c.Ne(value)
+		case ">":
+			c.Gt(value)
+		case "<":
+			c.Lt(value)
+		case ">=":
+			c.Ge(value)
+		case "<=":
+			c.Le(value)
+		default:
+			return p.errorAt(offset, "unknown operator %s", op)
+		}
+		return nil
+	case p.isKeyword("IN"):
+		err = p.next()
+		if err != nil {
+			return err
+		}
+		values, err := p.parseValues()
+		if err != nil {
+			return err
+		}
+		c.In(values...)
+		return nil
+	case p.isKeyword("IS"):
+		err = p.next()
+		if err != nil {
+			return err
+		}
+		if p.isKeyword("NOT") {
+			c.Not()
+			err = p.next()
+			if err != nil {
+				return err
+			}
+		}
+		if !p.isKeyword("NIL") && !p.isKeyword("NULL") {
+			return p.unexpected("NIL")
+		}
+		c.IsNil()
+		return p.next()
+	case p.isKeyword("CONTAINS"):
+		err = p.next()
+		if err != nil {
+			return err
+		}
+		if p.isKeyword("STRING") {
+			err = p.next()
+			if err != nil {
+				return err
+			}
+			value, err := p.parseString()
+			if err != nil {
+				return err
+			}
+			c.ContainsString(value)
+			return nil
+		}
+		if p.isKeyword("ANY") || p.isKeyword("ALL") {
+			all := p.isKeyword("ALL")
+			err = p.next()
+			if err != nil {
+				return err
+			}
+			values, err := p.parseValues()
+			if err != nil {
+				return err
+			}
+			if all {
+				c.ContainsAll(values...)
+			} else {
+				c.ContainsAny(values...)
+			}
+			return nil
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return err
+		}
+		c.Contains(value)
+		return nil
+	case p.isKeyword("HAS"):
+		err = p.next()
+		if err != nil {
+			return err
+		}
+		if p.isKeyword("PREFIX") || p.isKeyword("SUFFIX") {
+			suffix := p.isKeyword("SUFFIX")
+			err = p.next()
+			if err != nil {
+				return err
+			}
+			value, err := p.parseString()
+			if err != nil {
+				return err
+			}
+			if suffix {
+				c.HasSuffix(value)
+			} else {
+				c.HasPrefix(value)
+			}
+			return nil
+		}
+		if !p.isKeyword("KEY") && !p.isKeyword("VALUE") {
+			return p.unexpected("KEY, VALUE, PREFIX or SUFFIX")
+		}
+		hasValue := p.isKeyword("VALUE")
+		err = p.next()
+		if err != nil {
+			return err
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return err
+		}
+		if hasValue {
+			c.HasValue(value)
+		} else {
+			c.HasKey(value)
+		}
+		return nil
+	case p.isKeyword("EQFOLD"):
+		err = p.next()
+		if err != nil {
+			return err
+		}
+		value, err := p.parseString()
+		if err != nil {
+			return err
+		}
+		c.EqFold(value)
+		return nil
+	case p.isKeyword("ELEMMATCH"):
+		err = p.next()
+		if err != nil {
+			return err
+		}
+		sub, err := p.parseSubQuery()
+		if err != nil {
+			return err
+		}
+		c.ElemMatch(sub)
+		return nil
+	case p.isKeyword("MATCHFUNC"):
+		return p.errorAt(offset, "MatchFunc criteria can't be written as text")
+	}
+
+	return p.unexpected("an operator")
+}
+
+// parseValues parses a list of values in parentheses
+func (p *parser) parseValues() ([]interface{}, error) {
+	err := p.expectPunct("(")
+	if err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	for !p.isPunct(")") {
+		if len(values) != 0 {
+			err = p.expectPunct(",")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, p.next()
+}
+
+// parseString parses a string value
+func (p *parser) parseString() (string, error) {
+	if p.tok.kind != tokenString {
+		return "", p.unexpected("a string")
+	}
+	value := p.tok.text
+	return value, p.next()
+}
+
+func (p *parser) parseValue() (interface{}, error) {
+	tok := p.tok
+
+	switch {
+	case tok.kind == tokenString:
+		return tok.text, p.next()
+	case tok.kind == tokenInt:
+		value, err := strconv.ParseInt(tok.text, 10, strconv.IntSize)
+		if err != nil {
+			return nil, p.errorAt(tok.offset, "invalid int %s", tok.text)
+		}
+		return int(value), p.next()
+	case tok.kind == tokenFloat:
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorAt(tok.offset, "invalid float %s", tok.text)
+		}
+		return value, p.next()
+	case p.isKeyword("TRUE"):
+		return true, p.next()
+	case p.isKeyword("FALSE"):
+		return false, p.next()
+	case p.isKeyword("NIL"), p.isKeyword("NULL"):
+		return nil, p.next()
+	case p.isKeyword("TIME"):
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		err = p.expectPunct("(")
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokenString {
+			return nil, p.unexpected("a time string")
+		}
+		value, err := time.Parse(time.RFC3339Nano, p.tok.text)
+		if err != nil {
+			return nil, p.errorAt(p.tok.offset, "invalid time %q, times must be in the RFC 3339 format",
+				p.tok.text)
+		}
+		err = p.next()
+		if err != nil {
+			return nil, err
+		}
+		return value, p.expectPunct(")")
+	case p.isKeyword("FIELD"):
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		err = p.expectPunct("(")
+		if err != nil {
+			return nil, err
+		}
+		field, err := p.parseField(false)
+		if err != nil {
+			return nil, err
+		}
+		return Field(field), p.expectPunct(")")
+	}
+
+	return nil, p.unexpected("a value")
+}
+
+func (p *parser) parseOrder(query *Query) error {
+	err := p.next()
+	if err != nil {
+		return err
+	}
+	err = p.expectKeyword("BY")
+	if err != nil {
+		return err
+	}
+
+	var fields []string
+	var desc []bool
+	var nulls []int
+	for {
+		field, err := p.parseField(true)
+		if err != nil {
+			return err
+		}
+
+		fieldDesc := false
+		if p.isKeyword("ASC") || p.isKeyword("DESC") {
+			fieldDesc = p.isKeyword("DESC")
+			err = p.next()
+			if err != nil {
+				return err
+			}
+		}
+
+		fieldNulls := nullsDefault
+		if p.isKeyword("NULLS") {
+			err = p.next()
+			if err != nil {
+				return err
+			}
+			switch {
+			case p.isKeyword("FIRST"):
+				fieldNulls = nullsFirst
+			case p.isKeyword("LAST"):
+				fieldNulls = nullsLast
+			default:
+				return p.unexpected("FIRST or LAST")
+			}
+			err = p.next()
+			if err != nil {
+				return err
+			}
+		}
+
+		fields = append(fields, field)
+		desc = append(desc, fieldDesc)
+		nulls = append(nulls, fieldNulls)
+
+		if !p.isPunct(",") {
+			break
+		}
+		err = p.next()
+		if err != nil {
+			return err
+		}
+	}
+
+	allDesc := true
+	for i := range desc {
+		allDesc = allDesc && desc[i]
+	}
+
+	if allDesc {
+		// the whole order is reversed, including records with the same values
+		query.SortBy(fields...).Reverse()
+	} else {
+		for i := range fields {
+			if desc[i] {
+				query.SortByDesc(fields[i])
+			} else {
+				query.SortBy(fields[i])
+			}
+		}
+	}
+
+	for i := range fields {
+		if nulls[i] == nullsDefault {
+			continue
+		}
+		for k := range query.sort {
+			if query.sort[k] == fields[i] {
+				query.sortNull[k] = nulls[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *parser) parseCount(clause string) (int, error) {
+	if p.tok.kind != tokenInt {
+		return 0, p.unexpected("a number")
+	}
+
+	value, err := strconv.ParseInt(p.tok.text, 10, strconv.IntSize)
+	if err != nil || value < 0 {
+		return 0, p.errorAt(p.tok.offset, "%s must be a positive int", clause)
+	}
+
+	return int(value), p.next()
+}
+
+// String returns the query as text, which ParseQuery parses back into the same query.  MatchFunc criteria can't be
+// written as text, and values that aren't strings, numbers, bools, times, fields or nil are written as strings.
+// Numbers of any type are written as plain numbers, and compare the same once parsed, whatever their field's type.
+func (q *Query) String() string {
+	var b strings.Builder
+
+	if len(q.selected) > 0 {
+		b.WriteString("SELECT ")
+		for i := range q.selected {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(fieldText(q.selected[i]))
+		}
+		b.WriteString(" WHERE")
+	}
+
+	q.writeBranch(&b)
+
+	// Or'd queries of Or'd queries return the same records as if they were all Or'd with the top query
+	ors := append([]*Query{}, q.ors...)
+	for i := 0; i < len(ors); i++ {
+		b.WriteString(" OR")
+		ors[i].writeBranch(&b)
+		ors = append(ors, ors[i].ors...)
+	}
+
+	if len(q.sort) > 0 {
+		b.WriteString(" ORDER BY ")
+		for i := range q.sort {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(fieldText(q.sort[i]))
+			if q.sortDesc[i] != q.reverse {
+				b.WriteString(" DESC")
+			}
+			switch q.sortNulls(i) {
+			case nullsFirst:
+				b.WriteString(" NULLS FIRST")
+			case nullsLast:
+				b.WriteString(" NULLS LAST")
+			}
+		}
+	}
+
+	if q.skip != 0 {
+		b.WriteString(" SKIP " + strconv.Itoa(q.skip))
+	}
+
+	if q.limit != 0 {
+		b.WriteString(" LIMIT " + strconv.Itoa(q.limit))
+	}
+
+	return strings.TrimPrefix(b.String(), " ")
+}
+
+// writeBranch writes the criteria of the query, in order of their fields, and its index
+func (q *Query) writeBranch(b *strings.Builder) {
+	q.writeCriteria(b)
+
+	if q.indexSet {
+		b.WriteString(" USING INDEX ")
+		if q.index == Key {
+			b.WriteString("KEY")
+		} else {
+			b.WriteString(identText(q.index))
+		}
+	}
+}
+
+// writeCriteria writes the criteria of the query, in order of their fields, followed by its groups
+func (q *Query) writeCriteria(b *strings.Builder) {
+	fields := make([]string, 0, len(q.fieldCriteria))
+	for field := range q.fieldCriteria {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	first := true
+	for _, field := range fields {
+		for _, c := range q.fieldCriteria[field] {
+			if !first {
+				b.WriteString(" AND")
+			}
+			first = false
+
+			b.WriteString(" ")
+			if c.negate {
+				b.WriteString("NOT ")
+			}
+			if c.length {
+				b.WriteString("LEN(" + fieldText(field) + ")")
+			} else {
+				b.WriteString(fieldText(field))
+			}
+			b.WriteString(" ")
+			b.WriteString(c.text())
+		}
+	}
+
+	for _, group := range q.groups {
+		if !first {
+			b.WriteString(" AND")
+		}
+		first = false
+
+		b.WriteString(" ")
+		b.WriteString(group.text())
+	}
+}
+
+// text returns the group in parentheses as query text.  The group's index, sort order, skip and limit aren't used,
+// so they aren't written.
+func (g queryGroup) text() string {
+	var b strings.Builder
+
+	g.query.writeCriteria(&b)
+
+	ors := append([]*Query{}, g.query.ors...)
+	for i := 0; i < len(ors); i++ {
+		b.WriteString(" OR")
+		ors[i].writeCriteria(&b)
+		ors = append(ors, ors[i].ors...)
+	}
+
+	text := "(" + strings.TrimPrefix(b.String(), " ") + ")"
+	if g.negate {
+		return "NOT " + text
+	}
+	return text
+}
+
+// text returns the operator and value of the criterion as query text
+func (c *Criterion) text() string {
+	switch c.operator {
+	case eq:
+		return "== " + valueText(c.value)
+	case ne:
+		return "!= " + valueText(c.value)
+	case gt:
+		return "> " + valueText(c.value)
+	case lt:
+		return "< " + valueText(c.value)
+	case le:
+		return "<= " + valueText(c.value)
+	case ge:
+		return ">= " + valueText(c.value)
+	case in:
+		return "IN " + valuesText(c.values)
+	case re:
+		return "=~ /" + strings.ReplaceAll(c.value.(*regexp.Regexp).String(), "/", `\/`) + "/"
+	case fn:
+		return "MATCHFUNC"
+	case isnil:
+		return "IS NIL"
+	case hk:
+		return "HAS KEY " + valueText(c.value)
+	case hv:
+		return "HAS VALUE " + valueText(c.value)
+	case hp:
+		return "HAS PREFIX " + valueText(c.value)
+	case hs:
+		return "HAS SUFFIX " + valueText(c.value)
+	case cs:
+		return "CONTAINS STRING " + valueText(c.value)
+	case ef:
+		return "EQFOLD " + valueText(c.value)
+	case em:
+		return "ELEMMATCH " + queryGroup{query: c.value.(*Query)}.text()
+	case contains:
+		return "CONTAINS " + valueText(c.value)
+	case any:
+		return "CONTAINS ANY " + valuesText(c.values)
+	case all:
+		return "CONTAINS ALL " + valuesText(c.values)
+	default:
+		panic("invalid operator")
+	}
+}
+
+// fieldText returns the field as query text
+func fieldText(field string) string {
+	if field == Key {
+		return "KEY"
+	}
+	return identText(field)
+}
+
+// identText returns the name as query text, quoting it if it isn't a plain identifier
+func identText(name string) string {
+	plain := name != "" && !keywords[strings.ToUpper(name)]
+	for i, r := range name {
+		if r == '_' || unicode.IsLetter(r) || (i > 0 && (r == '.' || unicode.IsDigit(r))) {
+			continue
+		}
+		plain = false
+	}
+
+	if plain {
+		return name
+	}
+	return "`" + name + "`"
+}
+
+func valuesText(values []interface{}) string {
+	texts := make([]string, len(values))
+	for i := range values {
+		texts[i] = valueText(values[i])
+	}
+	return "(" + strings.Join(texts, ", ") + ")"
+}
+
+// valueText returns the value as query text
+func valueText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return strconv.Quote(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", v)
+	case float32:
+		return floatText(float64(v))
+	case float64:
+		return floatText(v)
+	case time.Time:
+		return "TIME(" + strconv.Quote(v.Format(time.RFC3339Nano)) + ")"
+	case Field:
+		return "FIELD(" + fieldText(string(v)) + ")"
+	}
+
+	return strconv.Quote(fmt.Sprintf("%v", value))
+}
+
+// floatText returns the float as query text, which always parses back into a float
+func floatText(value float64) string {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return strconv.Quote(strconv.FormatFloat(value, 'g', -1, 64))
+	}
+
+	text := strconv.FormatFloat(value, 'g', -1, 64)
+	if !strings.ContainsAny(text, ".e") {
+		text += ".0"
+	}
+	return text
+}
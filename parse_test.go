@@ -0,0 +1,223 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/timshannon/bolthold"
+)
+
+func TestParseQuery(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		tests := []struct {
+			text  string
+			query *bolthold.Query
+		}{
+			{`Category = "food" AND Name =~ /^A/ OR Tags CONTAINS 'red' ORDER BY Name DESC LIMIT 3`,
+				bolthold.Where("Category").Eq("food").And("Name").RegExp(regexp.MustCompile("^A")).
+					Or(bolthold.Where("Tags").Contains("red")).SortBy("Name").Reverse().Limit(3)},
+			{`where key >= 3 and key < 10 and Color != "" order by Name skip 2`,
+				bolthold.Where(bolthold.Key).Ge(3).And(bolthold.Key).Lt(10).And("Color").Ne("").SortBy("Name").Skip(2)},
+			{`Color IN ("red", "blue") AND NOT Category == 'food' USING INDEX Category`,
+				bolthold.Where("Color").In("red", "blue").And("Category").Not().Eq("food").Index("Category")},
+			{`Category NOT IN ("food") AND Name !~ /e/ AND MapVal IS NOT NIL`,
+				bolthold.Where("Category").Not().In("food").And("Name").Not().RegExp(regexp.MustCompile("e")).
+					And("MapVal").Not().IsNil()},
+			{`Tags CONTAINS ANY ("red", "blue") OR Tags CONTAINS ALL ("fast", "car")`,
+				bolthold.Where("Tags").ContainsAny("red", "blue").Or(bolthold.Where("Tags").ContainsAll("fast", "car"))},
+			{`MapVal HAS KEY "test" OR Fruit = FIELD(Color)`,
+				bolthold.Where("MapVal").HasKey("test").Or(bolthold.Where("Fruit").Eq(bolthold.Field("Color")))},
+			{`SELECT Name, Category WHERE Category = "vehicle"`,
+				bolthold.Where("Category").Eq("vehicle").Select("Name", "Category")},
+			{`ORDER BY Category, Name`, (&bolthold.Query{}).SortBy("Category", "Name")},
+			{`ORDER BY Category, Name DESC`, (&bolthold.Query{}).SortBy("Category").SortByDesc("Name")},
+			{`ORDER BY KEY DESC`, (&bolthold.Query{}).SortBy(bolthold.Key).Reverse()},
+			{``, nil},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.text, func(t *testing.T) {
+				query, err := bolthold.ParseQuery(tst.text)
+				ok(t, err)
+
+				expected := tst.query
+				if expected == nil {
+					expected = &bolthold.Query{}
+				}
+				equals(t, expected.String(), query.String())
+
+				var want, got []ItemTest
+				ok(t, store.Find(&want, tst.query))
+				ok(t, store.Find(&got, query))
+				equals(t, want, got)
+			})
+		}
+	})
+}
+
+func TestQueryStringRoundTrip(t *testing.T) {
+	queries := []*bolthold.Query{
+		bolthold.Where("Name").Eq("with \"quotes\" and\nnew lines").And("Age").Ge(21).And("Score").Lt(1.0).
+			And("Rate").Gt(2.5e-10).And("Active").Eq(true).And("Parent").Eq(nil),
+		bolthold.Where("Created").Gt(time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)),
+		bolthold.Where("Path").RegExp(regexp.MustCompile(`^/home/.*\.go$`)),
+		bolthold.Where("Name").Not().In("a", 1, 2.5, nil).Index("Name").
+			Or(bolthold.Where("Or").IsNil().Index(bolthold.Key).
+				Or(bolthold.Where("Nested").Eq(bolthold.Field("Other")))),
+		bolthold.Where("Order").Eq("keyword field").Index("Index With Spaces").SortBy("Select", "Name").Reverse().
+			Skip(10).Limit(20),
+		bolthold.Where(bolthold.Key).Eq(1).Select("Name", "Order"),
+	}
+
+	for _, query := range queries {
+		text := query.String()
+		parsed, err := bolthold.ParseQuery(text)
+		if err != nil {
+			t.Fatalf("Error parsing %s: %s", text, err)
+		}
+		equals(t, text, parsed.String())
+	}
+
+	equals(t, "Name == \"a\" OR Nested == 1 OR `Or` == 2",
+		bolthold.Where("Name").Eq("a").Or(bolthold.Where("Nested").Eq(1).Or(bolthold.Where("Or").Eq(2))).String())
+}
+
+type NumberTypes struct {
+	ID      int   `boltholdKey:"ID"`
+	N64     int64 `boltholdIndex:"N64"`
+	U       uint
+	U32     uint32
+	F32     float32 `boltholdIndex:"F32"`
+	Numbers []int16
+}
+
+func TestParsedNumberTypes(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		data := []NumberTypes{
+			{ID: 1, N64: 3, U: 7, U32: 10, F32: 0.1, Numbers: []int16{1, 2}},
+			{ID: 2, N64: -5, U: 0, U32: 4000000000, F32: 2.5, Numbers: []int16{3}},
+			{ID: 3, N64: 12, U: 12, U32: 3, F32: -1, Numbers: []int16{2, 5}},
+		}
+		for i := range data {
+			ok(t, store.Insert(data[i].ID, &data[i]))
+		}
+
+		tests := []struct {
+			query *bolthold.Query
+			want  []int
+		}{
+			{bolthold.Where("N64").Eq(int64(3)), []int{1}},
+			{bolthold.Where("N64").Ge(int64(0)).Index("N64"), []int{1, 3}},
+			{bolthold.Where("N64").In(int64(-5), int64(12)), []int{2, 3}},
+			{bolthold.Where("U").Gt(uint(5)), []int{1, 3}},
+			{bolthold.Where("U32").Ge(uint32(4000000000)), []int{2}},
+			{bolthold.Where("F32").Eq(float32(0.1)), []int{1}},
+			{bolthold.Where("F32").Lt(float32(2.5)).Index("F32"), []int{1, 3}},
+			{bolthold.Where("U").Eq(uint(0)).Or(bolthold.Where("N64").Lt(int64(4))), []int{1, 2}},
+			{bolthold.Where("Numbers").Contains(int16(2)), []int{1, 3}},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.query.String(), func(t *testing.T) {
+				var result []NumberTypes
+				ok(t, store.Find(&result, tst.query))
+				ids := []int{}
+				for i := range result {
+					ids = append(ids, result[i].ID)
+				}
+				sort.Ints(ids)
+				equals(t, tst.want, ids)
+
+				parsed, err := bolthold.ParseQuery(tst.query.String())
+				ok(t, err)
+				ok(t, parsed.Validate(&NumberTypes{}))
+
+				data, err := json.Marshal(parsed)
+				ok(t, err)
+				unmarshaled := &bolthold.Query{}
+				ok(t, json.Unmarshal(data, unmarshaled))
+
+				for _, query := range []*bolthold.Query{parsed, unmarshaled} {
+					result = nil
+					ok(t, store.Find(&result, query))
+					ids = []int{}
+					for i := range result {
+						ids = append(ids, result[i].ID)
+					}
+					sort.Ints(ids)
+					equals(t, tst.want, ids)
+				}
+			})
+		}
+
+		// numbers that don't fit the type of the field are still compared by their value
+		texts := map[string][]int{
+			"N64 > 2.5":  {1, 3},
+			"N64 = 2.5":  {},
+			"U > -1":     {1, 2, 3},
+			"U32 < 1e10": {1, 2, 3},
+			"F32 >= 0":   {1, 2},
+			// compared exactly, the same as with NumericPromotion, and 0.1 is a different number as a float32
+			"F32 = 0.1": {},
+		}
+		for text, want := range texts {
+			parsed, err := bolthold.ParseQuery(text)
+			ok(t, err)
+
+			var result []NumberTypes
+			ok(t, store.Find(&result, parsed))
+			ids := []int{}
+			for i := range result {
+				ids = append(ids, result[i].ID)
+			}
+			sort.Ints(ids)
+			equals(t, want, ids)
+		}
+	})
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		text   string
+		line   int
+		column int
+	}{
+		{`Name = `, 1, 8},
+		{`Name = "open`, 1, 8},
+		{`name = "lower"`, 1, 1},
+		{`Name =~ /(/`, 1, 9},
+		{`Name = "a" AND`, 1, 15},
+		{`Name = "a"` + "\n" + `AND Age ? 3`, 2, 9},
+		{`Name = "a" ORDER BY Name ASC DESC`, 1, 30},
+		{`ORDER BY Name,`, 1, 15},
+		{`Name MATCHFUNC`, 1, 6},
+		{`Name IN ("a" "b")`, 1, 14},
+		{`Name = "a" LIMIT -1`, 1, 18},
+		{`Name = "a" LIMIT 1 SKIP 1`, 1, 20},
+		{`Created > TIME("yesterday")`, 1, 16},
+		{`Name IS NOT "a"`, 1, 13},
+		{`Name = "a" USING INDEX`, 1, 23},
+	}
+
+	for _, tst := range tests {
+		_, err := bolthold.ParseQuery(tst.text)
+		var parseErr *bolthold.ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("Parsing %s returned %v instead of a ParseError", tst.text, err)
+		}
+		if parseErr.Line != tst.line || parseErr.Column != tst.column {
+			t.Fatalf("Parsing %s returned an error at line %d column %d instead of line %d column %d: %s", tst.text,
+				parseErr.Line, parseErr.Column, tst.line, tst.column, err)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// indexEstimateMaxValues is the most index values read when estimating how many records an index will return
+const indexEstimateMaxValues = 1000
+
+// selectIndex chooses the index to run the query against when one hasn't been specified with Query.Index.
+// Every index with criteria on its leading field that can be answered by the index is a candidate, and the one
+// estimated to return the fewest records is picked.  If no index can be used, the query runs against the Key.
+// Indexes of custom Storers are only candidates when the criteria bound the part of the index that is read.
+func (s *Store) selectIndex(source BucketSource, storer Storer, query *Query) string {
+	_, tagged := storer.(*anonStorer)
+
+	names := make([]string, 0, len(storer.Indexes()))
+	for name := range storer.Indexes() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best := Key
+	bestEstimate := -1
+	fallback := Key
+	fallbackFields := 0
+
+	for _, name := range names {
+		fields := indexFields(storer, name)
+		if !canPlanIndex(query.fieldCriteria[fields[0]]) {
+			continue
+		}
+
+		usable := true
+		constrained := 0
+		for _, field := range fields {
+			criteria, ok := query.fieldCriteria[field]
+			if !ok {
+				continue
+			}
+			if !canPlanIndex(criteria) {
+				usable = false
+				break
+			}
+			constrained++
+		}
+
+		if !usable {
+			continue
+		}
+
+		iBucket := source.Bucket(indexBucketName(storer.Type(), name))
+		if iBucket == nil || iBucket.Sequence() != indexVersion {
+			// indexes from older versions of bolthold may not cover every record
+			continue
+		}
+
+		if !tagged && !s.rangePlanned(storer, name, iBucket, fields, query) {
+			continue
+		}
+
+		estimate, ok := s.estimateIndex(iBucket, fields, query, bestEstimate)
+		if ok {
+			best = name
+			bestEstimate = estimate
+			continue
+		}
+
+		if bestEstimate < 0 && constrained > fallbackFields {
+			fallback = name
+			fallbackFields = constrained
+		}
+	}
+
+	if bestEstimate < 0 {
+		return fallback
+	}
+
+	return best
+}
+
+// rangePlanned returns whether the criteria on the fields of a custom Storer's index bound the part of the index that
+// is read.  Only indexes that set their Fields, and whose keys are encoded with EncodeIndexKey, can be bounded.  The
+// keys of any other custom index may not hold the values of the fields, so it's only used when named with Query.Index
+func (s *Store) rangePlanned(storer Storer, name string, iBucket *bolt.Bucket, fields []string, query *Query) bool {
+	if len(storer.Indexes()[name].Fields) == 0 {
+		return false
+	}
+
+	rng := s.newIndexRange(iBucket.Cursor(), fields, query.fieldCriteria)
+	return len(rng.lower) != 0 || len(rng.upper) != 0
+}
+
+// canPlanIndex returns whether or not the criteria can be picked automatically to run against an index.
+// Records with a nil value aren't stored in an index, so only criteria that can never match a nil value qualify.
+func canPlanIndex(criteria []*Criterion) bool {
+	if len(criteria) == 0 {
+		return false
+	}
+
+	for _, c := range criteria {
+		if c.negate || c.length {
+			return false
+		}
+
+		switch c.operator {
+		case hp:
+			// a nil value never has the prefix
+		case eq, gt, lt, ge, le:
+			if c.value == nil {
+				return false
+			}
+			if _, ok := c.value.(Field); ok {
+				return false
+			}
+		case in:
+			for i := range c.values {
+				if c.values[i] == nil {
+					return false
+				}
+				if _, ok := c.values[i].(Field); ok {
+					return false
+				}
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// estimateIndex estimates the number of records the index will return for the query from the record counts stored
+// on each index value.  If the estimate can't be made without reading too much of the index, or it reaches max, then
+// ok is false. A negative max means there is no maximum.
+func (s *Store) estimateIndex(iBucket *bolt.Bucket, fields []string, query *Query, max int) (estimate int, ok bool) {
+	criteria := query.fieldCriteria[fields[0]]
+	if len(fields) == 1 && len(criteria) == 1 && criteria[0].operator == in {
+		for _, value := range criteria[0].values {
+			indexKey, err := s.encodeIndexKey(value)
+			if err != nil {
+				return 0, false
+			}
+			if keys := iBucket.Bucket(indexKey); keys != nil {
+				estimate += int(keys.Sequence())
+			}
+			if max >= 0 && estimate >= max {
+				return estimate, false
+			}
+		}
+		return estimate, true
+	}
+
+	cursor := iBucket.Cursor()
+	rng := s.newIndexRange(cursor, fields, query.fieldCriteria)
+
+	read := 0
+	for k, v := rng.seek(cursor); k != nil && !rng.past(k); k, v = cursor.Next() {
+		if read >= indexEstimateMaxValues || (max >= 0 && estimate >= max) {
+			return estimate, false
+		}
+		read++
+
+		if v == nil {
+			estimate += int(iBucket.Bucket(k).Sequence())
+		}
+	}
+
+	return estimate, max < 0 || estimate < max
+}
+
+// Plan describes how a query is run against the store, see Store.Explain
+type Plan struct {
+	// Type is the bucket name of the records being queried
+	Type string
+	// Index is the index the records are read through.  An empty Index (bolthold.Key) reads the records in key
+	// order
+	Index string
+	// Selected is true when the index was picked automatically, rather than set with Query.Index
+	Selected bool
+	// Scan is true when every record is read and tested against the criteria, either because the query runs against
+	// the Key, or because the criteria can't use the index
+	Scan bool
+	// IndexFields are the fields whose criteria are tested against the index keys rather than each record
+	IndexFields []string
+	// Prefix, Seek and Stop are the encoded index keys that bound the part of the index that is read.  The index is
+	// read from Seek until a key no longer starts with Prefix, or is past Stop.  A nil Seek starts at the
+	// beginning of the index, and a nil Prefix and Stop read to the end
+	Prefix []byte
+	Seek   []byte
+	Stop   []byte
+	// Estimate is the estimated number of records read from the index, or -1 if it isn't known
+	Estimate int
+	// Covered is true when the index holds every field the query selects, tests and sorts by, so the records are
+	// read from the index keys rather than decoded
+	Covered bool
+	// Criteria are the criteria tested against each record read
+	Criteria []string
+	// Sort is the fields the matching records are sorted by, and SortDesc is whether each of them is sorted in
+	// descending order.  Sorting happens in memory, after every matching record has been read, unless Sorted is
+	// false because the records are read in order, either by Key or from the index on the only sort field
+	Sort     []string
+	SortDesc []bool
+	Sorted   bool
+	Reverse  bool
+	Skip     int
+	Limit    int
+	// Ors are the plans for each query Or'd with this one.  They run after this query, and skip any records it has
+	// already matched
+	Ors []*Plan
+
+	// Profiled is true if the plan was returned by Store.Profile, in which case Scanned is the number of records
+	// read, and Matched is the number of those records that matched the criteria
+	Profiled bool
+	Scanned  int
+	Matched  int
+}
+
+// Explain returns the plan for running the query against the passed in data type, without running it
+func (s *Store) Explain(dataType interface{}, query *Query) (*Plan, error) {
+	var plan *Plan
+	err := s.Bolt().View(func(tx *bolt.Tx) error {
+		var txErr error
+		plan, txErr = s.TxExplain(tx, dataType, query)
+		return txErr
+	})
+	return plan, err
+}
+
+// TxExplain is the same as Explain but you get to specify your transaction
+func (s *Store) TxExplain(tx *bolt.Tx, dataType interface{}, query *Query) (*Plan, error) {
+	return s.explain(tx, dataType, query)
+}
+
+// ExplainInBucket is the same as Explain but you get to specify your parent bucket
+func (s *Store) ExplainInBucket(parent *bolt.Bucket, dataType interface{}, query *Query) (*Plan, error) {
+	return s.explain(parent, dataType, query)
+}
+
+// Profile runs the query against the passed in data type, and returns its plan along with the number of records
+// scanned and matched by the query and each of its Or'd queries
+func (s *Store) Profile(dataType interface{}, query *Query) (*Plan, error) {
+	var plan *Plan
+	err := s.Bolt().View(func(tx *bolt.Tx) error {
+		var txErr error
+		plan, txErr = s.TxProfile(tx, dataType, query)
+		return txErr
+	})
+	return plan, err
+}
+
+// TxProfile is the same as Profile but you get to specify your transaction
+func (s *Store) TxProfile(tx *bolt.Tx, dataType interface{}, query *Query) (*Plan, error) {
+	return s.profile(tx, dataType, query)
+}
+
+// ProfileInBucket is the same as Profile but you get to specify your parent bucket
+func (s *Store) ProfileInBucket(parent *bolt.Bucket, dataType interface{}, query *Query) (*Plan, error) {
+	return s.profile(parent, dataType, query)
+}
+
+func (s *Store) explain(source BucketSource, dataType interface{}, query *Query) (*Plan, error) {
+	if query == nil {
+		query = &Query{}
+	}
+
+	// the query is planned on a copy, so the planner's state isn't left on the caller's query
+	qCopy := *query
+	query = &qCopy
+
+	storer := s.newStorer(dataType)
+
+	plan := &Plan{
+		Type:     storer.Type(),
+		Index:    query.index,
+		Estimate: -1,
+		Sort:     query.sort,
+		SortDesc: query.sortDesc,
+		Sorted:   len(query.sort) > 0,
+		Reverse:  query.reverse,
+		Skip:     query.skip,
+		Limit:    query.limit,
+	}
+
+	if query.index != "" && source.Bucket(indexBucketName(storer.Type(), query.index)) == nil {
+		return nil, fmt.Errorf("The index %s does not exist", query.index)
+	}
+
+	if !query.indexSet {
+		plan.Index = s.selectIndex(source, storer, query)
+		plan.Selected = true
+	}
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	query.dataType = tp
+
+	if !query.indexSet {
+		if sortIndex := s.sortIndex(source, storer, query, plan.Index); sortIndex != "" {
+			plan.Index = sortIndex
+		}
+	}
+
+	inOrder, err := s.readsInOrder(source, storer, query)
+	if err != nil {
+		return nil, err
+	}
+	plan.Sorted = len(query.sort) > 0 && !inOrder
+
+	iter := s.newIterator(source, storer, query, plan.Index, nil)
+	plan.Scan = iter.scan
+	plan.Covered = !iter.scan && s.coversQuery(source, storer, query, plan.Index)
+
+	if !iter.scan && iter.rng != nil {
+		plan.IndexFields = query.indexFields
+		plan.Prefix = iter.rng.prefix
+		plan.Seek = iter.rng.lower
+		plan.Stop = iter.rng.upper
+
+		iBucket := source.Bucket(indexBucketName(storer.Type(), plan.Index))
+		if estimate, ok := s.estimateIndex(iBucket, indexFields(storer, plan.Index), query, -1); ok {
+			plan.Estimate = estimate
+		}
+	}
+
+	fields := make([]string, 0, len(query.fieldCriteria))
+	for field := range query.fieldCriteria {
+		if plan.IndexFields != nil && query.indexHandles(field) {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		name := field
+		if name == Key {
+			name = "Key"
+		}
+		for _, c := range query.fieldCriteria[field] {
+			plan.Criteria = append(plan.Criteria, name+" "+c.String())
+		}
+	}
+
+	for _, group := range query.groups {
+		plan.Criteria = append(plan.Criteria, group.text())
+	}
+
+	for i := range query.ors {
+		orPlan, err := s.explain(source, dataType, query.ors[i])
+		if err != nil {
+			return nil, err
+		}
+		plan.Ors = append(plan.Ors, orPlan)
+	}
+
+	return plan, nil
+}
+
+func (s *Store) profile(source BucketSource, dataType interface{}, query *Query) (*Plan, error) {
+	if query == nil {
+		query = &Query{}
+	}
+
+	plan, err := s.explain(source, dataType, query)
+	if err != nil {
+		return nil, err
+	}
+
+	query.setProfile(plan)
+	defer query.setProfile(nil)
+
+	err = s.runQuery(source, dataType, query, nil, query.skip, func(r *record) error {
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
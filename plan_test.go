@@ -0,0 +1,326 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/timshannon/bolthold"
+	bolt "go.etcd.io/bbolt"
+)
+
+type PlanItem struct {
+	Key    int    `boltholdKey:"Key"`
+	Status string `boltholdIndex:"Status"`
+	Email  string `boltholdIndex:"Email"`
+}
+
+// PlanItemUnindexed shares a bucket with PlanItem, but doesn't declare any indexes
+type PlanItemUnindexed struct {
+	Key    int
+	Status string
+	Email  string
+}
+
+func (p *PlanItemUnindexed) Type() string { return "PlanItem" }
+
+func (p *PlanItemUnindexed) Indexes() map[string]bolthold.Index { return nil }
+
+func (p *PlanItemUnindexed) SliceIndexes() map[string]bolthold.SliceIndex { return nil }
+
+func planStatus(i int) string {
+	if i%2 == 0 {
+		return "active"
+	}
+	return "inactive"
+}
+
+func insertPlanData(t *testing.T, store *bolthold.Store) {
+	for i := 0; i < 100; i++ {
+		ok(t, store.Insert(i, &PlanItem{
+			Status: planStatus(i),
+			Email:  fmt.Sprintf("user%d@example.com", i),
+		}))
+	}
+}
+
+// removeIndexValue removes a value from the index behind bolthold's back, so queries that use the index no longer
+// find the records with that value
+func removeIndexValue(t *testing.T, store *bolthold.Store, index string, value interface{}) {
+	key, err := store.EncodeIndexKey(value)
+	ok(t, err)
+
+	ok(t, store.Bolt().Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(indexName("PlanItem", index)).DeleteBucket(key)
+	}))
+}
+
+func TestAutoIndex(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPlanData(t, store)
+
+		query := func() *bolthold.Query {
+			return bolthold.Where("Status").Eq("active").And("Email").Eq("user10@example.com")
+		}
+
+		var result []PlanItem
+		ok(t, store.Find(&result, query()))
+		equals(t, 1, len(result))
+		equals(t, 10, result[0].Key)
+
+		// Email is far more selective than Status, so it's picked
+		removeIndexValue(t, store, "Email", "user10@example.com")
+
+		result = nil
+		ok(t, store.Find(&result, query()))
+		equals(t, 0, len(result))
+
+		// an explicit index is always used
+		result = nil
+		ok(t, store.Find(&result, query().Index("Status")))
+		equals(t, 1, len(result))
+
+		result = nil
+		ok(t, store.Find(&result, query().Index(bolthold.Key)))
+		equals(t, 1, len(result))
+	})
+}
+
+func TestAutoIndexOr(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPlanData(t, store)
+
+		count, err := store.Count(&PlanItem{}, bolthold.Where("Email").Eq("user1@example.com").
+			Or(bolthold.Where("Status").Eq("active")))
+		ok(t, err)
+		equals(t, 51, count)
+
+		// each Or'd query picks its own index
+		removeIndexValue(t, store, "Email", "user1@example.com")
+
+		count, err = store.Count(&PlanItem{}, bolthold.Where("Email").Eq("user1@example.com").
+			Or(bolthold.Where("Status").Eq("active")))
+		ok(t, err)
+		equals(t, 50, count)
+	})
+}
+
+func TestAutoIndexNotUsed(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPlanData(t, store)
+		removeIndexValue(t, store, "Status", "inactive")
+
+		// records with nil values aren't stored in an index, so criteria that could match a nil value read every
+		// record instead
+		count, err := store.Count(&PlanItem{}, bolthold.Where("Status").Ne("active"))
+		ok(t, err)
+		equals(t, 50, count)
+
+		count, err = store.Count(&PlanItem{}, bolthold.Where("Status").Not().Eq("active"))
+		ok(t, err)
+		equals(t, 50, count)
+
+		count, err = store.Count(&PlanItem{}, bolthold.Where("Status").Eq("inactive"))
+		ok(t, err)
+		equals(t, 0, count)
+	})
+}
+
+func TestAutoIndexExistingRecords(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		for i := 0; i < 10; i++ {
+			ok(t, store.Insert(i, &PlanItemUnindexed{Status: planStatus(i)}))
+		}
+
+		// the first write with an index builds it from every record already stored
+		ok(t, store.Insert(10, &PlanItem{Status: "active", Email: "new@example.com"}))
+
+		count, err := store.Count(&PlanItem{}, bolthold.Where("Status").Eq("active"))
+		ok(t, err)
+		equals(t, 6, count)
+	})
+}
+
+// LowerName is a custom Storer whose Name index holds the lower case name, rather than the name itself
+type LowerName struct {
+	Name string
+}
+
+func (l *LowerName) Type() string { return "LowerName" }
+
+func (l *LowerName) Indexes() map[string]bolthold.Index {
+	return map[string]bolthold.Index{
+		"Name": {
+			IndexFunc: func(_ string, value interface{}) ([]byte, error) {
+				return []byte(strings.ToLower(value.(*LowerName).Name)), nil
+			},
+			Fields: []string{"Name"},
+		},
+	}
+}
+
+func (l *LowerName) SliceIndexes() map[string]bolthold.SliceIndex { return nil }
+
+func TestAutoIndexCustomStorer(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		ok(t, store.Insert(1, &LowerName{Name: "John"}))
+		ok(t, store.Insert(2, &LowerName{Name: "Kate"}))
+		ok(t, store.Insert(3, &LowerName{Name: "Abe"}))
+
+		// the Name index isn't encoded with EncodeIndexKey, so it can't be bounded and isn't picked automatically
+		var result []LowerName
+		ok(t, store.Find(&result, bolthold.Where("Name").Eq("John")))
+		equals(t, 1, len(result))
+
+		result = nil
+		ok(t, store.Find(&result, bolthold.Where("Name").Ge("J")))
+		equals(t, 2, len(result))
+
+		plan, err := store.Explain(&LowerName{}, bolthold.Where("Name").Eq("John"))
+		ok(t, err)
+		equals(t, bolthold.Key, plan.Index)
+
+		// custom indexes encoded with EncodeIndexKey are picked when the criteria bound the part that is read
+		customCompositeStore = store
+		for i := 0; i < 20; i++ {
+			ok(t, store.Insert(i, &CustomComposite{Category: []string{"a", "b"}[i%2], Rank: i}))
+		}
+
+		query := bolthold.Where("Category").Eq("b").And("Rank").Lt(9)
+		plan, err = store.Explain(&CustomComposite{}, query)
+		ok(t, err)
+		equals(t, "CategoryRank", plan.Index)
+		assert(t, plan.Selected && !plan.Scan, "The CategoryRank index wasn't range planned")
+
+		var composites []CustomComposite
+		ok(t, store.Find(&composites, query))
+		equals(t, 4, len(composites))
+
+		plan, err = store.Explain(&CustomComposite{}, bolthold.Where("Rank").Lt(9))
+		ok(t, err)
+		equals(t, bolthold.Key, plan.Index)
+	})
+}
+
+func TestExplain(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPlanData(t, store)
+
+		t.Run("Selected Index", func(t *testing.T) {
+			plan, err := store.Explain(&PlanItem{}, bolthold.Where("Email").Eq("user10@example.com"))
+			ok(t, err)
+
+			key, err := store.EncodeIndexKey("user10@example.com")
+			ok(t, err)
+
+			equals(t, "PlanItem", plan.Type)
+			equals(t, "Email", plan.Index)
+			assert(t, plan.Selected, "Index was not selected automatically")
+			assert(t, !plan.Scan, "Plan scans every record")
+			equals(t, []string{"Email"}, plan.IndexFields)
+			equals(t, key, plan.Prefix)
+			equals(t, key, plan.Seek)
+			equals(t, 1, plan.Estimate)
+			equals(t, 0, len(plan.Criteria))
+			assert(t, !plan.Profiled, "Plan was profiled")
+		})
+
+		t.Run("Range", func(t *testing.T) {
+			plan, err := store.Explain(&PlanItem{}, bolthold.Where("Status").Eq("active").And("Email").Ge("user9"))
+			ok(t, err)
+
+			key, err := store.EncodeIndexKey("user9")
+			ok(t, err)
+
+			equals(t, "Email", plan.Index)
+			equals(t, key, plan.Seek)
+			assert(t, plan.Stop == nil, "Range has an upper bound %v", plan.Stop)
+			equals(t, 11, plan.Estimate)
+			equals(t, []string{"Status == active"}, plan.Criteria)
+		})
+
+		t.Run("Scan", func(t *testing.T) {
+			plan, err := store.Explain(&PlanItem{}, bolthold.Where("Status").Ne("active"))
+			ok(t, err)
+
+			equals(t, bolthold.Key, plan.Index)
+			assert(t, plan.Selected, "Index was not selected automatically")
+			assert(t, plan.Scan, "Plan doesn't scan every record")
+			equals(t, -1, plan.Estimate)
+			equals(t, []string{"Status != active"}, plan.Criteria)
+		})
+
+		t.Run("Unusable Index", func(t *testing.T) {
+			plan, err := store.Explain(&PlanItem{}, bolthold.Where("Status").
+				MatchFunc(func(ra *bolthold.RecordAccess) (bool, error) {
+					return true, nil
+				}).Index("Status"))
+			ok(t, err)
+
+			equals(t, "Status", plan.Index)
+			assert(t, !plan.Selected, "Index was selected automatically")
+			assert(t, plan.Scan, "Plan doesn't scan every record")
+			equals(t, 1, len(plan.Criteria))
+		})
+
+		t.Run("Sort and Or", func(t *testing.T) {
+			plan, err := store.Explain(&PlanItem{}, bolthold.Where("Email").Eq("user1@example.com").
+				Or(bolthold.Where("Status").Eq("active")).SortBy("Email").Reverse().Skip(2).Limit(10))
+			ok(t, err)
+
+			equals(t, "Email", plan.Index)
+			equals(t, []string{"Email"}, plan.Sort)
+			assert(t, plan.Reverse, "Plan isn't reversed")
+			equals(t, 2, plan.Skip)
+			equals(t, 10, plan.Limit)
+			equals(t, 1, len(plan.Ors))
+			equals(t, "Status", plan.Ors[0].Index)
+			equals(t, 50, plan.Ors[0].Estimate)
+		})
+
+		t.Run("Missing Index", func(t *testing.T) {
+			_, err := store.Explain(&PlanItem{}, bolthold.Where("Status").Eq("active").Index("Missing"))
+			assert(t, err != nil, "No error explaining a query on a missing index")
+		})
+	})
+}
+
+func TestProfile(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPlanData(t, store)
+
+		query := bolthold.Where("Status").Eq("active").And("Email").Ge("user9")
+		plan, err := store.Profile(&PlanItem{}, query)
+		ok(t, err)
+
+		assert(t, plan.Profiled, "Plan wasn't profiled")
+		equals(t, 11, plan.Scanned)
+		equals(t, 5, plan.Matched)
+
+		plan, err = store.Profile(&PlanItem{}, bolthold.Where("Status").Ne("active").Limit(10).
+			Or(bolthold.Where("Email").Eq("user0@example.com")))
+		ok(t, err)
+
+		equals(t, 20, plan.Scanned)
+		equals(t, 10, plan.Matched)
+		equals(t, 0, plan.Ors[0].Scanned)
+
+		plan, err = store.Profile(&PlanItem{}, bolthold.Where("Status").Ne("active").
+			Or(bolthold.Where("Email").Eq("user0@example.com")))
+		ok(t, err)
+
+		equals(t, 100, plan.Scanned)
+		equals(t, 50, plan.Matched)
+		equals(t, 1, plan.Ors[0].Scanned)
+		equals(t, 1, plan.Ors[0].Matched)
+
+		// profiling doesn't change the query
+		count, err := store.Count(&PlanItem{}, query)
+		ok(t, err)
+		equals(t, 5, count)
+	})
+}
@@ -0,0 +1,183 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// project returns a copy of the record value with only the query's selected fields set.  value is always a pointer
+// to a struct, and is returned as is if no fields are selected
+func (q *Query) project(value reflect.Value) (reflect.Value, error) {
+	if len(q.selected) == 0 {
+		return value, nil
+	}
+
+	projected := reflect.New(value.Type().Elem())
+
+	if field, ok := keyField(value.Type().Elem()); ok {
+		projected.Elem().FieldByIndex(field.Index).Set(value.Elem().FieldByIndex(field.Index))
+	}
+
+	for _, field := range q.selected {
+		err := projectField(value.Elem(), projected.Elem(), strings.Split(field, "."))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+	}
+
+	return projected, nil
+}
+
+// unselected returns the query without its selected fields, for running queries that need every field of the
+// records they read
+func (q *Query) unselected() *Query {
+	if len(q.selected) == 0 {
+		return q
+	}
+
+	qCopy := *q
+	qCopy.selected = nil
+	return &qCopy
+}
+
+// projectField copies the field at path from src to dst, allocating any struct pointers along the path
+func projectField(src, dst reflect.Value, path []string) error {
+	for i, name := range path {
+		for src.Kind() == reflect.Ptr {
+			if src.IsNil() {
+				return nil
+			}
+			if dst.IsNil() {
+				dst.Set(reflect.New(src.Type().Elem()))
+			}
+			src = src.Elem()
+			dst = dst.Elem()
+		}
+
+		if src.Kind() != reflect.Struct {
+			return fmt.Errorf("%s is not a struct", src.Type())
+		}
+
+		srcField := src.FieldByName(name)
+		if !srcField.IsValid() {
+			return fmt.Errorf("The field %s does not exist in the type %s", name, src.Type())
+		}
+		dstField := dst.FieldByName(name)
+
+		if i == len(path)-1 {
+			dstField.Set(srcField)
+			return nil
+		}
+
+		src = srcField
+		dst = dstField
+	}
+
+	return nil
+}
+
+// coversQuery returns whether the records that match the query can be read from the index's keys, without
+// decoding the records.  Only the fields of indexes defined with struct tags, and kinds of values that are read
+// back from an index key exactly as they were written, are covered.
+func (s *Store) coversQuery(source BucketSource, storer Storer, query *Query, index string) bool {
+	if len(query.selected) == 0 || index == Key || query.dataType == nil || len(query.groups) > 0 {
+		// groups are tested against the whole record
+		return false
+	}
+
+	if _, ok := storer.(*anonStorer); !ok {
+		return false
+	}
+
+	if _, ok := storer.Indexes()[index]; !ok {
+		return false
+	}
+
+	iBucket := source.Bucket(indexBucketName(storer.Type(), index))
+	if iBucket == nil || iBucket.Sequence() != indexVersion {
+		return false
+	}
+
+	covered := map[string]bool{Key: true}
+	for _, field := range indexFields(storer, index) {
+		structField, ok := query.dataType.FieldByName(field)
+		if !ok || len(structField.Index) != 1 || !coveredKind(structField.Type) {
+			return false
+		}
+		covered[field] = true
+	}
+
+	if field, ok := keyField(query.dataType); ok {
+		covered[field.Name] = true
+	}
+
+	for _, field := range query.selected {
+		if !covered[field] {
+			return false
+		}
+	}
+
+	for _, field := range query.sort {
+		if !covered[field] {
+			return false
+		}
+	}
+
+	for field, criteria := range query.fieldCriteria {
+		if !covered[field] {
+			return false
+		}
+		for _, c := range criteria {
+			if c.operator == fn {
+				return false
+			}
+			if _, ok := c.value.(Field); ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// coveredKind returns whether a value of the type is read back from an index key exactly as it was written
+func coveredKind(tp reflect.Type) bool {
+	switch tp.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// decodeCovered sets the fields of the index on value from the index key.  value is a pointer to a struct
+func (s *Store) decodeCovered(indexKey []byte, fields []string, value reflect.Value) error {
+	values := [][]byte{indexKey}
+	if len(fields) > 1 {
+		var err error
+		values, err = splitIndexKey(indexKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(values) != len(fields) {
+		return errInvalidIndexKey
+	}
+
+	for i, field := range fields {
+		_, err := s.decodeIndexKeyValue(values[i], value.Elem().FieldByName(field))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"testing"
+
+	"github.com/timshannon/bolthold"
+	bolt "go.etcd.io/bbolt"
+)
+
+// corruptRecord overwrites the stored record behind bolthold's back, so only queries that don't decode it still work
+func corruptRecord(t *testing.T, store *bolthold.Store, typeName string, key int) {
+	encoded, err := bolthold.DefaultEncode(key)
+	ok(t, err)
+
+	ok(t, store.Bolt().Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(typeName)).Put(encoded, []byte("not a record"))
+	}))
+}
+
+func TestSelect(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		query := func() *bolthold.Query {
+			return bolthold.Where("Category").Eq("food").And("Color").Ne("").SortBy("Fruit")
+		}
+
+		var all []ItemTest
+		ok(t, store.Find(&all, query()))
+
+		var result []ItemTest
+		ok(t, store.Find(&result, query().Select("Name", "Color")))
+		equals(t, len(all), len(result))
+
+		for i := range all {
+			equals(t, ItemTest{Name: all[i].Name, Color: all[i].Color}, result[i])
+		}
+
+		var pointers []*ItemTest
+		ok(t, store.Find(&pointers, query().Select("Name")))
+		equals(t, all[0].Name, pointers[0].Name)
+		equals(t, "", pointers[0].Category)
+
+		var one ItemTest
+		ok(t, store.FindOne(&one, query().Select("Color")))
+		equals(t, ItemTest{Color: all[0].Color}, one)
+
+		ok(t, store.ForEach(query().Select("Name"), func(record *ItemTest) error {
+			assert(t, record.Name != "", "Selected field wasn't set")
+			equals(t, "", record.Category)
+			return nil
+		}))
+
+		var missing []ItemTest
+		err := store.Find(&missing, query().Select("DoesntExist"))
+		assert(t, err != nil, "No error selecting a field that doesn't exist")
+
+		// writes always use the whole record
+		ok(t, store.UpdateMatching(&ItemTest{}, query().Select("Name"), func(record interface{}) error {
+			record.(*ItemTest).UpdateField = "updated"
+			return nil
+		}))
+
+		result = nil
+		ok(t, store.Find(&result, query()))
+		for i := range all {
+			all[i].UpdateField = "updated"
+		}
+		equals(t, all, result)
+	})
+}
+
+func TestSelectCovered(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertPlanData(t, store)
+
+		query := func() *bolthold.Query {
+			return bolthold.Where("Email").Ge("user9").Select("Email")
+		}
+
+		plan, err := store.Explain(&PlanItem{}, query())
+		ok(t, err)
+		assert(t, plan.Covered, "Query isn't covered by the index")
+
+		var expected []PlanItem
+		ok(t, store.Find(&expected, query()))
+		equals(t, 11, len(expected))
+
+		corruptRecord(t, store, "PlanItem", expected[3].Key)
+
+		// covered queries never decode the records
+		var result []PlanItem
+		ok(t, store.Find(&result, query()))
+		equals(t, expected, result)
+		equals(t, "", result[0].Status)
+		equals(t, 90, result[0].Key)
+
+		count, err := store.Count(&PlanItem{}, query())
+		ok(t, err)
+		equals(t, 11, count)
+
+		result = nil
+		ok(t, store.Find(&result, query().SortBy("Email").Reverse()))
+		equals(t, expected[10], result[0])
+
+		items := bolthold.NewCollection[PlanItem](store)
+		collected, err := items.Find(query())
+		ok(t, err)
+		equals(t, expected, collected)
+
+		// the rest read the records, and fail on the bad record
+		result = nil
+		err = store.Find(&result, bolthold.Where("Email").Ge("user9").Select("Email", "Status"))
+		assert(t, err != nil, "Query that selects a field outside the index didn't read the records")
+
+		result = nil
+		err = store.Find(&result, query().And("Status").Eq("active"))
+		assert(t, err != nil, "Query with criteria outside the index didn't read the records")
+
+		result = nil
+		err = store.Find(&result, bolthold.Where("Email").Ge("user9"))
+		assert(t, err != nil, "Query without selected fields didn't read the records")
+
+		plan, err = store.Explain(&PlanItem{}, query().SortBy("Status"))
+		ok(t, err)
+		assert(t, !plan.Covered, "Query sorted by a field outside the index is covered")
+	})
+}
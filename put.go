@@ -107,26 +107,22 @@ func (s *Store) insert(source BucketSource, key, data interface{}) error {
 	}
 	dataType := dataVal.Type()
 
-	for i := 0; i < dataType.NumField(); i++ {
-		tf := dataType.Field(i)
-		// XXX: should we require standard tag format so we can use StructTag.Lookup()?
-		// XXX: should we use strings.Contains(string(tf.Tag), BoltholdKeyTag) so we don't require proper tags?
-		if _, ok := tf.Tag.Lookup(BoltholdKeyTag); ok {
-			fieldValue := dataVal.Field(i)
-			keyValue := reflect.ValueOf(key)
-			if keyValue.Type() != tf.Type {
-				break
-			}
-			if !fieldValue.CanSet() {
-				break
-			}
-			if !reflect.DeepEqual(fieldValue.Interface(), reflect.Zero(tf.Type).Interface()) {
-				break
-			}
-			fieldValue.Set(keyValue)
-			break
-		}
+	// XXX: should we require standard tag format so we can use StructTag.Lookup()?
+	// XXX: should we use strings.Contains(string(tf.Tag), BoltholdKeyTag) so we don't require proper tags?
+	tf, ok := keyField(dataType)
+	if !ok {
+		return nil
+	}
+
+	fieldValue := dataVal.FieldByIndex(tf.Index)
+	keyValue := reflect.ValueOf(key)
+	if keyValue.Type() != tf.Type || !fieldValue.CanSet() {
+		return nil
+	}
+	if !reflect.DeepEqual(fieldValue.Interface(), reflect.Zero(tf.Type).Interface()) {
+		return nil
 	}
+	fieldValue.Set(keyValue)
 
 	return nil
 }
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
-	"strings"
 )
 
 type record struct {
@@ -18,16 +17,71 @@ type record struct {
 
 func (s *Store) runQuery(source BucketSource, dataType interface{}, query *Query, retrievedKeys keyList, skip int,
 	action func(r *record) error) error {
+	it, err := s.newQueryIterator(source, dataType, query, retrievedKeys, skip)
+	if err != nil {
+		return err
+	}
+	defer it.close()
+
+	for {
+		r, err := it.next()
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			return nil
+		}
+
+		err = action(r)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// queryIterator returns the records that match a query one at a time
+type queryIterator struct {
+	store         *Store
+	source        BucketSource
+	dataType      interface{}
+	query         *Query
+	retrievedKeys keyList
+	skip          int
+
+	iter        *iterator
+	coverFields []string // fields of the index the records are read from, when the index covers the query
+	newKeys     keyList
+	limit       int
+	done        bool
+
+	sorted sortedRecords // sorted queries read all of their records up front
+
+	orIndex int
+	or      *queryIterator
+}
+
+func (s *Store) newQueryIterator(source BucketSource, dataType interface{}, query *Query, retrievedKeys keyList,
+	skip int) (*queryIterator, error) {
 	storer := s.newStorer(dataType)
 
+	it := &queryIterator{
+		store:         s,
+		source:        source,
+		query:         query,
+		retrievedKeys: retrievedKeys,
+		skip:          skip,
+		newKeys:       make(keyList, 0),
+	}
+
 	bkt := source.Bucket([]byte(storer.Type()))
 	if bkt == nil {
 		// if the bucket doesn't exist or is empty then our job is really easy!
-		return nil
+		it.done = true
+		return it, nil
 	}
 
 	if query.index != "" && source.Bucket(indexBucketName(storer.Type(), query.index)) == nil {
-		return fmt.Errorf("The index %s does not exist", query.index)
+		return nil, fmt.Errorf("The index %s does not exist", query.index)
 	}
 
 	tp := dataType
@@ -36,125 +90,238 @@ func (s *Store) runQuery(source BucketSource, dataType interface{}, query *Query
 		tp = reflect.ValueOf(tp).Elem().Interface()
 	}
 
+	it.dataType = tp
 	query.dataType = reflect.TypeOf(tp)
 
-	if len(query.sort) > 0 {
-		return s.runQuerySort(source, dataType, query, action)
+	inOrder, err := s.readsInOrder(source, storer, query)
+	if err != nil {
+		return nil, err
+	}
+	sorted := len(query.sort) > 0 && !inOrder
+
+	// pages of queries with Or'd queries are ordered by key, so they have to be sorted
+	if sorted || ((query.page != nil || query.paged) && (query.pageBefore || len(query.ors) > 0)) {
+		records, err := s.runQuerySort(source, dataType, query)
+		if err != nil {
+			return nil, err
+		}
+		it.sorted = records
+		it.done = true
+		it.orIndex = len(query.ors)
+		return it, nil
+	}
+
+	index, err := s.queryIndex(source, storer, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var after *pagePosition
+	if query.page != nil {
+		after, err = s.pagePosition(source, storer, query, index)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	it.iter = s.newIterator(source, storer, query, index, after)
+	it.limit = query.limit - len(retrievedKeys)
+
+	if !it.iter.scan && s.coversQuery(source, storer, query, index) {
+		it.iter.covered = true
+		it.coverFields = indexFields(storer, index)
 	}
 
-	iter := s.newIterator(source, storer.Type(), query)
+	return it, nil
+}
+
+// next returns the next record that matches the query, or nil if there are no more records
+func (it *queryIterator) next() (*record, error) {
+	if it.sorted != nil {
+		return it.sorted.next()
+	}
+
+	s := it.store
+	query := it.query
+
+	for !it.done {
+		err := query.contextErr()
+		if err != nil {
+			return nil, err
+		}
 
-	newKeys := make(keyList, 0)
+		k, v := it.iter.Next()
+		if k == nil {
+			it.done = true
+
+			if it.iter.Error() != nil {
+				return nil, it.iter.Error()
+			}
 
-	limit := query.limit - len(retrievedKeys)
+			if query.limit != 0 && it.limit == 0 {
+				// limit was reached
+				it.orIndex = len(query.ors)
+			}
+
+			if len(query.ors) > 0 {
+				for i := range it.newKeys {
+					it.retrievedKeys.add(it.newKeys[i])
+				}
+			}
+			break
+		}
 
-	for k, v := iter.Next(); k != nil; k, v = iter.Next() {
-		if len(retrievedKeys) != 0 {
+		if len(it.retrievedKeys) != 0 {
 			// don't check this record if it's already been retrieved
-			if retrievedKeys.in(k) {
+			if it.retrievedKeys.in(k) {
 				continue
 			}
 		}
 
-		val := reflect.New(reflect.TypeOf(tp))
-
-		err := s.decode(v, val.Interface())
+		val, err := it.decode(k, v)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		query.source = source
+		query.source = it.source
 
 		ok, err := query.matchesAllFields(s, k, val, val.Interface())
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		if ok {
-			if skip > 0 {
-				skip--
-				continue
+		if query.profile != nil {
+			query.profile.Scanned++
+		}
+
+		if !ok {
+			continue
+		}
+
+		if query.profile != nil {
+			query.profile.Matched++
+		}
+
+		if it.skip > 0 {
+			it.skip--
+			continue
+		}
+
+		// track that this key's entry has been added to the result list
+		it.newKeys.add(k)
+
+		if query.limit != 0 {
+			it.limit--
+			if it.limit == 0 {
+				// stop reading once the limit is reached, and skip the Or'd queries
+				it.done = true
+				it.orIndex = len(query.ors)
 			}
+		}
 
-			err = action(&record{
-				key:   k,
-				value: val,
-			})
+		return &record{
+			key:   k,
+			value: val,
+		}, nil
+	}
+
+	for it.orIndex < len(query.ors) {
+		if it.or == nil {
+			var err error
+			it.or, err = s.newQueryIterator(it.source, it.dataType, query.ors[it.orIndex], it.retrievedKeys, it.skip)
 			if err != nil {
-				return err
+				return nil, err
 			}
+		}
 
-			// track that this key's entry has been added to the result list
-			newKeys.add(k)
+		r, err := it.or.next()
+		if err != nil {
+			return nil, err
+		}
 
+		if r != nil {
+			// Or'd queries count towards the limit of the query they're Or'd with
 			if query.limit != 0 {
-				limit--
-				if limit == 0 {
-					break
+				it.limit--
+				if it.limit == 0 {
+					it.orIndex = len(query.ors)
 				}
 			}
+			return r, nil
 		}
 
+		it.or = nil
+		it.orIndex++
 	}
 
-	if iter.Error() != nil {
-		return iter.Error()
-	}
+	return nil, nil
+}
 
-	if query.limit != 0 && limit == 0 {
-		return nil
+// close releases the records of a sorted query that haven't been read
+func (it *queryIterator) close() {
+	if it.sorted != nil {
+		_ = it.sorted.close()
+	}
+	if it.or != nil {
+		it.or.close()
 	}
+}
 
-	if len(query.ors) > 0 {
-		for i := range newKeys {
-			retrievedKeys.add(newKeys[i])
-		}
+// decode decodes the record with the key and value read from the iterator.  Records read from an index that covers
+// the query are set from the index key, and only decoded if the index key can't be read into the record's fields
+func (it *queryIterator) decode(k, v []byte) (reflect.Value, error) {
+	val := reflect.New(reflect.TypeOf(it.dataType))
 
-		for i := range query.ors {
-			err := s.runQuery(source, tp, query.ors[i], retrievedKeys, skip, action)
-			if err != nil {
-				return err
-			}
+	if it.iter.covered {
+		err := it.store.decodeCovered(it.iter.indexKey, it.coverFields, val)
+		if err == nil {
+			return val, nil
 		}
+
+		val = reflect.New(reflect.TypeOf(it.dataType))
+		v = it.iter.dataBucket.Get(k)
 	}
 
-	return nil
+	return val, it.store.decode(v, val.Interface())
 }
 
-// runQuerySort runs the query without sort, skip, or limit, then applies them to the entire result set
-func (s *Store) runQuerySort(source BucketSource, dataType interface{}, query *Query, action func(r *record) error) error {
+// runQuerySort runs the query without sort, skip, or limit, then applies them to the entire result set.  Queries
+// with After or Before that can't start reading at their position are run here as well
+func (s *Store) runQuerySort(source BucketSource, dataType interface{}, query *Query) (sortedRecords, error) {
 	// Validate sort fields
 	for _, field := range query.sort {
-		fields := strings.Split(field, ".")
-
-		current := query.dataType
-		for i := range fields {
-			var structField reflect.StructField
-			found := false
-			if current.Kind() == reflect.Ptr {
-				structField, found = current.Elem().FieldByName(fields[i])
-			} else {
-				structField, found = current.FieldByName(fields[i])
-			}
+		if field == Key {
+			continue
+		}
+		_, err := sortFieldType(query.dataType, field)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			if !found {
-				return fmt.Errorf("The field %s does not exist in the type %s", field, query.dataType)
-			}
-			current = structField.Type
+	storer := s.newStorer(dataType)
+
+	index, err := s.queryIndex(source, storer, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var page *pagePosition
+	if query.page != nil {
+		page, err = s.pagePosition(source, storer, query, index)
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	tp := query.dataType
 
 	var keyType reflect.Type
-	var keyField string
+	var keyFieldName string
 
-	for i := 0; i < tp.NumField(); i++ {
-		if strings.Contains(string(tp.Field(i).Tag), BoltholdKeyTag) {
-			keyType = tp.Field(i).Type
-			keyField = tp.Field(i).Name
-			break
-		}
+	if field, ok := keyField(tp); ok {
+		keyType = field.Type
+		keyFieldName = field.Name
 	}
 
 	// Run query without sort, skip or limit
@@ -163,10 +330,36 @@ func (s *Store) runQuerySort(source BucketSource, dataType interface{}, query *Q
 	qCopy.sort = nil
 	qCopy.limit = 0
 	qCopy.skip = 0
+	qCopy.page = nil
+	qCopy.paged = false
+	qCopy.index = index
+	qCopy.indexSet = true
+
+	if len(query.selected) > 0 {
+		// the records are sorted before they're projected, so the sort fields have to be read as well
+		qCopy.selected = append([]string{}, query.selected...)
+		for _, field := range query.sort {
+			if field != Key {
+				qCopy.selected = append(qCopy.selected, field)
+			}
+		}
+	}
 
-	var records []*record
-	err := s.runQuery(source, dataType, &qCopy, nil, 0,
+	collector := s.newRecordCollector(storer, query, index)
+	err = s.runQuery(source, dataType, &qCopy, nil, 0,
 		func(r *record) error {
+			pos, err := s.recordPosition(storer, query, index, r)
+			if err != nil {
+				return err
+			}
+
+			if page != nil {
+				cmp := s.comparePositions(query, pos, page)
+				if (query.pageBefore && cmp >= 0) || (!query.pageBefore && cmp <= 0) {
+					return nil
+				}
+			}
+
 			if keyType != nil {
 				var rowValue reflect.Value
 
@@ -180,85 +373,36 @@ func (s *Store) runQuerySort(source BucketSource, dataType interface{}, query *Q
 				for rowKey.Kind() == reflect.Ptr {
 					rowKey = rowKey.Elem()
 				}
-				err := s.decode(r.key, rowKey.FieldByName(keyField).Addr().Interface())
+				err := s.decode(r.key, rowKey.FieldByName(keyFieldName).Addr().Interface())
 				if err != nil {
 					return err
 				}
 			}
-			records = append(records, r)
 
-			return nil
+			return collector.add(r, pos)
 		})
 
 	if err != nil {
-		return err
-	}
-
-	sort.Slice(records, func(i, j int) bool {
-		for _, field := range query.sort {
-			value, err := fieldValue(records[i].value.Elem(), field)
-			if err != nil {
-				panic(err.Error()) // shouldn't happen due to field check above
-			}
-
-			other, err := fieldValue(records[j].value.Elem(), field)
-			if err != nil {
-				panic(err.Error()) // shouldn't happen due to field check above
-			}
-
-			if query.reverse {
-				value, other = other, value
-			}
-
-			cmp, cerr := compare(value, other)
-			if cerr != nil {
-				// if for some reason there is an error on compare, fallback to a lexicographic compare
-				valS := fmt.Sprintf("%s", value)
-				otherS := fmt.Sprintf("%s", other)
-				if valS < otherS {
-					return true
-				} else if valS == otherS {
-					continue
-				}
-				return false
-			}
-
-			if cmp == -1 {
-				return true
-			} else if cmp == 0 {
-				continue
-			}
-			return false
-		}
-		return false
-	})
-
-	// apply skip and limit
-	limit := query.limit
-	skip := query.skip
-
-	if skip > len(records) {
-		records = records[0:0]
-	} else {
-		records = records[skip:]
-	}
-
-	if limit > 0 && limit <= len(records) {
-		records = records[:limit]
+		_ = collector.close()
+		return nil, err
 	}
 
-	for i := range records {
-		err = action(records[i])
-		if err != nil {
-			return err
-		}
+	records, err := collector.sorted()
+	if err != nil {
+		_ = collector.close()
+		return nil, err
 	}
 
-	return nil
-
+	return records, nil
 }
 
 func (s *Store) findQuery(source BucketSource, result interface{}, query *Query) error {
+	return s.find(source, result, query, nil)
+}
+
+// find runs the query and sets the matching records on the result slice.  If page is not nil, it's set to the
+// positions of the next and previous pages of results
+func (s *Store) find(source BucketSource, result interface{}, query *Query, page *Page) error {
 	if query == nil {
 		query = &Query{}
 	}
@@ -279,42 +423,42 @@ func (s *Store) findQuery(source BucketSource, result interface{}, query *Query)
 	}
 
 	var keyType reflect.Type
-	var keyField string
+	var keyFieldName string
 
-	for i := 0; i < tp.NumField(); i++ {
-		if strings.Contains(string(tp.Field(i).Tag), BoltholdKeyTag) {
-			keyType = tp.Field(i).Type
-			keyField = tp.Field(i).Name
-			break
-		}
+	if field, ok := keyField(tp); ok {
+		keyType = field.Type
+		keyFieldName = field.Name
 	}
 
 	val := reflect.New(tp)
 
-	err := s.runQuery(source, val.Interface(), query, nil, query.skip,
-		func(r *record) error {
-			var rowValue reflect.Value
+	storer := s.newStorer(val.Interface())
+	run := query
+	index := ""
 
-			// FIXME:
-			if elType.Kind() == reflect.Ptr {
-				rowValue = r.value
-			} else {
-				rowValue = r.value.Elem()
-			}
+	if page != nil {
+		var err error
+		index, err = s.queryIndex(source, storer, query)
+		if err != nil {
+			return err
+		}
 
-			if keyType != nil {
-				rowKey := rowValue
-				for rowKey.Kind() == reflect.Ptr {
-					rowKey = rowKey.Elem()
-				}
-				err := s.decode(r.key, rowKey.FieldByName(keyField).Addr().Interface())
-				if err != nil {
-					return err
-				}
-			}
+		// read one more record than the page holds to find out if there's another page
+		qCopy := *query
+		qCopy.index = index
+		qCopy.indexSet = true
+		qCopy.paged = true
+		if qCopy.limit > 0 {
+			qCopy.limit++
+		}
+		run = &qCopy
+	}
 
-			sliceVal = reflect.Append(sliceVal, rowValue)
+	var records []*record
 
+	err := s.runQuery(source, val.Interface(), run, nil, run.skip,
+		func(r *record) error {
+			records = append(records, r)
 			return nil
 		})
 
@@ -322,6 +466,58 @@ func (s *Store) findQuery(source BucketSource, result interface{}, query *Query)
 		return err
 	}
 
+	if page != nil && len(records) != 0 {
+		more := query.limit > 0 && len(records) > query.limit
+		if more && query.pageBefore {
+			records = records[1:]
+		} else if more {
+			records = records[:len(records)-1]
+		}
+
+		if (more && !query.pageBefore) || query.pageBefore {
+			page.Next, err = s.pageToken(storer, query, index, records[len(records)-1])
+			if err != nil {
+				return err
+			}
+		}
+
+		if (more && query.pageBefore) || (query.page != nil && !query.pageBefore) {
+			page.Previous, err = s.pageToken(storer, query, index, records[0])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, r := range records {
+		value, err := query.project(r.value)
+		if err != nil {
+			return err
+		}
+
+		var rowValue reflect.Value
+
+		// FIXME:
+		if elType.Kind() == reflect.Ptr {
+			rowValue = value
+		} else {
+			rowValue = value.Elem()
+		}
+
+		if keyType != nil {
+			rowKey := rowValue
+			for rowKey.Kind() == reflect.Ptr {
+				rowKey = rowKey.Elem()
+			}
+			err := s.decode(r.key, rowKey.FieldByName(keyFieldName).Addr().Interface())
+			if err != nil {
+				return err
+			}
+		}
+
+		sliceVal = reflect.Append(sliceVal, rowValue)
+	}
+
 	resultVal.Elem().Set(sliceVal.Slice(0, sliceVal.Len()))
 
 	return nil
@@ -332,6 +528,8 @@ func (s *Store) deleteQuery(source BucketSource, dataType interface{}, query *Qu
 		query = &Query{}
 	}
 
+	query = query.unselected()
+
 	var records []*record
 
 	err := s.runQuery(source, dataType, query, nil, query.skip,
@@ -349,7 +547,12 @@ func (s *Store) deleteQuery(source BucketSource, dataType interface{}, query *Qu
 
 	b := source.Bucket([]byte(storer.Type()))
 	for i := range records {
-		err := b.Delete(records[i].key)
+		err := query.contextErr()
+		if err != nil {
+			return err
+		}
+
+		err = b.Delete(records[i].key)
 		if err != nil {
 			return err
 		}
@@ -369,6 +572,8 @@ func (s *Store) updateQuery(source BucketSource, dataType interface{}, query *Qu
 		query = &Query{}
 	}
 
+	query = query.unselected()
+
 	var records []*record
 
 	err := s.runQuery(source, dataType, query, nil, query.skip,
@@ -387,10 +592,15 @@ func (s *Store) updateQuery(source BucketSource, dataType interface{}, query *Qu
 	b := source.Bucket([]byte(storer.Type()))
 
 	for i := range records {
+		err := query.contextErr()
+		if err != nil {
+			return err
+		}
+
 		upVal := records[i].value.Interface()
 
 		// delete any existing indexes bad on original value
-		err := s.deleteIndexes(storer, source, records[i].key, upVal)
+		err = s.deleteIndexes(storer, source, records[i].key, upVal)
 		if err != nil {
 			return err
 		}
@@ -426,10 +636,12 @@ func (s *Store) aggregateQuery(source BucketSource, dataType interface{}, query
 		query = &Query{}
 	}
 
+	query = query.unselected()
+
 	var result []*AggregateResult
 
 	if len(groupBy) == 0 {
-		result = append(result, &AggregateResult{})
+		result = append(result, &AggregateResult{store: s})
 	}
 
 	err := s.runQuery(source, dataType, query, nil, query.skip,
@@ -455,7 +667,7 @@ func (s *Store) aggregateQuery(source BucketSource, dataType interface{}, query
 
 			i := sort.Search(len(result), func(i int) bool {
 				for j := range grouping {
-					c, err = compare(result[i].group[j].Interface(), grouping[j].Interface())
+					c, err = s.compare(result[i].group[j].Interface(), grouping[j].Interface())
 					if err != nil {
 						return true
 					}
@@ -486,6 +698,7 @@ func (s *Store) aggregateQuery(source BucketSource, dataType interface{}, query
 			result[i] = &AggregateResult{
 				group:     grouping,
 				reduction: []reflect.Value{r.value},
+				store:     s,
 			}
 
 			return nil
@@ -535,14 +748,11 @@ func (s *Store) findOneQuery(source BucketSource, result interface{}, query *Que
 	structType := resultVal.Elem().Type()
 
 	var keyType reflect.Type
-	var keyField string
+	var keyFieldName string
 
-	for i := 0; i < structType.NumField(); i++ {
-		if strings.Contains(string(structType.Field(i).Tag), BoltholdKeyTag) {
-			keyType = structType.Field(i).Type
-			keyField = structType.Field(i).Name
-			break
-		}
+	if field, ok := keyField(structType); ok {
+		keyType = field.Type
+		keyFieldName = field.Name
 	}
 
 	found := false
@@ -556,12 +766,16 @@ func (s *Store) findOneQuery(source BucketSource, result interface{}, query *Que
 				for rowKey.Kind() == reflect.Ptr {
 					rowKey = rowKey.Elem()
 				}
-				err := s.decode(r.key, rowKey.FieldByName(keyField).Addr().Interface())
+				err := s.decode(r.key, rowKey.FieldByName(keyFieldName).Addr().Interface())
 				if err != nil {
 					return err
 				}
 			}
-			resultVal.Elem().Set(r.value.Elem())
+			value, err := query.project(r.value)
+			if err != nil {
+				return err
+			}
+			resultVal.Elem().Set(value.Elem())
 
 			return nil
 		})
@@ -593,29 +807,31 @@ func (s *Store) forEach(source BucketSource, query *Query, fn interface{}) error
 	dataType := reflect.New(argType).Interface()
 
 	var keyType reflect.Type
-	var keyField string
+	var keyFieldName string
 
-	for i := 0; i < argType.NumField(); i++ {
-		if strings.Contains(string(argType.Field(i).Tag), BoltholdKeyTag) {
-			keyType = argType.Field(i).Type
-			keyField = argType.Field(i).Name
-			break
-		}
+	if field, ok := keyField(argType); ok {
+		keyType = field.Type
+		keyFieldName = field.Name
 	}
 
 	return s.runQuery(source, dataType, query, nil, query.skip, func(r *record) error {
+		value, err := query.project(r.value)
+		if err != nil {
+			return err
+		}
+
 		if keyType != nil {
-			rowKey := r.value
+			rowKey := value
 			for rowKey.Kind() == reflect.Ptr {
 				rowKey = rowKey.Elem()
 			}
-			err := s.decode(r.key, rowKey.FieldByName(keyField).Addr().Interface())
+			err := s.decode(r.key, rowKey.FieldByName(keyFieldName).Addr().Interface())
 			if err != nil {
 				return err
 			}
 		}
 
-		out := fnVal.Call([]reflect.Value{r.value})
+		out := fnVal.Call([]reflect.Value{value})
 		if len(out) != 1 {
 			return fmt.Errorf("foreach function does not return an error")
 		}
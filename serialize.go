@@ -0,0 +1,667 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// ErrNotSerializable is the error returned when marshaling a query that can't be serialized, because it has a
+// MatchFunc criterion, a value of a type that can't be marshaled, or has been paged with After or Before
+var ErrNotSerializable = errors.New("This query cannot be serialized")
+
+// queryJSON is the JSON form of a Query
+type queryJSON struct {
+	Criteria []criterionJSON `json:"criteria,omitempty"`
+	Index    *string         `json:"index,omitempty"`
+	Ors      []*Query        `json:"ors,omitempty"`
+	Groups   []groupJSON     `json:"groups,omitempty"`
+	Sort     []string        `json:"sort,omitempty"`
+	SortDesc []bool          `json:"sortDesc,omitempty"`
+	Nulls    []string        `json:"sortNulls,omitempty"`
+	Reverse  bool            `json:"reverse,omitempty"`
+	Skip     int             `json:"skip,omitempty"`
+	Limit    int             `json:"limit,omitempty"`
+	Select   []string        `json:"select,omitempty"`
+}
+
+// criterionJSON is the JSON form of a Criterion.  An empty Field is the Key
+type criterionJSON struct {
+	Field    string      `json:"field"`
+	Operator string      `json:"op"`
+	Not      bool        `json:"not,omitempty"`
+	Len      bool        `json:"len,omitempty"`
+	Literal  bool        `json:"literal,omitempty"`
+	Value    *valueJSON  `json:"value,omitempty"`
+	Values   []valueJSON `json:"values,omitempty"`
+	Query    *Query      `json:"query,omitempty"`
+}
+
+// groupJSON is the JSON form of a query grouped with AndGroup or Not
+type groupJSON struct {
+	Not   bool   `json:"not,omitempty"`
+	Query *Query `json:"query"`
+}
+
+// valueJSON is the JSON form of a criterion value, along with its type so it unmarshals into the same type
+type valueJSON struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+var nullsNames = map[int]string{
+	nullsDefault: "",
+	nullsFirst:   "first",
+	nullsLast:    "last",
+}
+
+var operatorNames = map[int]string{
+	eq:       "Eq",
+	ne:       "Ne",
+	gt:       "Gt",
+	lt:       "Lt",
+	ge:       "Ge",
+	le:       "Le",
+	in:       "In",
+	re:       "RegExp",
+	fn:       "MatchFunc",
+	isnil:    "IsNil",
+	hk:       "HasKey",
+	hv:       "HasValue",
+	hp:       "HasPrefix",
+	hs:       "HasSuffix",
+	cs:       "ContainsString",
+	ef:       "EqFold",
+	em:       "ElemMatch",
+	contains: "Contains",
+	any:      "ContainsAny",
+	all:      "ContainsAll",
+}
+
+// MarshalJSON implements json.Marshaler.  Every criterion except MatchFunc can be marshaled, along with the
+// query's index, Or'd queries, groups, sort order, skip, limit and selected fields.  Values of criteria must be nil,
+// strings, bools, ints, uints, floats, []byte, time.Time or Field, so they unmarshal back into the same type; anything else
+// returns ErrNotSerializable
+func (q *Query) MarshalJSON() ([]byte, error) {
+	if q.page != nil {
+		return nil, fmt.Errorf("%w: After and Before can't be serialized", ErrNotSerializable)
+	}
+
+	qj := queryJSON{
+		Ors:     q.ors,
+		Sort:    q.sort,
+		Reverse: q.reverse,
+		Skip:    q.skip,
+		Limit:   q.limit,
+		Select:  q.selected,
+	}
+
+	for i := range q.sortDesc {
+		if q.sortDesc[i] {
+			qj.SortDesc = q.sortDesc
+			break
+		}
+	}
+
+	for i := range q.sort {
+		if q.sortNulls(i) != nullsDefault {
+			for k := range q.sort {
+				qj.Nulls = append(qj.Nulls, nullsNames[q.sortNulls(k)])
+			}
+			break
+		}
+	}
+
+	if q.indexSet {
+		index := q.index
+		qj.Index = &index
+	}
+
+	for _, group := range q.groups {
+		qj.Groups = append(qj.Groups, groupJSON{Not: group.negate, Query: group.query})
+	}
+
+	fields := make([]string, 0, len(q.fieldCriteria))
+	for field := range q.fieldCriteria {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		for _, c := range q.fieldCriteria[field] {
+			cj := criterionJSON{
+				Field:    field,
+				Operator: operatorNames[c.operator],
+				Not:      c.negate,
+				Len:      c.length,
+				Literal:  c.literal,
+			}
+
+			switch c.operator {
+			case fn:
+				return nil, fmt.Errorf("%w: the MatchFunc criterion on the field %s can't be serialized",
+					ErrNotSerializable, field)
+			case re:
+				value, err := json.Marshal(c.value.(*regexp.Regexp).String())
+				if err != nil {
+					return nil, err
+				}
+				cj.Value = &valueJSON{Type: "regexp", Value: value}
+			case isnil:
+			case em:
+				cj.Query = c.value.(*Query)
+			case in, any, all:
+				cj.Values = make([]valueJSON, len(c.values))
+				for i := range c.values {
+					value, err := marshalValue(field, c.values[i])
+					if err != nil {
+						return nil, err
+					}
+					cj.Values[i] = *value
+				}
+			default:
+				value, err := marshalValue(field, c.value)
+				if err != nil {
+					return nil, err
+				}
+				cj.Value = value
+			}
+
+			qj.Criteria = append(qj.Criteria, cj)
+		}
+	}
+
+	return json.Marshal(qj)
+}
+
+func marshalValue(field string, value interface{}) (*valueJSON, error) {
+	var tp string
+
+	switch v := value.(type) {
+	case nil:
+		return &valueJSON{Type: "nil"}, nil
+	case time.Time:
+		tp = "time"
+		value = v.Format(time.RFC3339Nano)
+	case Field:
+		tp = "field"
+		value = string(v)
+	case []byte:
+		tp = "bytes"
+	case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		tp = reflect.TypeOf(value).String()
+	default:
+		return nil, fmt.Errorf("%w: the value %v (%T) on the field %s can't be serialized", ErrNotSerializable, value,
+			value, field)
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueJSON{Type: tp, Value: encoded}, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.  The unmarshaled query isn't checked against any type, use Validate
+// before running a query from an untrusted source
+func (q *Query) UnmarshalJSON(data []byte) error {
+	var qj queryJSON
+	err := json.Unmarshal(data, &qj)
+	if err != nil {
+		return err
+	}
+
+	*q = Query{}
+
+	for _, cj := range qj.Criteria {
+		if !startsUpper(cj.Field) {
+			return fmt.Errorf("The first letter of the field %s must be upper-case", cj.Field)
+		}
+
+		c := q.And(cj.Field)
+		if cj.Not {
+			c.Not()
+		}
+		if cj.Len {
+			c.Len()
+		}
+		c.literal = cj.Literal
+
+		var value interface{}
+		if cj.Value != nil {
+			value, err = unmarshalValue(cj.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+		values := make([]interface{}, len(cj.Values))
+		for i := range cj.Values {
+			values[i], err = unmarshalValue(&cj.Values[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		switch cj.Operator {
+		case "Eq":
+			c.Eq(value)
+		case "Ne":
+			c.Ne(value)
+		case "Gt":
+			c.Gt(value)
+		case "Lt":
+			c.Lt(value)
+		case "Ge":
+			c.Ge(value)
+		case "Le":
+			c.Le(value)
+		case "In":
+			c.In(values...)
+		case "RegExp":
+			expression, ok := value.(*regexp.Regexp)
+			if !ok {
+				return fmt.Errorf("The RegExp criterion on the field %s has no regular expression", cj.Field)
+			}
+			c.RegExp(expression)
+		case "IsNil":
+			c.IsNil()
+		case "HasKey":
+			c.HasKey(value)
+		case "HasValue":
+			c.HasValue(value)
+		case "HasPrefix", "HasSuffix", "ContainsString", "EqFold":
+			str, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("The %s criterion on the field %s has no string value", cj.Operator, cj.Field)
+			}
+			switch cj.Operator {
+			case "HasPrefix":
+				c.HasPrefix(str)
+			case "HasSuffix":
+				c.HasSuffix(str)
+			case "ContainsString":
+				c.ContainsString(str)
+			default:
+				c.EqFold(str)
+			}
+		case "Contains":
+			c.Contains(value)
+		case "ContainsAny":
+			c.ContainsAny(values...)
+		case "ContainsAll":
+			c.ContainsAll(values...)
+		case "ElemMatch":
+			if cj.Query == nil {
+				return fmt.Errorf("The ElemMatch criterion on the field %s has no query", cj.Field)
+			}
+			c.ElemMatch(cj.Query)
+		default:
+			return fmt.Errorf("Invalid operator %q on the field %s", cj.Operator, cj.Field)
+		}
+	}
+
+	if qj.Index != nil {
+		q.Index(*qj.Index)
+	}
+
+	for _, group := range qj.Groups {
+		if group.Query == nil {
+			return errors.New("A query group has no query")
+		}
+		q.groups = append(q.groups, queryGroup{query: group.Query, negate: group.Not})
+	}
+
+	for i := range qj.Ors {
+		if qj.Ors[i] == nil {
+			continue
+		}
+		if qj.Ors[i].skip != 0 || qj.Ors[i].limit != 0 {
+			return errors.New("Or'd queries cannot contain skip or limit values")
+		}
+		q.Or(qj.Ors[i])
+	}
+
+	for i, field := range qj.Sort {
+		if i < len(qj.SortDesc) && qj.SortDesc[i] {
+			q.SortByDesc(field)
+		} else {
+			q.SortBy(field)
+		}
+	}
+
+	for i := range qj.Nulls {
+		if i >= len(q.sort) {
+			return errors.New("A query has more sortNulls values than sort fields")
+		}
+		switch qj.Nulls[i] {
+		case "":
+		case "first":
+			q.sortNull[i] = nullsFirst
+		case "last":
+			q.sortNull[i] = nullsLast
+		default:
+			return fmt.Errorf("Invalid sortNulls value %q, must be first, last or empty", qj.Nulls[i])
+		}
+	}
+
+	if qj.Reverse {
+		q.Reverse()
+	}
+
+	if qj.Skip < 0 || qj.Limit < 0 {
+		return errors.New("Skip and Limit must be positive numbers")
+	}
+	q.Skip(qj.Skip)
+	q.Limit(qj.Limit)
+
+	for _, field := range qj.Select {
+		if !startsUpper(field) {
+			return fmt.Errorf("The first letter of the selected field %s must be upper-case", field)
+		}
+	}
+	q.Select(qj.Select...)
+
+	return nil
+}
+
+func unmarshalValue(vj *valueJSON) (interface{}, error) {
+	var value interface{}
+
+	switch vj.Type {
+	case "nil":
+		return nil, nil
+	case "time":
+		var text string
+		err := json.Unmarshal(vj.Value, &text)
+		if err != nil {
+			return nil, err
+		}
+		return time.Parse(time.RFC3339Nano, text)
+	case "field":
+		var field string
+		err := json.Unmarshal(vj.Value, &field)
+		if err != nil {
+			return nil, err
+		}
+		if !startsUpper(field) {
+			return nil, fmt.Errorf("The first letter of the field %s must be upper-case", field)
+		}
+		return Field(field), nil
+	case "regexp":
+		var expression string
+		err := json.Unmarshal(vj.Value, &expression)
+		if err != nil {
+			return nil, err
+		}
+		return regexp.Compile(expression)
+	case "bytes":
+		value = new([]byte)
+	case "string":
+		value = new(string)
+	case "bool":
+		value = new(bool)
+	case "int":
+		value = new(int)
+	case "int8":
+		value = new(int8)
+	case "int16":
+		value = new(int16)
+	case "int32":
+		value = new(int32)
+	case "int64":
+		value = new(int64)
+	case "uint":
+		value = new(uint)
+	case "uint8":
+		value = new(uint8)
+	case "uint16":
+		value = new(uint16)
+	case "uint32":
+		value = new(uint32)
+	case "uint64":
+		value = new(uint64)
+	case "float32":
+		value = new(float32)
+	case "float64":
+		value = new(float64)
+	default:
+		return nil, fmt.Errorf("Invalid value type %q", vj.Type)
+	}
+
+	err := json.Unmarshal(vj.Value, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return reflect.ValueOf(value).Elem().Interface(), nil
+}
+
+// GobEncode implements gob.GobEncoder, using the same encoding as MarshalJSON
+func (q *Query) GobEncode() ([]byte, error) {
+	return q.MarshalJSON()
+}
+
+// GobDecode implements gob.GobDecoder
+func (q *Query) GobDecode(data []byte) error {
+	return q.UnmarshalJSON(data)
+}
+
+// Validate checks the query against the passed in data type, and returns an error if it uses fields that don't
+// exist in the type, or criteria and values that can't be used with the fields they test, such as IsNil on a
+// field that can never be nil.  Queries built in code panic or return errors when they run, but queries that have
+// been unmarshaled, or parsed from text, can be checked before they're run.  Index names are checked when the query
+// is run.
+func (q *Query) Validate(dataType interface{}) error {
+	tp := reflect.TypeOf(dataType)
+	for tp != nil && tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	if tp == nil || tp.Kind() != reflect.Struct {
+		return fmt.Errorf("Queries can only be validated against structs, not %v", reflect.TypeOf(dataType))
+	}
+
+	fields := make([]string, 0, len(q.fieldCriteria))
+	for field := range q.fieldCriteria {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		if field == Key {
+			// the Key's type is only known when it's decoded
+			continue
+		}
+
+		fieldType, err := sortFieldType(tp, field)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range q.fieldCriteria[field] {
+			err = c.validate(tp, field, fieldType)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, field := range q.sort {
+		if field == Key {
+			continue
+		}
+		_, err := sortFieldType(tp, field)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, field := range q.selected {
+		_, err := sortFieldType(tp, field)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range q.ors {
+		err := q.ors[i].Validate(dataType)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, group := range q.groups {
+		err := group.query.Validate(dataType)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate checks the criterion against the type of the field it tests
+func (c *Criterion) validate(dataType reflect.Type, field string, fieldType reflect.Type) error {
+	valueType := fieldType
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+
+	if c.length {
+		switch valueType.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
+		default:
+			return fmt.Errorf("Len can't be used on the field %s, which is a %s", field, fieldType)
+		}
+		// lengths are ints
+		fieldType = reflect.TypeOf(0)
+		valueType = fieldType
+	}
+
+	values := c.values
+
+	switch c.operator {
+	case eq, ne, gt, lt, ge, le:
+		values = []interface{}{c.value}
+	case isnil:
+		switch fieldType.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Chan, reflect.Func:
+			return nil
+		}
+		return fmt.Errorf("The field %s is a %s, which can never be nil", field, fieldType)
+	case hk:
+		if valueType.Kind() != reflect.Map {
+			return fmt.Errorf("HasKey can't be used on the field %s, which is a %s and not a map", field, fieldType)
+		}
+		if c.value == nil || !reflect.TypeOf(c.value).AssignableTo(valueType.Key()) {
+			return fmt.Errorf("The key %v (%T) can't be a key of the field %s, which is a %s", c.value, c.value,
+				field, fieldType)
+		}
+		return nil
+	case hv:
+		if valueType.Kind() != reflect.Map {
+			return fmt.Errorf("HasValue can't be used on the field %s, which is a %s and not a map", field, fieldType)
+		}
+		values = []interface{}{c.value}
+		valueType = valueType.Elem()
+		for valueType.Kind() == reflect.Ptr {
+			valueType = valueType.Elem()
+		}
+	case em:
+		if c.length {
+			return fmt.Errorf("ElemMatch can't be used on the length of the field %s", field)
+		}
+		switch valueType.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+		default:
+			return fmt.Errorf("ElemMatch can't be used on the field %s, which is a %s and not a slice, array or map",
+				field, fieldType)
+		}
+
+		elemType := valueType.Elem()
+		for elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+		if elemType.Kind() != reflect.Struct {
+			// criteria on the Key of elements that aren't structs are checked when they're compared
+			return nil
+		}
+		return c.value.(*Query).Validate(reflect.New(elemType).Interface())
+	case hp, hs, cs, ef:
+		if valueType.Kind() != reflect.String {
+			return fmt.Errorf("%s can't be used on the field %s, which is a %s and not a string",
+				operatorNames[c.operator], field, fieldType)
+		}
+		return nil
+	case contains:
+		values = []interface{}{c.value}
+		fallthrough
+	case any, all:
+		if valueType.Kind() == reflect.Slice || valueType.Kind() == reflect.Array {
+			valueType = valueType.Elem()
+			for valueType.Kind() == reflect.Ptr {
+				valueType = valueType.Elem()
+			}
+		}
+	case in:
+	default:
+		return nil
+	}
+
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+
+		if other, ok := value.(Field); ok {
+			_, err := sortFieldType(dataType, string(other))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !strictCompare(valueType) {
+			continue
+		}
+
+		if c.literal && isNumber(reflect.TypeOf(value)) && isNumber(valueType) {
+			// numbers parsed from text are compared with every type of number
+			continue
+		}
+
+		tp := reflect.TypeOf(value)
+		for tp.Kind() == reflect.Ptr {
+			tp = tp.Elem()
+		}
+		if tp != valueType {
+			return fmt.Errorf("The value %v (%T) can't be compared with the field %s, which is a %s", value, value,
+				field, fieldType)
+		}
+	}
+
+	return nil
+}
+
+// strictCompare returns whether the type is only ever compared against values of exactly the same type
+func strictCompare(tp reflect.Type) bool {
+	switch tp {
+	case reflect.TypeOf(time.Time{}), reflect.TypeOf(big.Float{}), reflect.TypeOf(big.Int{}),
+		reflect.TypeOf(big.Rat{}), reflect.TypeOf(""), reflect.TypeOf(0), reflect.TypeOf(int8(0)),
+		reflect.TypeOf(int16(0)), reflect.TypeOf(int32(0)), reflect.TypeOf(int64(0)), reflect.TypeOf(uint(0)),
+		reflect.TypeOf(uint8(0)), reflect.TypeOf(uint16(0)), reflect.TypeOf(uint32(0)), reflect.TypeOf(uint64(0)),
+		reflect.TypeOf(float32(0)), reflect.TypeOf(float64(0)):
+		return true
+	}
+	return false
+}
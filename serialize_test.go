@@ -0,0 +1,137 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/timshannon/bolthold"
+)
+
+type SavedFilter struct {
+	Name  string
+	Query *bolthold.Query
+}
+
+func serializeQueries() []*bolthold.Query {
+	return []*bolthold.Query{
+		bolthold.Where("Category").Eq("food").And("Name").RegExp(regexp.MustCompile("^A")).
+			Or(bolthold.Where("Tags").Contains("red")).SortBy("Name").Reverse().Limit(3),
+		bolthold.Where(bolthold.Key).Ge(3).And(bolthold.Key).Lt(10).And("Color").Ne("").SortBy("Name").Skip(2),
+		bolthold.Where("Color").In("red", "blue").And("Category").Not().Eq("food").Index("Category"),
+		bolthold.Where("Category").Not().In("food").And("MapVal").Not().IsNil(),
+		bolthold.Where("Tags").ContainsAny("red", "blue").Or(bolthold.Where("Tags").ContainsAll("fast", "car")),
+		bolthold.Where("MapVal").HasKey("test").Or(bolthold.Where("Fruit").Eq(bolthold.Field("Color"))),
+		bolthold.Where("Category").Eq("vehicle").Select("Name", "Category"),
+		bolthold.Where("Category").Ne("vehicle").SortBy("Category").SortByDesc("Name"),
+		bolthold.Where("Created").Lt(time.Now().Add(time.Hour)).And("ID").Ne(3).Index(bolthold.Key),
+	}
+}
+
+func TestQueryJSON(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		for _, query := range serializeQueries() {
+			data, err := json.Marshal(query)
+			ok(t, err)
+
+			unmarshaled := &bolthold.Query{}
+			ok(t, json.Unmarshal(data, unmarshaled))
+			equals(t, query.String(), unmarshaled.String())
+
+			var want, got []ItemTest
+			ok(t, store.Find(&want, query))
+			ok(t, store.Find(&got, unmarshaled))
+			equals(t, want, got)
+		}
+
+		data, err := json.Marshal(bolthold.Where("Value").Eq(uint16(3)).And("Other").In(float32(1.5), nil))
+		ok(t, err)
+		unmarshaled := &bolthold.Query{}
+		ok(t, json.Unmarshal(data, unmarshaled))
+		equals(t, `Other IN (1.5, nil) AND Value == 3`, unmarshaled.String())
+	})
+}
+
+func TestQueryJSONErrors(t *testing.T) {
+	_, err := json.Marshal(bolthold.Where("Name").MatchFunc(func(ra *bolthold.RecordAccess) (bool, error) {
+		return true, nil
+	}))
+	assert(t, errors.Is(err, bolthold.ErrNotSerializable), "MatchFunc query was marshaled: %v", err)
+
+	_, err = json.Marshal(bolthold.Where("Name").Eq(struct{ Value int }{}))
+	assert(t, errors.Is(err, bolthold.ErrNotSerializable), "Query with a struct value was marshaled: %v", err)
+
+	_, err = json.Marshal(bolthold.Where("Name").Eq("a").Or(bolthold.Where("Name").MatchFunc(
+		func(ra *bolthold.RecordAccess) (bool, error) {
+			return true, nil
+		})))
+	assert(t, errors.Is(err, bolthold.ErrNotSerializable), "Or'd MatchFunc query was marshaled: %v", err)
+
+	for _, data := range []string{
+		`{"criteria":[{"field":"name","op":"Eq","value":{"type":"string","value":"a"}}]}`,
+		`{"criteria":[{"field":"Name","op":"Matches","value":{"type":"string","value":"a"}}]}`,
+		`{"criteria":[{"field":"Name","op":"Eq","value":{"type":"complex128","value":1}}]}`,
+		`{"criteria":[{"field":"Name","op":"RegExp","value":{"type":"regexp","value":"("}}]}`,
+		`{"criteria":[{"field":"Name","op":"Eq","value":{"type":"int","value":"a"}}]}`,
+		`{"ors":[{"limit":1}]}`,
+		`{"sort":["Name"],"sortDesc":"x"}`,
+		`{"limit":-1}`,
+	} {
+		err = json.Unmarshal([]byte(data), &bolthold.Query{})
+		assert(t, err != nil, "No error unmarshaling %s", data)
+	}
+}
+
+func TestQueryGob(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		query := bolthold.Where("Category").Eq("food").And("Color").In("red", "yellow").SortBy("Name")
+		ok(t, store.Insert("red food", &SavedFilter{Name: "Red Food", Query: query}))
+
+		saved := &SavedFilter{}
+		ok(t, store.Get("red food", saved))
+		equals(t, query.String(), saved.Query.String())
+
+		var want, got []ItemTest
+		ok(t, store.Find(&want, query))
+		ok(t, store.Find(&got, saved.Query))
+		equals(t, want, got)
+	})
+}
+
+func TestQueryValidate(t *testing.T) {
+	for _, query := range serializeQueries() {
+		ok(t, query.Validate(&ItemTest{}))
+	}
+
+	invalid := []*bolthold.Query{
+		bolthold.Where("DoesntExist").Eq("a"),
+		bolthold.Where("Name").Eq(1),
+		bolthold.Where("ID").In(1, int64(2)),
+		bolthold.Where("Name").IsNil(),
+		bolthold.Where("Name").HasKey("a"),
+		bolthold.Where("MapVal").HasKey(1),
+		bolthold.Where("Tags").Contains(1),
+		bolthold.Where("Name").Eq(bolthold.Field("DoesntExist")),
+		bolthold.Where("Name").Eq("a").Or(bolthold.Where("ID").Gt("a")),
+		bolthold.Where("Name").Eq("a").SortBy("DoesntExist"),
+		bolthold.Where("Name").Eq("a").Select("DoesntExist"),
+	}
+
+	for _, query := range invalid {
+		err := query.Validate(&ItemTest{})
+		assert(t, err != nil, "No error validating %s", query)
+	}
+
+	err := bolthold.Where("Name").Eq("a").Validate("not a struct")
+	assert(t, err != nil, "No error validating against a string")
+}
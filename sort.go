@@ -0,0 +1,598 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// sortedRecords returns the records of a sorted query in order
+type sortedRecords interface {
+	// next returns the next record, or nil if there are no more records
+	next() (*record, error)
+	close() error
+}
+
+// recordCollector collects the records of a sorted query as they're read, and returns them in order with the
+// query's skip and limit applied
+type recordCollector interface {
+	add(r *record, pos *pagePosition) error
+	sorted() (sortedRecords, error)
+	close() error
+}
+
+// newRecordCollector returns the collector for the records of the query.  Queries with a limit only hold onto the
+// records that can fall within it, and sorted queries without a limit spill their records to a temporary file once
+// there are more than the store's sort buffer size
+func (s *Store) newRecordCollector(storer Storer, query *Query, index string) recordCollector {
+	sorting := len(query.sort) > 0 || len(query.ors) > 0
+
+	if sorting && query.limit > 0 {
+		return &recordHeap{
+			recordSort: recordSort{store: s, query: query},
+			size:       query.skip + query.limit,
+		}
+	}
+
+	list := &recordList{
+		recordSort: recordSort{store: s, query: query},
+		sorting:    sorting,
+	}
+
+	if sorting && s.sortBuffer > 0 {
+		return &spillSort{
+			recordList: list,
+			store:      s,
+			storer:     storer,
+			index:      index,
+		}
+	}
+
+	return list
+}
+
+// recordSortCheck is how many comparisons are made between checks of the query's context while sorting
+const recordSortCheck = 64
+
+// recordSort sorts records by their position in the results of the query.  If the query's context is cancelled
+// while sorting, err is set and the rest of the comparisons are skipped
+type recordSort struct {
+	store     *Store
+	query     *Query
+	records   []*record
+	positions []*pagePosition
+	compares  int
+	err       error
+}
+
+func (r *recordSort) Len() int { return len(r.records) }
+
+func (r *recordSort) Less(i, j int) bool {
+	return r.compare(r.positions[i], r.positions[j]) < 0
+}
+
+func (r *recordSort) Swap(i, j int) {
+	r.records[i], r.records[j] = r.records[j], r.records[i]
+	r.positions[i], r.positions[j] = r.positions[j], r.positions[i]
+}
+
+func (r *recordSort) compare(a, b *pagePosition) int {
+	if r.err != nil {
+		return 0
+	}
+
+	r.compares++
+	if r.compares%recordSortCheck == 0 {
+		r.err = r.query.contextErr()
+	}
+
+	return r.store.comparePositions(r.query, a, b)
+}
+
+func (r *recordSort) sort() error {
+	sort.Sort(r)
+	return r.err
+}
+
+// recordList holds every record of the query in memory
+type recordList struct {
+	recordSort
+	sorting bool
+}
+
+func (l *recordList) add(r *record, pos *pagePosition) error {
+	l.records = append(l.records, r)
+	l.positions = append(l.positions, pos)
+	return nil
+}
+
+func (l *recordList) sorted() (sortedRecords, error) {
+	if l.sorting {
+		err := l.sort()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	records := skipRecords(l.query, l.records)
+	return &records, nil
+}
+
+func (l *recordList) close() error {
+	l.records = nil
+	l.positions = nil
+	return nil
+}
+
+// skipRecords applies the skip and limit of the query to its sorted records
+func skipRecords(query *Query, records []*record) recordSlice {
+	limit := query.limit
+	skip := query.skip
+
+	if skip > len(records) {
+		records = records[0:0]
+	} else if query.pageBefore {
+		records = records[:len(records)-skip]
+	} else {
+		records = records[skip:]
+	}
+
+	if limit > 0 && limit <= len(records) {
+		if query.pageBefore {
+			records = records[len(records)-limit:]
+		} else {
+			records = records[:limit]
+		}
+	}
+
+	return recordSlice(records)
+}
+
+// recordSlice returns records that are already in order
+type recordSlice []*record
+
+func (s *recordSlice) next() (*record, error) {
+	if len(*s) == 0 {
+		return nil, nil
+	}
+	r := (*s)[0]
+	*s = (*s)[1:]
+	return r, nil
+}
+
+func (s *recordSlice) close() error {
+	*s = nil
+	return nil
+}
+
+// recordHeap holds the records that fall within the skip and limit of a query.  The root of the heap is the record
+// that would be dropped first: the last record when reading forward, and the first when reading before a page
+type recordHeap struct {
+	recordSort
+	size int
+}
+
+func (h *recordHeap) Less(i, j int) bool {
+	cmp := h.compare(h.positions[i], h.positions[j])
+	if h.query.pageBefore {
+		return cmp < 0
+	}
+	return cmp > 0
+}
+
+func (h *recordHeap) Push(x interface{}) {
+	item := x.(*heapRecord)
+	h.records = append(h.records, item.record)
+	h.positions = append(h.positions, item.pos)
+}
+
+func (h *recordHeap) Pop() interface{} {
+	last := len(h.records) - 1
+	item := &heapRecord{record: h.records[last], pos: h.positions[last]}
+	h.records = h.records[:last]
+	h.positions = h.positions[:last]
+	return item
+}
+
+type heapRecord struct {
+	record *record
+	pos    *pagePosition
+}
+
+func (h *recordHeap) add(r *record, pos *pagePosition) error {
+	if len(h.records) < h.size {
+		heap.Push(h, &heapRecord{record: r, pos: pos})
+		return h.err
+	}
+
+	// replace the root if the record comes before it
+	cmp := h.compare(pos, h.positions[0])
+	if (h.query.pageBefore && cmp > 0) || (!h.query.pageBefore && cmp < 0) {
+		h.records[0] = r
+		h.positions[0] = pos
+		heap.Fix(h, 0)
+	}
+
+	return h.err
+}
+
+func (h *recordHeap) sorted() (sortedRecords, error) {
+	err := h.sort()
+	if err != nil {
+		return nil, err
+	}
+
+	records := skipRecords(h.query, h.records)
+	return &records, nil
+}
+
+func (h *recordHeap) close() error {
+	h.records = nil
+	h.positions = nil
+	return nil
+}
+
+// spillSort sorts the records of a query in runs of the store's sort buffer size.  Once the buffer is full, its
+// records are sorted and written to a bucket in a temporary file, and the runs are merged as the records are read
+type spillSort struct {
+	*recordList
+	store  *Store
+	storer Storer
+	index  string
+
+	filename string
+	db       *bolt.DB
+	tx       *bolt.Tx
+	runs     int
+	count    int // total records added
+}
+
+// spillRecord is a record as it's written to a sort run
+type spillRecord struct {
+	Key   []byte
+	Value []byte
+}
+
+func (s *spillSort) add(r *record, pos *pagePosition) error {
+	s.count++
+	err := s.recordList.add(r, pos)
+	if err != nil {
+		return err
+	}
+
+	if len(s.records) < s.store.sortBuffer {
+		return nil
+	}
+	return s.spill()
+}
+
+// spill writes the sorted records in the buffer to a new run
+func (s *spillSort) spill() error {
+	err := s.sort()
+	if err != nil {
+		return err
+	}
+
+	if s.db == nil {
+		file, err := os.CreateTemp("", "bolthold-sort-")
+		if err != nil {
+			return err
+		}
+		s.filename = file.Name()
+		err = file.Close()
+		if err != nil {
+			return err
+		}
+
+		s.db, err = bolt.Open(s.filename, 0600, &bolt.Options{NoSync: true, NoFreelistSync: true})
+		if err != nil {
+			_ = os.Remove(s.filename)
+			return err
+		}
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucket(runKey(s.runs))
+		if err != nil {
+			return err
+		}
+
+		for i := range s.records {
+			value, err := s.store.encode(s.records[i].value.Interface())
+			if err != nil {
+				return err
+			}
+
+			data, err := s.store.encode(&spillRecord{Key: s.records[i].key, Value: value})
+			if err != nil {
+				return err
+			}
+
+			err = bkt.Put(runKey(i), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.runs++
+	s.records = s.records[:0]
+	s.positions = s.positions[:0]
+	return nil
+}
+
+func (s *spillSort) sorted() (sortedRecords, error) {
+	if s.runs == 0 {
+		// everything fit in the buffer
+		return s.recordList.sorted()
+	}
+
+	if len(s.records) > 0 {
+		err := s.spill()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	s.tx, err = s.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &runMerge{
+		recordSort: recordSort{store: s.store, query: s.query},
+		spill:      s,
+		count:      s.count - s.query.skip,
+	}
+	if !s.query.pageBefore {
+		// reading before a page drops the last records instead
+		m.skip = s.query.skip
+	}
+
+	for i := 0; i < s.runs; i++ {
+		c := s.tx.Bucket(runKey(i)).Cursor()
+		_, v := c.First()
+		err = m.push(c, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// readRecord decodes a record written to a run
+func (s *spillSort) readRecord(data []byte) (*record, *pagePosition, error) {
+	spilled := &spillRecord{}
+	err := s.store.decode(data, spilled)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := &record{
+		key:   spilled.Key,
+		value: reflect.New(s.query.dataType),
+	}
+
+	err = s.store.decode(spilled.Value, r.value.Interface())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pos, err := s.store.recordPosition(s.storer, s.query, s.index, r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return r, pos, nil
+}
+
+func (s *spillSort) close() error {
+	s.recordList.close()
+
+	if s.db == nil {
+		return nil
+	}
+
+	if s.tx != nil {
+		_ = s.tx.Rollback()
+		s.tx = nil
+	}
+
+	err := s.db.Close()
+	s.db = nil
+	if err != nil {
+		return err
+	}
+	return os.Remove(s.filename)
+}
+
+func runKey(i int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(i))
+	return key
+}
+
+// runMerge merges the sorted runs of a spillSort.  The heap holds the next record of each run
+type runMerge struct {
+	recordSort
+	spill   *spillSort
+	cursors []*bolt.Cursor
+	skip    int // records left to skip
+	count   int // records left to return
+}
+
+func (m *runMerge) Swap(i, j int) {
+	m.recordSort.Swap(i, j)
+	m.cursors[i], m.cursors[j] = m.cursors[j], m.cursors[i]
+}
+
+func (m *runMerge) Push(x interface{}) {
+	item := x.(*heapRecord)
+	m.records = append(m.records, item.record)
+	m.positions = append(m.positions, item.pos)
+}
+
+func (m *runMerge) Pop() interface{} {
+	last := len(m.records) - 1
+	m.records = m.records[:last]
+	m.positions = m.positions[:last]
+	m.cursors = m.cursors[:last]
+	return nil
+}
+
+// push adds the run's record to the heap, unless the run has no more records
+func (m *runMerge) push(c *bolt.Cursor, data []byte) error {
+	if data == nil {
+		return nil
+	}
+
+	r, pos, err := m.spill.readRecord(data)
+	if err != nil {
+		return err
+	}
+
+	m.cursors = append(m.cursors, c)
+	heap.Push(m, &heapRecord{record: r, pos: pos})
+	return m.err
+}
+
+func (m *runMerge) next() (*record, error) {
+	for m.count > 0 && len(m.records) > 0 {
+		err := m.query.contextErr()
+		if err != nil {
+			return nil, err
+		}
+
+		r := m.records[0]
+		_, v := m.cursors[0].Next()
+		if v == nil {
+			heap.Pop(m)
+		} else {
+			r2, pos, err := m.spill.readRecord(v)
+			if err != nil {
+				return nil, err
+			}
+			m.records[0] = r2
+			m.positions[0] = pos
+			heap.Fix(m, 0)
+		}
+		if m.err != nil {
+			return nil, m.err
+		}
+
+		if m.skip > 0 {
+			m.skip--
+			continue
+		}
+
+		m.count--
+		return r, nil
+	}
+
+	return nil, m.close()
+}
+
+func (m *runMerge) close() error {
+	m.records = nil
+	m.positions = nil
+	m.cursors = nil
+	m.count = 0
+	return m.spill.close()
+}
+
+// sortIndex returns the index a query sorted by a single field can stream its records from in sorted order, or an
+// empty string if it has to sort them in memory.  The index has to be on just that field, with keys that preserve
+// the order of its values, and the field can't be nil, because records with nil values aren't in the index.  The
+// index is only used in place of the index picked from the query's criteria if that one would read every record
+func (s *Store) sortIndex(source BucketSource, storer Storer, query *Query, index string) string {
+	if len(query.sort) != 1 || query.sort[0] == Key || len(query.ors) != 0 || query.page != nil || query.paged ||
+		query.dataType == nil {
+		// pages are sorted in memory, and FindPage picks their index before the query's type is known
+		return ""
+	}
+
+	if _, ok := storer.(*anonStorer); !ok {
+		// custom indexes may not hold the values of the field
+		return ""
+	}
+
+	field := query.sort[0]
+	tp, err := sortFieldType(query.dataType, field)
+	if err != nil {
+		return ""
+	}
+
+	switch tp.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+		return ""
+	}
+	if tp.Implements(reflect.TypeOf((*Comparer)(nil)).Elem()) || s.hasComparer(tp) {
+		// the index isn't in the order of the values' Compare method, or their registered comparer
+		return ""
+	}
+
+	for name, idx := range storer.Indexes() {
+		if len(idx.Fields) != 1 || idx.Fields[0] != field {
+			continue
+		}
+
+		if index != Key && index != name {
+			return ""
+		}
+
+		iBucket := source.Bucket(indexBucketName(storer.Type(), name))
+		if iBucket == nil || iBucket.Sequence() != indexVersion {
+			return ""
+		}
+
+		c := iBucket.Cursor()
+		first, _ := c.First()
+		last, _ := c.Last()
+		if first != nil && (!isOrderedIndexKey(first) || !isOrderedIndexKey(last) || first[0] != last[0]) {
+			return ""
+		}
+
+		return name
+	}
+
+	return ""
+}
+
+// readsInOrder returns whether the records of a sorted query are read in the order they're sorted, either because
+// they're only sorted by Key and are read in key order, or because they're read from the index of their sort field
+func (s *Store) readsInOrder(source BucketSource, storer Storer, query *Query) (bool, error) {
+	if len(query.sort) == 0 {
+		return false, nil
+	}
+
+	index, err := s.queryIndex(source, storer, query)
+	if err != nil {
+		return false, err
+	}
+
+	if query.keyOrdered() {
+		return index == Key, nil
+	}
+
+	return index != Key && s.sortIndex(source, storer, query, index) == index, nil
+}
+
+// sortDirection returns whether the query reads the index backwards, and whether the record keys within each index
+// value are read backwards
+func sortDirection(query *Query) (desc, keysDesc bool) {
+	return query.sortDesc[0] != query.reverse, query.reverse
+}
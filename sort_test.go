@@ -6,9 +6,13 @@ package bolthold_test
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 
 	"github.com/timshannon/bolthold"
+	bolt "go.etcd.io/bbolt"
 )
 
 var sortTests = []test{
@@ -232,16 +236,63 @@ func TestSortedDeleteMatching(t *testing.T) {
 	}
 }
 
+// resultKeys returns the keys of the results, in order
+func resultKeys(result []ItemTest) []int {
+	keys := []int{}
+	for i := range result {
+		keys = append(keys, result[i].Key)
+	}
+	return keys
+}
+
 func TestSortOnKey(t *testing.T) {
 	testWrap(t, func(store *bolthold.Store, t *testing.T) {
-		defer func() {
-			if r := recover(); r == nil {
-				t.Fatalf("Running Sort on Key field did not panic!")
-			}
-		}()
+		insertTestData(t, store)
 
+		query := bolthold.Where("Category").Eq("animal").SortBy(bolthold.Key)
 		var result []ItemTest
-		_ = store.Find(&result, bolthold.Where("Name").Eq("blah").SortBy(bolthold.Key))
+		ok(t, store.Find(&result, query))
+		equals(t, []int{2, 5, 8, 9, 13, 14, 16}, resultKeys(result))
+
+		plan, err := store.Explain(&ItemTest{}, bolthold.Where(bolthold.Key).Gt(3).SortBy(bolthold.Key))
+		ok(t, err)
+		equals(t, bolthold.Key, plan.Index)
+		assert(t, !plan.Sorted, "Query sorted by Key was sorted in memory")
+
+		result = nil
+		ok(t, store.Find(&result, bolthold.Where("Category").Eq("animal").SortByDesc(bolthold.Key)))
+		equals(t, []int{16, 14, 13, 9, 8, 5, 2}, resultKeys(result))
+
+		result = nil
+		ok(t, store.Find(&result, bolthold.Where("Category").Eq("animal").SortBy(bolthold.Key).Reverse().Limit(2)))
+		equals(t, []int{16, 14}, resultKeys(result))
+	})
+}
+
+func TestSortDirection(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		var result []ItemTest
+		ok(t, store.Find(&result, bolthold.Where("ID").In(8, 3, 13).SortBy("Category").SortByDesc("Name")))
+		equals(t, 4, len(result))
+		for i := 1; i < len(result); i++ {
+			prev, cur := result[i-1], result[i]
+			assert(t, prev.Category < cur.Category || (prev.Category == cur.Category && prev.Name >= cur.Name),
+				"Results out of order at %d: %v, %v", i, prev, cur)
+		}
+
+		// reversing the query flips the direction of every field
+		var reversed []ItemTest
+		ok(t, store.Find(&reversed, bolthold.Where("ID").In(8, 3, 13).SortBy("Category").SortByDesc("Name").
+			Reverse()))
+		for i := range reversed {
+			equals(t, result[len(result)-1-i].Key, reversed[i].Key)
+		}
+
+		result = nil
+		ok(t, store.Find(&result, bolthold.Where("Category").Eq("animal").SortByDesc("Name")))
+		equals(t, []int{16, 2, 13, 8, 14, 5, 9}, resultKeys(result))
 	})
 }
 
@@ -311,3 +362,175 @@ func TestIssue139SortOnSequenceKey(t *testing.T) {
 
 	})
 }
+
+type ScoreRecord struct {
+	ID    int `boltholdKey:"ID"`
+	Score int
+	Group string
+}
+
+func TestSortedLimitAndSpill(t *testing.T) {
+	filename := tempfile()
+	spilled, err := bolthold.Open(filename, 0666, &bolthold.Options{SortBufferSize: 7})
+	ok(t, err)
+	defer os.Remove(filename)
+	defer spilled.Close()
+
+	tempFiles := func() int {
+		files, err := filepath.Glob(filepath.Join(os.TempDir(), "bolthold-sort-*"))
+		ok(t, err)
+		return len(files)
+	}
+	before := tempFiles()
+
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		var records []ScoreRecord
+		for i := 0; i < 100; i++ {
+			records = append(records, ScoreRecord{ID: i, Score: (i * 37) % 23, Group: fmt.Sprintf("g%d", i%3)})
+		}
+		for i := range records {
+			ok(t, store.Insert(records[i].ID, &records[i]))
+			ok(t, spilled.Insert(records[i].ID, &records[i]))
+		}
+
+		// every record, sorted by score with the highest first, then by key
+		want := append([]ScoreRecord{}, records...)
+		sort.SliceStable(want, func(i, j int) bool { return want[i].Score > want[j].Score })
+
+		ids := func(result []ScoreRecord) []int {
+			keys := []int{}
+			for i := range result {
+				keys = append(keys, result[i].ID)
+			}
+			return keys
+		}
+
+		tests := []struct {
+			name  string
+			query *bolthold.Query
+			want  []ScoreRecord
+		}{
+			{"All", bolthold.Where("ID").Ge(0).SortByDesc("Score"), want},
+			{"Limit", bolthold.Where("ID").Ge(0).SortByDesc("Score").Limit(10), want[:10]},
+			{"Skip and Limit", bolthold.Where("ID").Ge(0).SortByDesc("Score").Skip(45).Limit(10), want[45:55]},
+			{"Skip", bolthold.Where("ID").Ge(0).SortByDesc("Score").Skip(90), want[90:]},
+			{"Skip Past End", bolthold.Where("ID").Ge(0).SortByDesc("Score").Skip(100).Limit(5), []ScoreRecord{}},
+			{"Before", bolthold.Where("ID").Ge(0).SortByDesc("Score").Before(want[50].ID).Limit(5), want[45:50]},
+			{"Before with Skip", bolthold.Where("ID").Ge(0).SortByDesc("Score").Before(want[50].ID).Skip(10),
+				want[:40]},
+			{"Or", bolthold.Where("Group").Eq("g0").Or(bolthold.Where("Group").Ne("g0")).SortByDesc("Score").
+				Skip(3).Limit(20), want[3:23]},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				for _, s := range []*bolthold.Store{store, spilled} {
+					var result []ScoreRecord
+					ok(t, s.Find(&result, tst.query))
+					equals(t, ids(tst.want), ids(result))
+				}
+			})
+		}
+
+		// records spilled by an iterator that's closed early are removed
+		ok(t, spilled.Bolt().View(func(tx *bolt.Tx) error {
+			iter := spilled.Iter(tx, bolthold.Where("ID").Ge(0).SortByDesc("Score"), &ScoreRecord{})
+			assert(t, iter.Next(), "Iterator has no records")
+			return iter.Close()
+		}))
+		equals(t, before, tempFiles())
+	})
+}
+
+type IndexedScore struct {
+	ID    int    `boltholdKey:"ID"`
+	Score int    `boltholdIndex:"Score"`
+	Group string `boltholdIndex:"Group"`
+}
+
+func TestSortFromIndex(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		var records []IndexedScore
+		for i := 0; i < 50; i++ {
+			records = append(records, IndexedScore{ID: i, Score: (i * 37) % 23, Group: fmt.Sprintf("g%d", i%3)})
+			ok(t, store.Insert(records[i].ID, &records[i]))
+		}
+
+		// sorted expects the records, which are in key order, sorted by score and then key
+		sorted := func(filter func(r IndexedScore) bool, desc, reverse bool) []int {
+			var want []IndexedScore
+			for i := range records {
+				if filter(records[i]) {
+					want = append(want, records[i])
+				}
+			}
+			sort.SliceStable(want, func(i, j int) bool {
+				if desc {
+					return want[i].Score > want[j].Score
+				}
+				return want[i].Score < want[j].Score
+			})
+			ids := []int{}
+			for i := range want {
+				ids = append(ids, want[i].ID)
+			}
+			if reverse {
+				for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+					ids[i], ids[j] = ids[j], ids[i]
+				}
+			}
+			return ids
+		}
+		all := func(r IndexedScore) bool { return true }
+		between := func(r IndexedScore) bool { return r.Score >= 5 && r.Score < 15 }
+		early := func(r IndexedScore) bool { return r.ID < 20 }
+
+		tests := []struct {
+			name  string
+			query *bolthold.Query
+			want  []int
+		}{
+			{"Ascending", bolthold.Where("ID").Ge(0).SortBy("Score"), sorted(all, false, false)},
+			{"Reverse", bolthold.Where("ID").Ge(0).SortBy("Score").Reverse(), sorted(all, false, true)},
+			{"Descending", bolthold.Where("ID").Ge(0).SortByDesc("Score"), sorted(all, true, false)},
+			{"Descending Reverse", bolthold.Where("ID").Ge(0).SortByDesc("Score").Reverse(),
+				sorted(all, true, true)},
+			{"Range", bolthold.Where("Score").Ge(5).And("Score").Lt(15).SortBy("Score"),
+				sorted(between, false, false)},
+			{"Range Reverse", bolthold.Where("Score").Ge(5).And("Score").Lt(15).SortBy("Score").Reverse(),
+				sorted(between, false, true)},
+			{"Other Field", bolthold.Where("ID").Lt(20).SortBy("Score").Reverse(), sorted(early, false, true)},
+			{"Limit", bolthold.Where("ID").Ge(0).SortBy("Score").Reverse().Limit(5), sorted(all, false, true)[:5]},
+			{"Skip and Limit", bolthold.Where("Score").Ge(5).And("Score").Lt(15).SortByDesc("Score").Skip(3).
+				Limit(4), sorted(between, true, false)[3:7]},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				var result []IndexedScore
+				ok(t, store.Find(&result, tst.query))
+				ids := []int{}
+				for i := range result {
+					ids = append(ids, result[i].ID)
+				}
+				equals(t, tst.want, ids)
+
+				plan, err := store.Explain(&IndexedScore{}, tst.query)
+				ok(t, err)
+				equals(t, "Score", plan.Index)
+				assert(t, !plan.Sorted, "Query sorted by an indexed field was sorted in memory")
+			})
+		}
+
+		// latest N stops reading once the limit is reached
+		plan, err := store.Profile(&IndexedScore{}, bolthold.Where("ID").Ge(0).SortBy("Score").Reverse().Limit(3))
+		ok(t, err)
+		equals(t, 3, plan.Scanned)
+
+		// an index picked from the query's criteria isn't replaced by the sort field's index
+		plan, err = store.Explain(&IndexedScore{}, bolthold.Where("Group").Eq("g1").SortBy("Score"))
+		ok(t, err)
+		equals(t, "Group", plan.Index)
+		assert(t, plan.Sorted, "Query read from another index wasn't sorted in memory")
+	})
+}
@@ -5,19 +5,33 @@
 package bolthold
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	bolt "go.etcd.io/bbolt"
 )
 
 // Store is a bolthold wrapper around a bolt DB
 type Store struct {
-	db     *bolt.DB
-	encode EncodeFunc
-	decode DecodeFunc
+	db         *bolt.DB
+	encode     EncodeFunc
+	decode     DecodeFunc
+	sortBuffer int
+
+	numericPromotion bool
+
+	comparers     atomic.Value // map[reflect.Type]CompareFunc, replaced rather than modified on each registration
+	comparersLock sync.Mutex   // serializes registrations
+
+	keptPointers     map[reflect.Type]bool // whether the encoder keeps pointers to empty values of each type
+	keptPointersLock sync.RWMutex
 }
 
 // Options allows you set different options from the defaults
@@ -25,6 +39,15 @@ type Store struct {
 type Options struct {
 	Encoder EncodeFunc
 	Decoder DecodeFunc
+	// SortBufferSize is the number of records a sorted query without a limit holds in memory.  Once there are more,
+	// the records are sorted in runs written to a temporary file, and merged as they're read.  Zero, the default,
+	// sorts every record in memory
+	SortBufferSize int
+	// NumericPromotion compares numbers of different types, such as an int field and a float64 criterion value, by
+	// their value instead of returning an ErrTypeMismatch.  It applies to criteria, SortBy and the Min and Max of
+	// aggregate results, and compares every combination of ints, uints, floats, big.Ints, big.Floats and big.Rats
+	// exactly
+	NumericPromotion bool
 	*bolt.Options
 }
 
@@ -38,9 +61,12 @@ func Open(filename string, mode os.FileMode, options *Options) (*Store, error) {
 	}
 
 	return &Store{
-		db:     db,
-		encode: options.Encoder,
-		decode: options.Decoder,
+		db:         db,
+		encode:     options.Encoder,
+		decode:     options.Decoder,
+		sortBuffer: options.SortBufferSize,
+
+		numericPromotion: options.NumericPromotion,
 	}, nil
 }
 
@@ -75,6 +101,16 @@ func (s *Store) Close() error {
 // if bucketName is nil, then we'll assume a bucketName of storer.Type()
 // if a bucketname is specified, then the data will be copied to the bolthold standard bucket of storer.Type()
 func (s *Store) ReIndex(exampleType interface{}, bucketName []byte) error {
+	return s.reIndex(context.Background(), exampleType, bucketName)
+}
+
+// ReIndexContext is the same as ReIndex, but stops and rolls back the changes with the context's error if the context
+// is cancelled
+func (s *Store) ReIndexContext(ctx context.Context, exampleType interface{}, bucketName []byte) error {
+	return s.reIndex(ctx, exampleType, bucketName)
+}
+
+func (s *Store) reIndex(ctx context.Context, exampleType interface{}, bucketName []byte) error {
 	storer := s.newStorer(exampleType)
 
 	return s.Bolt().Update(func(tx *bolt.Tx) error {
@@ -115,6 +151,10 @@ func (s *Store) ReIndex(exampleType interface{}, bucketName []byte) error {
 		c := bucket.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
 			if copyData {
 				b, err := tx.CreateBucketIfNotExists([]byte(storer.Type()))
 				if err != nil {
@@ -161,6 +201,32 @@ type anonStorer struct {
 	rType        reflect.Type
 	indexes      map[string]Index
 	sliceIndexes map[string]SliceIndex
+	composites   map[string]*compositeIndex
+	legacyTags   map[string]string // the tag of each index older versions of bolthold could have written
+}
+
+// compositeIndex is an index made up of more than one field, defined by a tag value of "IndexName,Position" on each
+// of the fields
+type compositeIndex struct {
+	unique    bool
+	fields    []string
+	positions []int
+}
+
+func (c *compositeIndex) add(indexName, field string, position int) {
+	i := sort.SearchInts(c.positions, position)
+	if i < len(c.positions) && c.positions[i] == position {
+		panic(fmt.Sprintf("The fields %s and %s are both at position %d in the composite index %s",
+			c.fields[i], field, position, indexName))
+	}
+
+	c.positions = append(c.positions, 0)
+	copy(c.positions[i+1:], c.positions[i:])
+	c.positions[i] = position
+
+	c.fields = append(c.fields, "")
+	copy(c.fields[i+1:], c.fields[i:])
+	c.fields[i] = field
 }
 
 // Type returns the name of the type as determined from the reflect package
@@ -198,6 +264,8 @@ func (s *Store) newStorer(dataType interface{}) Storer {
 		rType:        tp,
 		indexes:      make(map[string]Index),
 		sliceIndexes: make(map[string]SliceIndex),
+		composites:   make(map[string]*compositeIndex),
+		legacyTags:   make(map[string]string),
 	}
 
 	if storer.rType.Name() == "" {
@@ -212,6 +280,10 @@ func (s *Store) newStorer(dataType interface{}) Storer {
 		storer.addIndex(storer.rType.Field(i), s)
 	}
 
+	for name, composite := range storer.composites {
+		storer.addCompositeIndex(name, composite, s)
+	}
+
 	return storer
 }
 
@@ -228,38 +300,34 @@ func (t *anonStorer) addIndex(field reflect.StructField, store *Store) {
 	}
 
 	if strings.Contains(string(field.Tag), BoltholdIndexTag) {
-		indexName := field.Tag.Get(BoltholdIndexTag)
-
-		if indexName == "" {
-			indexName = field.Name
-		}
-
-		t.indexes[indexName] = Index{
-			IndexFunc: func(name string, value interface{}) ([]byte, error) {
-				val := findIndexValue(name, value, BoltholdIndexTag)
-				if val == nil {
-					return nil, nil
-				}
-				return store.encode(val)
-			},
-			Unique: false,
+		indexName, position := parseIndexTag(field, BoltholdIndexTag)
+
+		if position >= 0 {
+			t.addCompositeField(indexName, field.Name, position, false)
+		} else {
+			t.indexes[indexName] = Index{
+				IndexFunc: func(name string, value interface{}) ([]byte, error) {
+					return store.encodeIndexKey(store.storedValue(findIndexValue(name, value, BoltholdIndexTag)))
+				},
+				Unique: false,
+				Fields: []string{field.Name},
+			}
+			t.legacyTags[indexName] = BoltholdIndexTag
 		}
 	} else if strings.Contains(string(field.Tag), BoltholdUniqueTag) {
-		indexName := field.Tag.Get(BoltholdUniqueTag)
-
-		if indexName == "" {
-			indexName = field.Name
-		}
-
-		t.indexes[indexName] = Index{
-			IndexFunc: func(name string, value interface{}) ([]byte, error) {
-				val := findIndexValue(name, value, BoltholdUniqueTag)
-				if val == nil {
-					return nil, nil
-				}
-				return store.encode(val)
-			},
-			Unique: true,
+		indexName, position := parseIndexTag(field, BoltholdUniqueTag)
+
+		if position >= 0 {
+			t.addCompositeField(indexName, field.Name, position, true)
+		} else {
+			t.indexes[indexName] = Index{
+				IndexFunc: func(name string, value interface{}) ([]byte, error) {
+					return store.encodeIndexKey(store.storedValue(findIndexValue(name, value, BoltholdUniqueTag)))
+				},
+				Unique: true,
+				Fields: []string{field.Name},
+			}
+			t.legacyTags[indexName] = BoltholdUniqueTag
 		}
 	}
 	if strings.Contains(string(field.Tag), BoltholdSliceIndexTag) {
@@ -269,6 +337,7 @@ func (t *anonStorer) addIndex(field reflect.StructField, store *Store) {
 			indexName = field.Name
 		}
 
+		t.legacyTags[indexName] = BoltholdSliceIndexTag
 		t.sliceIndexes[indexName] = func(name string, value interface{}) ([][]byte, error) {
 			val := reflect.ValueOf(value)
 			for val.Kind() == reflect.Ptr {
@@ -291,7 +360,7 @@ func (t *anonStorer) addIndex(field reflect.StructField, store *Store) {
 			indexValue := make(keyList, 0)
 
 			for i := 0; i < fld.Len(); i++ {
-				b, err := store.encode(fld.Index(i).Interface())
+				b, err := store.encodeIndexKey(fld.Index(i).Interface())
 				if err != nil {
 					return nil, err
 				}
@@ -301,6 +370,179 @@ func (t *anonStorer) addIndex(field reflect.StructField, store *Store) {
 			return indexValue, nil
 		}
 	}
+	if strings.Contains(string(field.Tag), BoltholdMapIndexTag) {
+		t.addMapIndexes(field, store)
+	}
+}
+
+// addMapIndexes adds an index for each of the map keys listed in the map index tag on the field
+func (t *anonStorer) addMapIndexes(field reflect.StructField, store *Store) {
+	mapType := field.Type
+	for mapType.Kind() == reflect.Ptr {
+		mapType = mapType.Elem()
+	}
+
+	if mapType.Kind() != reflect.Map {
+		panic(fmt.Sprintf("The %s tag can't be used on the field %s, which is a %s and not a map",
+			BoltholdMapIndexTag, field.Name, field.Type))
+	}
+
+	for _, key := range strings.Split(field.Tag.Get(BoltholdMapIndexTag), ",") {
+		key = strings.TrimSpace(key)
+		if key == "" || strings.ContainsAny(key, "[]") {
+			panic(fmt.Sprintf("Invalid map key %q in the %s tag on the field %s", key, BoltholdMapIndexTag,
+				field.Name))
+		}
+
+		_, err := mapKey(mapType.Key(), key)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid map key in the %s tag on the field %s: %s", BoltholdMapIndexTag,
+				field.Name, err))
+		}
+
+		path := field.Name + "[" + key + "]"
+		t.indexes[path] = Index{
+			IndexFunc: func(name string, value interface{}) ([]byte, error) {
+				entry, err := fieldValue(reflect.ValueOf(value), path)
+				if err != nil {
+					return nil, err
+				}
+				if _, ok := entry.(reflect.Value); ok {
+					// the map is in a nil embedded struct pointer
+					return nil, nil
+				}
+				return store.encodeIndexKey(entry)
+			},
+			Unique: false,
+			Fields: []string{path},
+		}
+	}
+}
+
+// legacyIndexKeys returns the keys of the value in an index written by an older version of bolthold, which encoded
+// the field's value, or each element of a slice index, with the store's encoder.  ok is false if older versions
+// couldn't have written the index
+func (t *anonStorer) legacyIndexKeys(store *Store, indexName string, value interface{}) (keys [][]byte, ok bool,
+	err error) {
+	tag, ok := t.legacyTags[indexName]
+	if !ok {
+		return nil, false, nil
+	}
+
+	fldValue := findIndexValue(indexName, value, tag)
+	if fldValue == nil {
+		return nil, true, nil
+	}
+
+	if tag != BoltholdSliceIndexTag {
+		key, err := store.encode(fldValue)
+		return [][]byte{key}, true, err
+	}
+
+	fld := reflect.ValueOf(fldValue)
+	if fld.Kind() != reflect.Slice {
+		return nil, true, fmt.Errorf("Type %s is not a slice", fld.Type())
+	}
+
+	for i := 0; i < fld.Len(); i++ {
+		key, err := store.encode(fld.Index(i).Interface())
+		if err != nil {
+			return nil, true, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, true, nil
+}
+
+// parseIndexTag returns the index name from the tag on the field, and if the tag is in the form of
+// "IndexName,Position", the position of the field in a composite index.  The position is -1 if the field isn't
+// part of a composite index
+func parseIndexTag(field reflect.StructField, tag string) (string, int) {
+	indexName := field.Tag.Get(tag)
+	position := -1
+
+	if i := strings.Index(indexName, ","); i >= 0 {
+		var err error
+		position, err = strconv.Atoi(strings.TrimSpace(indexName[i+1:]))
+		if err != nil || position < 0 {
+			panic(fmt.Sprintf("Invalid position in the %s tag on the field %s.  Composite indexes are "+
+				"specified as \"IndexName,Position\"", tag, field.Name))
+		}
+		indexName = indexName[:i]
+	}
+
+	if indexName == "" {
+		indexName = field.Name
+	}
+
+	return indexName, position
+}
+
+func (t *anonStorer) addCompositeField(indexName, fieldName string, position int, unique bool) {
+	composite, ok := t.composites[indexName]
+	if !ok {
+		composite = &compositeIndex{}
+		t.composites[indexName] = composite
+	}
+
+	composite.unique = composite.unique || unique
+	composite.add(indexName, fieldName, position)
+}
+
+func (t *anonStorer) addCompositeIndex(indexName string, composite *compositeIndex, store *Store) {
+	fields := composite.fields
+
+	t.indexes[indexName] = Index{
+		IndexFunc: func(name string, value interface{}) ([]byte, error) {
+			values := make([]interface{}, len(fields))
+			for i := range fields {
+				values[i] = store.storedValue(compositeFieldValue(value, fields[i]))
+			}
+			return store.EncodeIndexKey(values...)
+		},
+		Unique: composite.unique,
+		Fields: fields,
+	}
+}
+
+// compositeFieldValue returns the value of the named field, or nil if the field is in a nil embedded struct pointer
+func compositeFieldValue(value interface{}, field string) interface{} {
+	val := reflect.ValueOf(value)
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+
+	f, ok := val.Type().FieldByName(field)
+	if !ok {
+		return nil
+	}
+
+	for _, index := range f.Index {
+		if val.Kind() == reflect.Ptr {
+			if val.IsNil() {
+				return nil
+			}
+			val = val.Elem()
+		}
+		val = val.Field(index)
+	}
+
+	return val.Interface()
+}
+
+// keyField returns the field of the struct type tagged with boltholdKey, if there is one.  The tag is looked up the
+// same way Insert looks it up, so it must be in the standard key:"value" format
+func keyField(tp reflect.Type) (reflect.StructField, bool) {
+	for i := 0; i < tp.NumField(); i++ {
+		if _, ok := tp.Field(i).Tag.Lookup(BoltholdKeyTag); ok {
+			return tp.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
 }
 
 // returns the value in the field with the matching indexStruct tag
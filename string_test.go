@@ -0,0 +1,113 @@
+// Copyright 2016 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package bolthold_test
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/timshannon/bolthold"
+)
+
+func TestStringCriteria(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		tests := []struct {
+			name  string
+			query *bolthold.Query
+			match func(item *ItemTest) bool
+		}{
+			{"HasPrefix",
+				bolthold.Where("Name").HasPrefix("ca"),
+				func(item *ItemTest) bool { return strings.HasPrefix(item.Name, "ca") }},
+			{"HasPrefix Index",
+				bolthold.Where("Category").HasPrefix("ve"),
+				func(item *ItemTest) bool { return strings.HasPrefix(item.Category, "ve") }},
+			{"HasPrefix Empty",
+				bolthold.Where("Category").HasPrefix(""),
+				func(item *ItemTest) bool { return true }},
+			{"Not HasPrefix",
+				bolthold.Where("Category").Not().HasPrefix("ve"),
+				func(item *ItemTest) bool { return !strings.HasPrefix(item.Category, "ve") }},
+			{"HasSuffix",
+				bolthold.Where("Name").HasSuffix("e"),
+				func(item *ItemTest) bool { return strings.HasSuffix(item.Name, "e") }},
+			{"ContainsString",
+				bolthold.Where("Name").ContainsString("an"),
+				func(item *ItemTest) bool { return strings.Contains(item.Name, "an") }},
+			{"EqFold",
+				bolthold.Where("Category").EqFold("FOOD"),
+				func(item *ItemTest) bool { return item.Category == "food" }},
+			{"Combined",
+				bolthold.Where("Category").HasPrefix("an").And("Name").Not().ContainsString("o"),
+				func(item *ItemTest) bool {
+					return strings.HasPrefix(item.Category, "an") && !strings.Contains(item.Name, "o")
+				}},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				var result []ItemTest
+				ok(t, store.Find(&result, tst.query))
+
+				keys := []int{}
+				for i := range result {
+					keys = append(keys, result[i].Key)
+				}
+				// records read through an index are in index order
+				sort.Ints(keys)
+				want := matchingKeys(tst.match)
+				assert(t, len(want) != 0, "No test data matches %s", tst.query)
+				equals(t, want, keys)
+
+				parsed, err := bolthold.ParseQuery(tst.query.String())
+				ok(t, err)
+				equals(t, tst.query.String(), parsed.String())
+
+				data, err := json.Marshal(tst.query)
+				ok(t, err)
+				unmarshaled := &bolthold.Query{}
+				ok(t, json.Unmarshal(data, unmarshaled))
+				equals(t, tst.query.String(), unmarshaled.String())
+
+				ok(t, tst.query.Validate(&ItemTest{}))
+			})
+		}
+
+		err := bolthold.Where("ID").HasPrefix("1").Validate(&ItemTest{})
+		assert(t, err != nil, "No error validating HasPrefix on an int field")
+
+		err = json.Unmarshal([]byte(`{"criteria":[{"field":"Name","op":"EqFold","value":{"type":"int","value":1}}]}`),
+			&bolthold.Query{})
+		assert(t, err != nil, "No error unmarshaling EqFold with an int value")
+	})
+}
+
+func TestHasPrefixIndex(t *testing.T) {
+	testWrap(t, func(store *bolthold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		query := bolthold.Where("Category").HasPrefix("foo")
+		plan, err := store.Profile(&ItemTest{}, query)
+		ok(t, err)
+		equals(t, "Category", plan.Index)
+		assert(t, plan.Selected, "HasPrefix index wasn't picked automatically")
+		assert(t, !plan.Scan, "HasPrefix query scanned every record")
+		assert(t, plan.Seek != nil, "HasPrefix query didn't seek the index")
+
+		food := len(matchingKeys(func(item *ItemTest) bool { return item.Category == "food" }))
+		equals(t, food, plan.Scanned)
+		equals(t, food, plan.Matched)
+
+		parsed, err := bolthold.ParseQuery(`Name HAS PREFIX "c" AND Category EQFOLD 'Vehicle' AND ` +
+			`Name NOT CONTAINS STRING "x" AND Name HAS SUFFIX 'r'`)
+		ok(t, err)
+		equals(t, bolthold.Where("Category").EqFold("Vehicle").And("Name").HasPrefix("c").
+			And("Name").Not().ContainsString("x").And("Name").HasSuffix("r").String(), parsed.String())
+	})
+}